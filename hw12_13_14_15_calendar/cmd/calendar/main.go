@@ -7,6 +7,8 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	// The time zones of the imported calendars are embedded for the images without tzdata.
+	_ "time/tzdata"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/cmd"
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/app"
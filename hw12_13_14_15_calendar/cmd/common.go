@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/app"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/queue"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/rmq"
 	memorystorage "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/storage/memory"
 	sqlstorage "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/storage/sql"
 	"github.com/sirupsen/logrus"
@@ -27,6 +30,85 @@ type StorageConf struct {
 type RabbitConf struct {
 	TTL int64  `json:"ttl"`
 	Dsn string `json:"dsn"`
+	// MaxRetries of a failed notification before it is dead-lettered.
+	MaxRetries int `json:"maxRetries"`
+	// RetryDelay of the first retry in milliseconds, every next one is twice as long.
+	RetryDelay int64 `json:"retryDelay"`
+	Prefetch   int   `json:"prefetch"`
+	// ReconnectDelay of the first reconnection attempt in milliseconds, every next one is twice as long.
+	ReconnectDelay int64 `json:"reconnectDelay"`
+}
+
+func (c RabbitConf) Options() rmq.Options {
+	return rmq.Options{
+		TTL:            c.TTL,
+		MaxRetries:     c.MaxRetries,
+		RetryDelay:     time.Duration(c.RetryDelay) * time.Millisecond,
+		Prefetch:       c.Prefetch,
+		ReconnectDelay: time.Duration(c.ReconnectDelay) * time.Millisecond,
+	}
+}
+
+// QueueConf selects the queue carrying the notifications from the scheduler to the sender.
+type QueueConf struct {
+	// Type is amqp (the default) configured by RabbitConf, postgres sharing the database of StorageConf
+	// or memory delivering within the process only.
+	Type string `json:"type"`
+	// MaxRetries of a failed notification before it is dead-lettered, amqp has its own one in RabbitConf.
+	MaxRetries int `json:"maxRetries"`
+	// RetryDelay of the first retry in milliseconds, every next one is twice as long.
+	RetryDelay int64 `json:"retryDelay"`
+	// Batch of the notifications a postgres consumer locks at once.
+	Batch int `json:"batch"`
+	// PollInterval of an empty postgres queue in milliseconds.
+	PollInterval int64 `json:"pollInterval"`
+}
+
+const (
+	QueueAMQP     = "amqp"
+	QueuePostgres = "postgres"
+	QueueMemory   = "memory"
+)
+
+func (c QueueConf) Options() queue.Options {
+	return queue.Options{
+		MaxRetries:   c.MaxRetries,
+		RetryDelay:   time.Duration(c.RetryDelay) * time.Millisecond,
+		Batch:        c.Batch,
+		PollInterval: time.Duration(c.PollInterval) * time.Millisecond,
+	}
+}
+
+// GetQueue connects to the queue selected by conf.
+func GetQueue(ctx context.Context, log *logrus.Logger, conf QueueConf, rabbit RabbitConf, storage StorageConf) (queue.Queue, error) {
+	switch conf.Type {
+	case "", QueueAMQP:
+		if rabbitDsn := os.Getenv("RABBIT_DSN"); rabbitDsn != "" {
+			rabbit.Dsn = rabbitDsn
+		}
+		return rmq.GetRMQConnectionAndDeclare(log, rabbit.Dsn, rabbit.Options())
+	case QueuePostgres:
+		return queue.NewPostgres(ctx, log, storage.DSN(), conf.Options())
+	case QueueMemory:
+		log.Warn("the memory queue delivers the notifications published by this process only")
+		return queue.NewMemory(log, 0, conf.Options()), nil
+	default:
+		return nil, fmt.Errorf("unknown queue type %q", conf.Type)
+	}
+}
+
+// DSN returns the connection string of the database, the host may be overridden by PG_HOST.
+func (c StorageConf) DSN() string {
+	if host := os.Getenv("PG_HOST"); host != "" {
+		c.Host = host
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		c.Host,
+		c.Port,
+		os.Getenv("PG_USER"),
+		os.Getenv("PG_PASSWORD"),
+		c.Database,
+		c.Ssl)
 }
 
 func GetStorage(ctx context.Context, log *logrus.Logger, conf StorageConf) (app.Storage, error) {
@@ -34,18 +116,8 @@ func GetStorage(ctx context.Context, log *logrus.Logger, conf StorageConf) (app.
 		storage app.Storage
 		err     error
 	)
-	if host := os.Getenv("PG_HOST"); host != "" {
-		conf.Host = host
-	}
 	if conf.Remote {
-		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-			conf.Host,
-			conf.Port,
-			os.Getenv("PG_USER"),
-			os.Getenv("PG_PASSWORD"),
-			conf.Database,
-			conf.Ssl)
-		storage, err = sqlstorage.New(ctx, log, dsn)
+		storage, err = sqlstorage.New(ctx, log, conf.DSN())
 		if err != nil {
 			return nil, err
 		}
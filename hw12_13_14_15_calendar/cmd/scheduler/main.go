@@ -10,7 +10,7 @@ import (
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/cmd"
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/logger"
-	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/rmq"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/scheduler"
 	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
@@ -25,22 +25,32 @@ func main() {
 	config := NewConfig(configFile)
 	log := logger.New(config.Logger.Level, config.Logger.Path)
 
-	if rabbitDsn := os.Getenv("RABBIT_DSN"); rabbitDsn != "" {
-		config.Rabbit.Dsn = rabbitDsn
-	}
-	rabbit, err := rmq.GetRMQConnectionAndDeclare(log, config.Rabbit.Dsn, config.Rabbit.TTL)
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications, err := cmd.GetQueue(ctx, log, config.Queue, config.Rabbit, config.Storage)
 	if err != nil {
-		log.Fatalf("failed to connect to rmq and declare topic: %s", err)
+		log.Fatalf("failed to connect to the queue of notifications: %s", err)
 	}
-	ctx, cancel := context.WithCancel(context.Background())
 
 	storage, err := cmd.GetStorage(ctx, log, config.Storage)
 	if err != nil {
 		log.Fatalf("failed to connect to database: %s", err)
 	}
 
-	scheduler := time.NewTicker(time.Duration(config.Scheduler.Period))
-	defer scheduler.Stop()
+	if cleanup := config.Scheduler.Cleanup; cleanup.Enabled {
+		schedule, err := scheduler.ParseSchedule(cleanup.Schedule)
+		if err != nil {
+			log.Fatalf("failed to parse cleanup schedule %q: %s", cleanup.Schedule, err)
+		}
+		cleaner := scheduler.NewCleaner(log, storage, time.Duration(cleanup.Retention), cleanup.Batch, cleanup.DryRun)
+		go cleaner.Run(ctx, schedule)
+	}
+
+	relay := scheduler.NewRelay(log, storage, notifications, config.Scheduler.Relay.Batch)
+	go relay.Run(ctx, time.Duration(config.Scheduler.Relay.Period))
+
+	notifier := scheduler.NewNotifier(log, storage)
+	ticker := time.NewTicker(time.Duration(config.Scheduler.Period))
+	defer ticker.Stop()
 
 	sigCh := make(chan os.Signal, 1)
 	go func() {
@@ -53,9 +63,9 @@ func main() {
 		log.Info("terminated by syscall...")
 		signal.Stop(sigCh)
 		cancel()
-		scheduler.Stop()
-		if err = rabbit.Close(); err != nil {
-			log.Warn("failed to disconnect from rabbit properly: ", err)
+		ticker.Stop()
+		if err = notifications.Close(); err != nil {
+			log.Warn("failed to disconnect from the queue properly: ", err)
 		}
 	}()
 
@@ -64,12 +74,10 @@ func main() {
 			select {
 			case <-ctx.Done():
 				return
-			case <-scheduler.C:
-				events, err := storage.ListEventsToNotify(ctx)
-				if err != nil {
-					log.Warn("failed to retrieve events for notification: ", err)
+			case now := <-ticker.C:
+				if _, err := notifier.Notify(ctx, now); err != nil {
+					log.Warn("failed to notify on events: ", err)
 				}
-				rabbit.Notify(events)
 			}
 		}
 	}()
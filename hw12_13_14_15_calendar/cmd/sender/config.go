@@ -8,20 +8,26 @@ import (
 	"path/filepath"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/cmd"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/dedup"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/notifier"
 )
 
 type Config struct {
 	Logger cmd.LoggerConf
 	Rabbit cmd.RabbitConf
 	Sender SenderConfig
+	Queue  cmd.QueueConf
+	// Storage is the database of the postgres queue.
+	Storage cmd.StorageConf
 }
 
 type SenderConfig struct {
-	SenderParam1 string `json:"sender_param_1"`
-	SenderParam2 string `json:"sender_param_2"`
-	SenderParam3 string `json:"sender_param_3"`
-	SenderParam4 string `json:"sender_param_4"`
-	SenderParam5 string `json:"sender_param_5"`
+	// Channels are notified of every notification.
+	Channels []notifier.ChannelConf `json:"channels"`
+	// Dedup drops the notifications delivered already.
+	Dedup dedup.Conf `json:"dedup"`
+	// Templates render the notifications, they are reloaded on SIGHUP.
+	Templates notifier.TemplatesConf `json:"templates"`
 }
 
 func NewConfig(path string) Config {
@@ -49,5 +55,6 @@ func defaultConfig() Config {
 	return Config{
 		Logger: cmd.LoggerConf{Level: "Debug", Path: "stdout"},
 		Rabbit: cmd.RabbitConf{Dsn: dsn},
+		Sender: SenderConfig{Channels: []notifier.ChannelConf{{Name: "log", Type: "log"}}},
 	}
 }
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"flag"
-	"net/http"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	// The time zones of the templates are embedded for the images without tzdata.
+	_ "time/tzdata"
 
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/cmd"
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/dedup"
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/logger"
-	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/rmq"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/notifier"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/queue"
+	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/sirupsen/logrus"
 )
 
@@ -28,73 +32,76 @@ func main() {
 	config := NewConfig(configFile)
 	log := logger.New(config.Logger.Level, config.Logger.Path)
 
-	if rabbitDsn := os.Getenv("RABBIT_DSN"); rabbitDsn != "" {
-		config.Rabbit.Dsn = rabbitDsn
+	templates, err := notifier.LoadTemplates(log, config.Sender.Templates)
+	if err != nil {
+		log.Fatalf("failed to load notification templates: %s", err)
 	}
-	rabbit, err := rmq.GetRMQConnectionAndDeclare(log, config.Rabbit.Dsn, config.Rabbit.TTL)
+	channels, err := notifier.NewMulti(log, config.Sender.Channels, templates)
 	if err != nil {
-		log.Fatalf("failed to connect to rmq and declare topic: %s", err)
+		log.Fatalf("failed to configure notification channels: %s", err)
 	}
-
 	ctx, cancel := context.WithCancel(context.Background())
+	store, err := dedup.NewStore(ctx, log, config.Sender.Dedup, config.Storage.DSN())
+	if err != nil {
+		log.Fatalf("failed to configure deduplication: %s", err)
+	}
+	// The deliveries are deduplicated per channel, so a retry skips the channels which succeeded.
+	var deduplicated *dedup.Channels
+	if store != nil {
+		deduplicated = dedup.NewChannels(log, store)
+		channels.Wrap(deduplicated.Wrap)
+	}
+	notifications, err := cmd.GetQueue(ctx, log, config.Queue, config.Rabbit, config.Storage)
+	if err != nil {
+		log.Fatalf("failed to connect to the queue of notifications: %s", err)
+	}
+
 	sigCh := make(chan os.Signal, 1)
 	go func() {
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGHUP)
-		select {
-		case <-ctx.Done():
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-sigCh:
+				if sig == syscall.SIGHUP {
+					if err := templates.Reload(); err != nil {
+						log.Warn("failed to reload notification templates: ", err)
+					}
+					continue
+				}
+			}
+			log.Info("terminated by syscall...")
+			signal.Stop(sigCh)
+			cancel()
 			return
-		case <-sigCh:
 		}
-		log.Info("terminated by syscall...")
-		signal.Stop(sigCh)
-		cancel()
 	}()
 
-	if err = rabbit.ConsumeAndSend(ctx, PrepareSender(log, config.Sender)); err != nil {
+	if err = notifications.Consume(ctx, PrepareSender(log, channels)); err != nil {
 		log.Fatal("failed to init consumer: ", err)
 	}
-	if err = rabbit.Close(); err != nil {
-		log.Warn("failed to disconnect from rabbit properly: ", err)
+	if deduplicated != nil {
+		log.Infof("suppressed %d duplicate notifications", deduplicated.Suppressed())
+	}
+	if err = notifications.Close(); err != nil {
+		log.Warn("failed to disconnect from the queue properly: ", err)
 	}
 }
 
-func PrepareSender(log *logrus.Logger, conf SenderConfig) func(context.Context, []byte) {
-	return func(ctx context.Context, body []byte) {
-		n := common.Notification{}
-		if err := json.Unmarshal(body, &n); err != nil {
+// PrepareSender returns the consumer of the messages which delivers them via the notifier.
+// Undecodable messages are poison, they are never retried.
+func PrepareSender(log *logrus.Logger, n notifier.Notifier) queue.Handler {
+	return func(ctx context.Context, body []byte) error {
+		notification := common.Notification{}
+		if err := json.Unmarshal(body, &notification); err != nil {
 			log.Warnf("failed to decode a message: %s", string(body))
+			return fmt.Errorf("%w: %s", queue.ErrPoison, err)
 		}
-		switch conf.SenderParam1 {
-		case "TEST":
-			log.Info("NOTIFICATION: ", n.String())
-			host := os.Getenv("NOTIFY_HOST")
-			if host == "" {
-				host = "http://172.17.0.1:3002"
-			} else {
-				host = "http://" + host + ":3002"
-			}
-			log.Warn("host: ", host)
-			c := &http.Client{Transport: http.DefaultTransport}
-			c.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
-			req, err := http.NewRequestWithContext(ctx, "POST", host+"/notify", bytes.NewReader(body))
-			if err != nil {
-				log.Warnf("err creating a notification POST request: %s", err)
-				return
-			}
-			r, err := c.Do(req)
-			if err != nil {
-				log.Warnf("err notifying: %s. err: %s", n.String(), err)
-				return
-			}
-			err = r.Body.Close()
-			if err != nil {
-				log.Warnf("err closing response body on a notification request: %s", err)
-				return
-			}
-		case "INFO":
-			log.Info("NOTIFICATION: ", n.String())
-		default:
-			log.Debug("NOTIFICATION: ", n.String())
+		if err := n.Notify(ctx, &notification); err != nil {
+			log.Warnf("err notifying: %s. err: %s", notification.String(), err)
+			return err
 		}
+		return nil
 	}
 }
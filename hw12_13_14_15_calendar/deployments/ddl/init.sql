@@ -1,12 +1,73 @@
 CREATE TABLE IF NOT EXISTS events
 (
-    id          serial primary key,
-    title       text      not null,
-    start_time  timestamp not null,
-    duration    integer   not null,
-    description text      not null,
-    owner       integer   not null,
-    notify_time integer   not null,
-    created     timestamp default now(),
-    updated     timestamp default now()
-);
\ No newline at end of file
+    id            serial      primary key,
+    title         text        not null,
+    start_time    timestamptz not null,
+    end_time      timestamptz not null,
+    time_zone     text        not null default '',
+    all_day       boolean     not null default false,
+    duration      integer     not null,
+    description   text        not null,
+    owner         integer     not null,
+    notify_time   integer     not null,
+    created       timestamptz default now(),
+    updated       timestamptz default now(),
+    version       integer     not null default 1,
+    notified_at   timestamptz,
+    rrule         text        not null default '',
+    exdates       text        not null default '',
+    parent_id     integer     not null default 0,
+    recurrence_id timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS events_owner_start_time_idx ON events (owner, start_time, id);
+CREATE INDEX IF NOT EXISTS events_owner_end_time_idx ON events (owner, end_time);
+CREATE INDEX IF NOT EXISTS events_parent_id_recurrence_id_idx ON events (parent_id, recurrence_id) WHERE parent_id != 0;
+
+CREATE TABLE IF NOT EXISTS event_reminders
+(
+    event_id integer not null references events (id) on delete cascade,
+    seconds  integer not null,
+    primary key (event_id, seconds)
+);
+
+CREATE TABLE IF NOT EXISTS notification_queue
+(
+    id           bigserial   primary key,
+    body         bytea       not null,
+    retries      integer     not null default 0,
+    available_at timestamptz not null default now(),
+    dead_at      timestamptz,
+    last_error   text        not null default ''
+);
+
+CREATE INDEX IF NOT EXISTS notification_queue_available_at_idx ON notification_queue (available_at) WHERE dead_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS notification_outbox
+(
+    id      bigserial   primary key,
+    key     text        not null unique,
+    payload jsonb       not null,
+    created timestamptz not null default now()
+);
+
+CREATE TABLE IF NOT EXISTS delivered_notifications
+(
+    key          text        primary key,
+    delivered_at timestamptz not null default now(),
+    delivered    boolean     not null default true
+);
+
+CREATE INDEX IF NOT EXISTS delivered_notifications_delivered_at_idx ON delivered_notifications (delivered_at);
+
+CREATE TABLE IF NOT EXISTS event_attendees
+(
+    event_id integer     not null references events (id) on delete cascade,
+    user_id  integer     not null,
+    status   text        not null default 'pending'
+        check (status IN ('pending', 'accepted', 'declined', 'tentative')),
+    updated  timestamptz not null default now(),
+    primary key (event_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS event_attendees_user_id_idx ON event_attendees (user_id);
@@ -2,12 +2,23 @@ package app
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// overlapHorizon limits how far ahead occurrences of a recurring event are checked for overlaps.
+const overlapHorizon = 365 * 24 * time.Hour
+
 type App struct {
 	log     *logrus.Logger
 	storage Storage
@@ -15,12 +26,43 @@ type App struct {
 
 type Storage interface {
 	CreateEvent(ctx context.Context, event *common.Event) (id int64, err error)
-	UpdateEvent(ctx context.Context, id int64, event *common.Event) (err error)
-	DeleteEvent(ctx context.Context, id int64) (err error)
-	ListEventsByDay(ctx context.Context, date time.Time) (events []common.Event, err error)
-	ListEventsByWeek(ctx context.Context, date time.Time) (events []common.Event, err error)
-	ListEventsByMonth(ctx context.Context, date time.Time) (events []common.Event, err error)
-	ListEventsToNotify(ctx context.Context) (events []common.Event, err error)
+	UpdateEvent(ctx context.Context, owner, id int64, event *common.Event) (err error)
+	// UpdateEvent and DeleteEvent fail with common.ErrVersionConflict if the expected version
+	// (event.Version for updates) is not zero and differs from the stored one.
+	DeleteEvent(ctx context.Context, owner, id, version int64) (err error)
+	GetEvent(ctx context.Context, owner, id int64) (event *common.Event, err error)
+	ListEventsByDay(ctx context.Context, owner int64, date time.Time) (events []common.Event, err error)
+	ListEventsByWeek(ctx context.Context, owner int64, date time.Time) (events []common.Event, err error)
+	ListEventsByMonth(ctx context.Context, owner int64, date time.Time) (events []common.Event, err error)
+	// FindOverlapping returns the events and occurrences of the owner overlapping [from, to).
+	FindOverlapping(ctx context.Context, owner int64, from, to time.Time) (events []common.Event, err error)
+	// ListEvents returns a page of filter.Owner's events overlapping [from, to) and the next page cursor.
+	ListEvents(ctx context.Context, from, to time.Time, filter common.EventFilter) (events []common.Event, next string, err error)
+	// ListEventsToNotify returns a notification per reminder due at now, see common.Event.DueReminders.
+	// Within a transaction the events are locked until it ends and skipped by concurrent transactions.
+	ListEventsToNotify(ctx context.Context, now time.Time) (notifications []common.Notification, err error)
+	// AddAttendees invites the users to the event of the owner, the users invited before keep their status.
+	AddAttendees(ctx context.Context, owner, id int64, users []int64) (err error)
+	// SetAttendeeStatus sets the status of the user's invitation to the event, common.ErrNotInvited if there is none.
+	SetAttendeeStatus(ctx context.Context, id, user int64, status common.AttendeeStatus) (err error)
+	// ListInvitedEvents returns the events and occurrences the user is invited to overlapping [from, to).
+	ListInvitedEvents(ctx context.Context, user int64, from, to time.Time) (events []common.Event, err error)
+	// MarkNotified sets NotifiedAt of the events, unknown IDs are ignored.
+	MarkNotified(ctx context.Context, ids []int64, at time.Time) (err error)
+	// EnqueueNotifications adds the notifications to the outbox, the ones with the keys already there are ignored.
+	EnqueueNotifications(ctx context.Context, notifications []common.Notification) (err error)
+	// ListOutbox returns up to limit notifications of the outbox in the order they were added.
+	// Within a transaction they are locked until it ends and skipped by concurrent transactions.
+	ListOutbox(ctx context.Context, limit int) (notifications []common.Notification, err error)
+	// DeleteFromOutbox removes the notifications with the keys from the outbox.
+	DeleteFromOutbox(ctx context.Context, keys []string) (err error)
+	// DeleteEventsBefore removes up to limit events (all of them if limit isn't positive) which
+	// ended before t, recurring series along with their overrides, and returns the number of removed rows.
+	DeleteEventsBefore(ctx context.Context, t time.Time, limit int) (n int64, err error)
+	// CountEventsBefore returns the number of rows DeleteEventsBefore would remove without a limit.
+	CountEventsBefore(ctx context.Context, t time.Time) (n int64, err error)
+	// WithTx runs fn atomically, storage calls made with the context passed to fn take part in it.
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 func New(log *logrus.Logger, storage Storage) *App {
@@ -28,25 +70,287 @@ func New(log *logrus.Logger, storage Storage) *App {
 }
 
 func (a *App) CreateEvent(ctx context.Context, event *common.Event) (id int64, err error) {
-	return a.storage.CreateEvent(ctx, event)
+	if event.Owner, err = ownerFrom(ctx); err != nil {
+		return 0, err
+	}
+	if err = validateEvent(event); err != nil {
+		return 0, err
+	}
+	event.Localize()
+	event.AlignAllDay()
+	err = a.storage.WithTx(ctx, func(ctx context.Context) error {
+		if err := a.checkParent(ctx, event); err != nil {
+			return err
+		}
+		if err := a.checkOverlaps(ctx, event); err != nil {
+			return err
+		}
+		id, err = a.storage.CreateEvent(ctx, event)
+		return err
+	})
+	return id, err
 }
 
 func (a *App) UpdateEvent(ctx context.Context, id int64, event *common.Event) (err error) {
-	return a.storage.UpdateEvent(ctx, id, event)
+	if event.Owner, err = ownerFrom(ctx); err != nil {
+		return err
+	}
+	event.Version = common.ExpectedVersion(ctx)
+	return a.storage.WithTx(ctx, func(ctx context.Context) error {
+		return a.update(ctx, id, event)
+	})
+}
+
+func (a *App) PatchEvent(ctx context.Context, id int64, patch *common.Event, fields []string) (event *common.Event, err error) {
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = a.storage.WithTx(ctx, func(ctx context.Context) error {
+		if event, err = a.storage.GetEvent(ctx, owner, id); err != nil {
+			return err
+		}
+		if expected := common.ExpectedVersion(ctx); expected != 0 && expected != event.Version {
+			return common.ErrVersionConflict
+		}
+		if err = event.Apply(patch, fields); err != nil {
+			return err
+		}
+		return a.update(ctx, id, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// update saves the event of its owner, it must run within a transaction.
+func (a *App) update(ctx context.Context, id int64, event *common.Event) error {
+	if err := validateEvent(event); err != nil {
+		return err
+	}
+	event.Localize()
+	event.AlignAllDay()
+	event.ID = id
+	if err := a.checkParent(ctx, event); err != nil {
+		return err
+	}
+	if err := a.checkOverlaps(ctx, event); err != nil {
+		return err
+	}
+	return a.storage.UpdateEvent(ctx, event.Owner, id, event)
 }
 
 func (a *App) DeleteEvent(ctx context.Context, id int64) (err error) {
-	return a.storage.DeleteEvent(ctx, id)
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return a.storage.DeleteEvent(ctx, owner, id, common.ExpectedVersion(ctx))
+}
+
+func (a *App) GetEvent(ctx context.Context, id int64) (*common.Event, error) {
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.storage.GetEvent(ctx, owner, id)
 }
 
 func (a *App) ListEventsByDay(ctx context.Context, date time.Time) (events []common.Event, err error) {
-	return a.storage.ListEventsByDay(ctx, date)
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.storage.ListEventsByDay(ctx, owner, date)
 }
 
 func (a *App) ListEventsByWeek(ctx context.Context, date time.Time) (events []common.Event, err error) {
-	return a.storage.ListEventsByWeek(ctx, date)
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.storage.ListEventsByWeek(ctx, owner, date)
 }
 
 func (a *App) ListEventsByMonth(ctx context.Context, date time.Time) (events []common.Event, err error) {
-	return a.storage.ListEventsByMonth(ctx, date)
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return a.storage.ListEventsByMonth(ctx, owner, date)
+}
+
+func (a *App) ListEvents(ctx context.Context, from, to time.Time, filter common.EventFilter) ([]common.Event, string, error) {
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	switch filter.Owner {
+	case 0:
+		filter.Owner = owner
+	case owner:
+	default:
+		return nil, "", common.ErrForbidden
+	}
+	if !to.After(from) {
+		return nil, "", common.ErrInvalidPeriod
+	}
+	switch {
+	case filter.Limit <= 0:
+		filter.Limit = DefaultPageSize
+	case filter.Limit > MaxPageSize:
+		filter.Limit = MaxPageSize
+	}
+	return a.storage.ListEvents(ctx, from, to, filter)
+}
+
+// InviteAttendees invites the users to the event of the owner of the request, the invitations to an occurrence
+// override go to its series. The users invited before keep their answers.
+func (a *App) InviteAttendees(ctx context.Context, id int64, users []int64) error {
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user <= 0 || user == owner {
+			return fmt.Errorf("%w: %d", common.ErrInvalidAttendee, user)
+		}
+	}
+	return a.storage.WithTx(ctx, func(ctx context.Context) error {
+		event, err := a.storage.GetEvent(ctx, owner, id)
+		if err != nil {
+			return err
+		}
+		return a.storage.AddAttendees(ctx, owner, event.InvitationID(), users)
+	})
+}
+
+// RespondToInvitation sets the answer of the user of the request to the invitation to the event.
+func (a *App) RespondToInvitation(ctx context.Context, id int64, status common.AttendeeStatus) error {
+	user, err := ownerFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if status == common.StatusPending {
+		return fmt.Errorf("%w: an answer can't be %s", common.ErrInvalidStatus, status)
+	}
+	if _, err = common.ParseAttendeeStatus(string(status)); err != nil {
+		return err
+	}
+	return a.storage.SetAttendeeStatus(ctx, id, user, status)
+}
+
+// ListInvitations returns the events and occurrences overlapping [from, to) the user of the request
+// is invited to ordered by start time, only the ones the user answered with status if it isn't empty.
+func (a *App) ListInvitations(ctx context.Context, from, to time.Time, status common.AttendeeStatus) ([]common.Event, error) {
+	user, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status != "" {
+		if _, err = common.ParseAttendeeStatus(string(status)); err != nil {
+			return nil, err
+		}
+	}
+	if !to.After(from) {
+		return nil, common.ErrInvalidPeriod
+	}
+	events, err := a.storage.ListInvitedEvents(ctx, user, from, to)
+	if err != nil {
+		return nil, err
+	}
+	matching := make([]common.Event, 0, len(events))
+	for _, event := range events {
+		if answer, _ := event.AttendeeStatus(user); status == "" || answer == status {
+			matching = append(matching, event)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool { return matching[i].StartTime.Before(matching[j].StartTime) })
+	return matching, nil
+}
+
+func ownerFrom(ctx context.Context) (int64, error) {
+	owner, ok := common.OwnerFromContext(ctx)
+	if !ok {
+		return 0, common.ErrNoOwner
+	}
+	return owner, nil
+}
+
+// checkParent returns a validation error unless the override replaces an occurrence of a recurring
+// series of its owner, it must run within a transaction.
+func (a *App) checkParent(ctx context.Context, event *common.Event) error {
+	if event.ParentID == 0 {
+		return nil
+	}
+	invalid := func(field, reason string) error {
+		return &common.ValidationError{Fields: []common.FieldError{{Field: field, Reason: reason, Err: common.ErrInvalidRRule}}}
+	}
+	parent, err := a.storage.GetEvent(ctx, event.Owner, event.ParentID)
+	if errors.Is(err, common.ErrNoSuchEvent) || errors.Is(err, common.ErrForbidden) {
+		return invalid(common.FieldParentID, "must be a recurring event of the owner")
+	}
+	if err != nil {
+		return err
+	}
+	if parent.RRule == "" {
+		return invalid(common.FieldParentID, "must be a recurring event of the owner")
+	}
+	recurrenceID := *event.RecurrenceID
+	occurrences, err := parent.Expand(recurrenceID, recurrenceID.Add(time.Nanosecond), nil)
+	if err != nil {
+		return err
+	}
+	if len(occurrences) == 0 {
+		return invalid(common.FieldRecurrenceID, "must be the start of an occurrence of the parent")
+	}
+	return nil
+}
+
+// checkOverlaps returns ErrDateBusy if the event takes the time of another event of the owner,
+// unless overlaps are allowed by the context. It must run within the transaction saving the event.
+func (a *App) checkOverlaps(ctx context.Context, event *common.Event) error {
+	if common.AllowOverlap(ctx) {
+		return nil
+	}
+	occurrences := []common.Event{*event}
+	if event.RRule != "" {
+		var err error
+		occurrences, err = event.Expand(event.StartTime, event.StartTime.Add(overlapHorizon), nil)
+		if err != nil {
+			return err
+		}
+		if len(occurrences) == 0 {
+			return nil
+		}
+	}
+	from, to := occurrences[0].StartTime, occurrences[len(occurrences)-1].End()
+	if !to.After(from) {
+		to = from.Add(time.Nanosecond)
+	}
+	busy, err := a.storage.FindOverlapping(ctx, event.Owner, from, to)
+	if err != nil {
+		return err
+	}
+	for _, other := range busy {
+		if replaces(event, &other) {
+			continue
+		}
+		for _, occurrence := range occurrences {
+			if other.Overlaps(occurrence.StartTime, occurrence.End()) {
+				return fmt.Errorf("%w: %q at %s", common.ErrDateBusy, other.Title, other.StartTime.Format(time.RFC3339))
+			}
+		}
+	}
+	return nil
+}
+
+// replaces reports whether other is the event itself or the occurrence the event overrides.
+func replaces(event, other *common.Event) bool {
+	if event.ID != 0 && other.ID == event.ID {
+		return true
+	}
+	return event.ParentID != 0 && other.ID == event.ParentID &&
+		other.RecurrenceID != nil && event.RecurrenceID != nil && other.RecurrenceID.Equal(*event.RecurrenceID)
 }
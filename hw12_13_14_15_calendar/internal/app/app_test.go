@@ -0,0 +1,360 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	memorystorage "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlaps(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	standupID, err := a.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: start, Duration: 1800, RRule: "FREQ=DAILY"})
+	require.NoError(t, err)
+
+	t.Run("single event", func(t *testing.T) {
+		_, err := a.CreateEvent(ctx, &common.Event{Title: "meeting", StartTime: start.AddDate(0, 0, 3).Add(15 * time.Minute), Duration: 3600})
+		require.ErrorIs(t, err, common.ErrDateBusy)
+		_, err = a.CreateEvent(ctx, &common.Event{Title: "meeting", StartTime: start.AddDate(0, 0, 3).Add(30 * time.Minute), Duration: 3600})
+		require.NoError(t, err)
+	})
+	t.Run("instant event", func(t *testing.T) {
+		_, err := a.CreateEvent(ctx, &common.Event{Title: "call", StartTime: start.AddDate(0, 0, 4).Add(10 * time.Minute)})
+		require.ErrorIs(t, err, common.ErrDateBusy)
+	})
+	t.Run("recurring event", func(t *testing.T) {
+		_, err := a.CreateEvent(ctx, &common.Event{Title: "retro", StartTime: start.AddDate(0, 0, -14).Add(time.Hour), Duration: 3600, RRule: "FREQ=WEEKLY"})
+		require.NoError(t, err)
+		_, err = a.CreateEvent(ctx, &common.Event{Title: "planning", StartTime: start.AddDate(0, 0, -7), Duration: 3600, RRule: "FREQ=WEEKLY"})
+		require.ErrorIs(t, err, common.ErrDateBusy)
+	})
+	t.Run("another owner", func(t *testing.T) {
+		ctx := common.WithOwner(context.Background(), 2)
+		_, err := a.CreateEvent(ctx, &common.Event{Title: "meeting", StartTime: start, Duration: 3600})
+		require.NoError(t, err)
+	})
+	t.Run("allowed overlap", func(t *testing.T) {
+		_, err := a.CreateEvent(common.WithAllowOverlap(ctx), &common.Event{Title: "lunch", StartTime: start, Duration: 3600})
+		require.NoError(t, err)
+	})
+	t.Run("update itself", func(t *testing.T) {
+		err := a.UpdateEvent(ctx, standupID, &common.Event{Title: "standup", StartTime: start.Add(time.Minute), Duration: 1800, RRule: "FREQ=DAILY"})
+		require.ErrorIs(t, err, common.ErrDateBusy, "the lunch takes the first standup")
+		err = a.UpdateEvent(ctx, standupID, &common.Event{Title: "standup", StartTime: start.AddDate(0, 0, 1).Add(-time.Hour), Duration: 1800, RRule: "FREQ=DAILY"})
+		require.NoError(t, err)
+	})
+	t.Run("override", func(t *testing.T) {
+		recurrenceID := start.AddDate(0, 0, 1).Add(-time.Hour)
+		_, err := a.CreateEvent(ctx, &common.Event{
+			Title: "standup", StartTime: recurrenceID.Add(10 * time.Minute), Duration: 1800,
+			ParentID: standupID, RecurrenceID: &recurrenceID,
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestListEvents(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < DefaultPageSize+1; i++ {
+		_, err := a.CreateEvent(ctx, &common.Event{Title: "event", StartTime: start.Add(time.Duration(i) * time.Hour)})
+		require.NoError(t, err)
+	}
+
+	events, next, err := a.ListEvents(ctx, start, start.AddDate(0, 1, 0), common.EventFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, DefaultPageSize)
+	require.NotEmpty(t, next)
+	events, next, err = a.ListEvents(ctx, start, start.AddDate(0, 1, 0), common.EventFilter{Owner: 1, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Empty(t, next)
+
+	_, _, err = a.ListEvents(ctx, start, start.AddDate(0, 1, 0), common.EventFilter{Owner: 2})
+	require.ErrorIs(t, err, common.ErrForbidden)
+	_, _, err = a.ListEvents(ctx, start, start, common.EventFilter{})
+	require.ErrorIs(t, err, common.ErrInvalidPeriod)
+}
+
+func TestPatchEvent(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	id, err := a.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: start, Duration: 900, Description: "daily"})
+	require.NoError(t, err)
+	_, err = a.CreateEvent(ctx, &common.Event{Title: "review", StartTime: start.Add(time.Hour), Duration: 3600})
+	require.NoError(t, err)
+
+	event, err := a.PatchEvent(ctx, id, &common.Event{StartTime: start.Add(-time.Hour)}, []string{common.FieldStartTime})
+	require.NoError(t, err)
+	require.Equal(t, "standup", event.Title)
+	require.Equal(t, "daily", event.Description)
+	require.Equal(t, start.Add(-time.Hour), event.StartTime)
+
+	_, err = a.PatchEvent(ctx, id, &common.Event{StartTime: start.Add(time.Hour)}, []string{common.FieldStartTime})
+	require.ErrorIs(t, err, common.ErrDateBusy)
+	_, err = a.PatchEvent(ctx, id, &common.Event{RRule: "FREQ=HOURLY"}, []string{common.FieldRRule})
+	require.ErrorIs(t, err, common.ErrInvalidRRule)
+	_, err = a.PatchEvent(common.WithOwner(ctx, 2), id, &common.Event{}, []string{common.FieldTitle})
+	require.ErrorIs(t, err, common.ErrForbidden)
+
+	stored, err := a.GetEvent(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, start.Add(-time.Hour), stored.StartTime)
+	require.Empty(t, stored.RRule)
+}
+
+func TestVersions(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	id, err := a.CreateEvent(ctx, &common.Event{Title: "draft", StartTime: start})
+	require.NoError(t, err)
+
+	stale := common.WithExpectedVersion(ctx, 1)
+	require.NoError(t, a.UpdateEvent(stale, id, &common.Event{Title: "first", StartTime: start, Version: 100}))
+	require.ErrorIs(t, a.UpdateEvent(stale, id, &common.Event{Title: "second", StartTime: start}), common.ErrVersionConflict)
+	_, err = a.PatchEvent(stale, id, &common.Event{Title: "second"}, []string{common.FieldTitle})
+	require.ErrorIs(t, err, common.ErrVersionConflict)
+	require.ErrorIs(t, a.DeleteEvent(stale, id), common.ErrVersionConflict)
+
+	event, err := a.PatchEvent(common.WithExpectedVersion(ctx, 2), id, &common.Event{Title: "second"}, []string{common.FieldTitle})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), event.Version)
+	require.NoError(t, a.DeleteEvent(common.WithExpectedVersion(ctx, 3), id))
+}
+
+func TestValidation(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	_, err := a.CreateEvent(ctx, &common.Event{Title: " ", Duration: -1, NotifyTime: -1, Reminders: []int32{600, 0}, RRule: "FREQ=HOURLY"})
+	var validation *common.ValidationError
+	require.ErrorAs(t, err, &validation)
+	fields := make([]string, 0, len(validation.Fields))
+	for _, field := range validation.Fields {
+		fields = append(fields, field.Field)
+	}
+	require.Equal(t, []string{
+		common.FieldTitle, common.FieldStartTime, common.FieldDuration, common.FieldNotifyTime, common.FieldReminders, common.FieldRRule,
+	}, fields)
+	require.ErrorIs(t, err, common.ErrInvalidRRule)
+
+	id, err := a.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: start, RRule: "FREQ=DAILY"})
+	require.NoError(t, err)
+	_, err = a.CreateEvent(ctx, &common.Event{Title: "moved", StartTime: start.Add(time.Hour), ParentID: id})
+	require.ErrorAs(t, err, &validation)
+	require.Equal(t, common.FieldRecurrenceID, validation.Fields[0].Field)
+
+	single, err := a.CreateEvent(ctx, &common.Event{Title: "single", StartTime: start.Add(-time.Hour)})
+	require.NoError(t, err)
+	stranger := common.WithOwner(context.Background(), 2)
+	recurrenceID := start.AddDate(0, 0, 1)
+	for name, override := range map[string]struct {
+		ctx    context.Context
+		parent int64
+		field  string
+	}{
+		"foreign parent": {stranger, id, common.FieldParentID},
+		"missing parent": {ctx, id + 100, common.FieldParentID},
+		"single parent":  {ctx, single, common.FieldParentID},
+	} {
+		_, err = a.CreateEvent(override.ctx, &common.Event{
+			Title: "intruder", StartTime: recurrenceID, ParentID: override.parent, RecurrenceID: &recurrenceID,
+		})
+		require.ErrorAs(t, err, &validation, name)
+		require.Equal(t, override.field, validation.Fields[0].Field, name)
+	}
+	notOccurrence := recurrenceID.Add(time.Minute)
+	_, err = a.CreateEvent(ctx, &common.Event{Title: "moved", StartTime: start, ParentID: id, RecurrenceID: &notOccurrence})
+	require.ErrorAs(t, err, &validation)
+	require.Equal(t, common.FieldRecurrenceID, validation.Fields[0].Field)
+	override, err := a.CreateEvent(ctx, &common.Event{Title: "moved", StartTime: recurrenceID.Add(2 * time.Hour), ParentID: id, RecurrenceID: &recurrenceID})
+	require.NoError(t, err)
+	_, err = a.PatchEvent(ctx, override, &common.Event{ParentID: single}, []string{common.FieldParentID})
+	require.ErrorAs(t, err, &validation)
+	require.Equal(t, common.FieldParentID, validation.Fields[0].Field)
+
+	_, err = a.PatchEvent(ctx, id, &common.Event{}, []string{common.FieldTitle})
+	require.ErrorAs(t, err, &validation)
+	require.Equal(t, common.FieldTitle, validation.Fields[0].Field)
+}
+
+func TestInvitations(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	owner := common.WithOwner(context.Background(), 1)
+	guest := common.WithOwner(context.Background(), 2)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	id, err := a.CreateEvent(owner, &common.Event{Title: "standup", StartTime: start, Duration: 900, RRule: "FREQ=DAILY"})
+	require.NoError(t, err)
+	recurrenceID := start.AddDate(0, 0, 1)
+	override, err := a.CreateEvent(owner, &common.Event{Title: "standup", StartTime: recurrenceID.Add(time.Hour), ParentID: id, RecurrenceID: &recurrenceID})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, a.InviteAttendees(owner, id, []int64{1}), common.ErrInvalidAttendee)
+	require.ErrorIs(t, a.InviteAttendees(owner, id, []int64{0}), common.ErrInvalidAttendee)
+	require.ErrorIs(t, a.InviteAttendees(guest, id, []int64{3}), common.ErrForbidden)
+	require.NoError(t, a.InviteAttendees(owner, override, []int64{2, 3}), "the invitation goes to the series")
+
+	require.ErrorIs(t, a.RespondToInvitation(guest, id, common.StatusPending), common.ErrInvalidStatus)
+	require.ErrorIs(t, a.RespondToInvitation(guest, id, "maybe"), common.ErrInvalidStatus)
+	require.ErrorIs(t, a.RespondToInvitation(common.WithOwner(context.Background(), 4), id, common.StatusAccepted), common.ErrNotInvited)
+	require.NoError(t, a.RespondToInvitation(guest, id, common.StatusAccepted))
+
+	events, err := a.ListInvitations(guest, start, start.AddDate(0, 0, 3), "")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, recurrenceID.Add(time.Hour), events[1].StartTime)
+	events, err = a.ListInvitations(guest, start, start.AddDate(0, 0, 3), common.StatusDeclined)
+	require.NoError(t, err)
+	require.Empty(t, events)
+	events, err = a.ListInvitations(common.WithOwner(context.Background(), 3), start, start.AddDate(0, 0, 3), common.StatusPending)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	_, err = a.ListInvitations(guest, start, start, "")
+	require.ErrorIs(t, err, common.ErrInvalidPeriod)
+}
+
+func TestFreeBusy(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	owner := common.WithOwner(context.Background(), 1)
+	guest := common.WithOwner(context.Background(), 2)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	_, err := a.CreateEvent(owner, &common.Event{Title: "standup", StartTime: start, Duration: 1800, RRule: "FREQ=DAILY;COUNT=3"})
+	require.NoError(t, err)
+	_, err = a.CreateEvent(owner, &common.Event{Title: "review", StartTime: start.Add(30 * time.Minute), Duration: 1800})
+	require.NoError(t, err)
+	meeting, err := a.CreateEvent(owner, &common.Event{Title: "meeting", StartTime: start.Add(3 * time.Hour), Duration: 3600})
+	require.NoError(t, err)
+	_, err = a.CreateEvent(guest, &common.Event{Title: "lunch", StartTime: start.Add(2 * time.Hour), Duration: 3600})
+	require.NoError(t, err)
+	require.NoError(t, a.InviteAttendees(owner, meeting, []int64{2}))
+
+	from, to := start, start.Add(8*time.Hour)
+	busy, err := a.FreeBusy(guest, []int64{1, 2}, from, to)
+	require.NoError(t, err)
+	require.Equal(t, []common.FreeBusy{
+		{Owner: 1, Busy: []common.Interval{{Start: start, End: start.Add(time.Hour)}, {Start: start.Add(3 * time.Hour), End: start.Add(4 * time.Hour)}}},
+		{Owner: 2, Busy: []common.Interval{{Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour)}}},
+	}, busy, "a pending invitation doesn't take the time")
+
+	require.NoError(t, a.RespondToInvitation(guest, meeting, common.StatusAccepted))
+	busy, err = a.FreeBusy(guest, []int64{2}, start.Add(150*time.Minute), to)
+	require.NoError(t, err)
+	require.Equal(t, []common.Interval{{Start: start.Add(150 * time.Minute), End: start.Add(4 * time.Hour)}}, busy[0].Busy)
+
+	slots, err := a.FindSlots(guest, common.SlotQuery{
+		Owners: []int64{1, 2}, Duration: time.Hour, From: from, To: to,
+		WorkingHours: common.WorkingHours{Start: 9 * 60, End: 18 * 60},
+		Step:         time.Hour, Limit: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []common.Interval{
+		{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)},
+		{Start: start.Add(4 * time.Hour), End: start.Add(5 * time.Hour)},
+	}, slots)
+	slots, err = a.FindSlots(owner, common.SlotQuery{Duration: 30 * time.Minute, From: start, To: start.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, slots, 3, "the owner of the request by default, 15 minutes apart")
+
+	_, err = a.FreeBusy(guest, []int64{1}, to, from)
+	require.ErrorIs(t, err, common.ErrInvalidPeriod)
+	for _, query := range []common.SlotQuery{
+		{Duration: time.Hour, From: from, To: from.Add(MaxFreeBusyPeriod + time.Hour)},
+		{Duration: time.Hour, From: from, To: to, Owners: []int64{-1}},
+		{From: from, To: to},
+		{Duration: time.Hour, From: from, To: to, Step: time.Second},
+		{Duration: time.Hour, From: from, To: to, WorkingHours: common.WorkingHours{Start: 600, End: 60}},
+	} {
+		_, err = a.FindSlots(owner, query)
+		require.ErrorIs(t, err, common.ErrInvalidQuery)
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	moscow, err := common.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	_, err = a.CreateEvent(ctx, &common.Event{Title: "zoned", StartTime: time.Now(), TimeZone: "Mars/Olympus"})
+	var validation *common.ValidationError
+	require.ErrorAs(t, err, &validation)
+	require.Equal(t, common.FieldTimeZone, validation.Fields[0].Field)
+
+	start := time.Date(2021, 3, 1, 23, 30, 0, 0, time.UTC)
+	id, err := a.CreateEvent(ctx, &common.Event{Title: "late call", StartTime: start, Duration: 1800, TimeZone: "Europe/Moscow"})
+	require.NoError(t, err)
+	event, err := a.GetEvent(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, moscow, event.StartTime.Location())
+
+	events, err := a.ListEventsByDay(ctx, time.Date(2021, 3, 2, 0, 0, 0, 0, moscow))
+	require.NoError(t, err)
+	require.Len(t, events, 1, "it is the 2nd of March in Moscow")
+	events, err = a.ListEventsByDay(ctx, time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestAllDayEvents(t *testing.T) {
+	log := logrus.New()
+	a := New(log, memorystorage.New(log))
+	ctx := common.WithOwner(context.Background(), 1)
+	moscow, err := common.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	// The conference takes three days, the duration is rounded up to them.
+	id, err := a.CreateEvent(ctx, &common.Event{
+		Title: "conference", StartTime: time.Date(2021, 3, 1, 15, 0, 0, 0, moscow), Duration: 2*24*3600 + 1,
+		TimeZone: "Europe/Moscow", AllDay: true,
+	})
+	require.NoError(t, err)
+	event, err := a.GetEvent(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 3, 1, 0, 0, 0, 0, moscow), event.StartTime)
+	require.Equal(t, int64(3*24*3600), event.Duration)
+	_, err = a.CreateEvent(ctx, &common.Event{
+		Title: "night shift", StartTime: time.Date(2021, 3, 4, 22, 0, 0, 0, moscow), Duration: 8 * 3600, TimeZone: "Europe/Moscow",
+	})
+	require.NoError(t, err)
+
+	for day, titles := range map[int][]string{
+		1: {"conference"},
+		2: {"conference"},
+		3: {"conference"},
+		4: {"night shift"},
+		5: {"night shift"},
+		6: {},
+	} {
+		events, err := a.ListEventsByDay(ctx, time.Date(2021, 3, day, 0, 0, 0, 0, moscow))
+		require.NoError(t, err)
+		found := make([]string, 0, len(events))
+		for _, event := range events {
+			found = append(found, event.Title)
+		}
+		require.Equal(t, titles, found, day)
+	}
+
+	page, _, err := a.ListEvents(ctx, time.Date(2021, 3, 2, 0, 0, 0, 0, moscow), time.Date(2021, 3, 6, 0, 0, 0, 0, moscow), common.EventFilter{})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.Equal(t, "conference", page[0].Title)
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+const (
+	// MaxFreeBusyOwners and MaxFreeBusyPeriod bound the work of a free/busy query.
+	MaxFreeBusyOwners = 50
+	MaxFreeBusyPeriod = 92 * 24 * time.Hour
+
+	DefaultSlotStep  = 15 * time.Minute
+	DefaultSlotLimit = 10
+	MaxSlotLimit     = 100
+)
+
+// FreeBusy returns the busy intervals of every owner within [from, to) in the order of the owners.
+// An owner is busy during the events and occurrences of their own and the ones they accepted
+// or tentatively accepted the invitations to. The intervals are clipped to the period and merged.
+func (a *App) FreeBusy(ctx context.Context, owners []int64, from, to time.Time) ([]common.FreeBusy, error) {
+	if _, err := ownerFrom(ctx); err != nil {
+		return nil, err
+	}
+	if err := checkFreeBusyQuery(owners, from, to); err != nil {
+		return nil, err
+	}
+	result := make([]common.FreeBusy, 0, len(owners))
+	for _, owner := range owners {
+		busy, err := a.busy(ctx, owner, from, to)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, common.FreeBusy{Owner: owner, Busy: busy})
+	}
+	return result, nil
+}
+
+// FindSlots proposes the slots within the working hours of the window none of the owners is busy at,
+// the owner of the request is the only one if the owners are omitted.
+func (a *App) FindSlots(ctx context.Context, query common.SlotQuery) ([]common.Interval, error) {
+	owner, err := ownerFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Owners) == 0 {
+		query.Owners = []int64{owner}
+	}
+	if err = checkFreeBusyQuery(query.Owners, query.From, query.To); err != nil {
+		return nil, err
+	}
+	if query.Duration <= 0 {
+		return nil, fmt.Errorf("%w: duration must be positive", common.ErrInvalidQuery)
+	}
+	if err = query.WorkingHours.Validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case query.Step == 0:
+		query.Step = DefaultSlotStep
+	case query.Step < time.Minute:
+		return nil, fmt.Errorf("%w: step must be at least a minute", common.ErrInvalidQuery)
+	}
+	switch {
+	case query.Limit <= 0:
+		query.Limit = DefaultSlotLimit
+	case query.Limit > MaxSlotLimit:
+		query.Limit = MaxSlotLimit
+	}
+	var busy []common.Interval
+	for _, owner := range query.Owners {
+		intervals, err := a.busy(ctx, owner, query.From, query.To)
+		if err != nil {
+			return nil, err
+		}
+		busy = append(busy, intervals...)
+	}
+	return common.FreeSlots(busy, query), nil
+}
+
+func checkFreeBusyQuery(owners []int64, from, to time.Time) error {
+	if !to.After(from) {
+		return common.ErrInvalidPeriod
+	}
+	if to.Sub(from) > MaxFreeBusyPeriod {
+		return fmt.Errorf("%w: the period is longer than %s", common.ErrInvalidQuery, MaxFreeBusyPeriod)
+	}
+	if len(owners) == 0 || len(owners) > MaxFreeBusyOwners {
+		return fmt.Errorf("%w: there must be from 1 to %d owners", common.ErrInvalidQuery, MaxFreeBusyOwners)
+	}
+	for _, owner := range owners {
+		if owner <= 0 {
+			return fmt.Errorf("%w: invalid owner %d", common.ErrInvalidQuery, owner)
+		}
+	}
+	return nil
+}
+
+// busy returns the merged intervals within [from, to) the owner is busy at.
+func (a *App) busy(ctx context.Context, owner int64, from, to time.Time) ([]common.Interval, error) {
+	events, err := a.storage.FindOverlapping(ctx, owner, from, to)
+	if err != nil {
+		return nil, err
+	}
+	invited, err := a.storage.ListInvitedEvents(ctx, owner, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range invited {
+		status, _ := event.AttendeeStatus(owner)
+		if (status == common.StatusAccepted || status == common.StatusTentative) && event.Overlaps(from, to) {
+			events = append(events, event)
+		}
+	}
+	intervals := make([]common.Interval, 0, len(events))
+	for _, event := range events {
+		interval := common.Interval{Start: event.StartTime, End: event.End()}
+		if interval.Start.Before(from) {
+			interval.Start = from
+		}
+		if interval.End.After(to) {
+			interval.End = to
+		}
+		intervals = append(intervals, interval)
+	}
+	return common.MergeIntervals(intervals), nil
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+const (
+	maxTitleLength = 256
+	maxReminders   = 10
+)
+
+// validateEvent returns *common.ValidationError listing all the invalid fields of the event.
+func validateEvent(event *common.Event) error {
+	var fields []common.FieldError
+	invalid := func(field, reason string, err error) {
+		fields = append(fields, common.FieldError{Field: field, Reason: reason, Err: err})
+	}
+	switch {
+	case strings.TrimSpace(event.Title) == "":
+		invalid(common.FieldTitle, "must not be empty", nil)
+	case utf8.RuneCountInString(event.Title) > maxTitleLength:
+		invalid(common.FieldTitle, fmt.Sprintf("must not be longer than %d characters", maxTitleLength), nil)
+	}
+	if event.StartTime.IsZero() {
+		invalid(common.FieldStartTime, "must be set", nil)
+	}
+	if _, err := common.LoadLocation(event.TimeZone); err != nil {
+		invalid(common.FieldTimeZone, "must be an IANA time zone such as Europe/Moscow", err)
+	}
+	if event.Duration < 0 {
+		invalid(common.FieldDuration, "must not be negative", nil)
+	}
+	if event.NotifyTime < 0 {
+		invalid(common.FieldNotifyTime, "must not be negative", nil)
+	}
+	if len(event.Reminders) > maxReminders {
+		invalid(common.FieldReminders, fmt.Sprintf("must not contain more than %d reminders", maxReminders), nil)
+	}
+	for _, offset := range event.Reminders {
+		if offset <= 0 {
+			invalid(common.FieldReminders, "must be positive", nil)
+			break
+		}
+	}
+	if event.ParentID != 0 {
+		if event.RRule != "" {
+			invalid(common.FieldRRule, "an occurrence override can't recur itself", common.ErrInvalidRRule)
+		}
+		if event.RecurrenceID == nil {
+			invalid(common.FieldRecurrenceID, "an occurrence override requires a recurrence id", common.ErrInvalidRRule)
+		}
+	} else if event.RRule != "" {
+		if _, err := common.ParseRRule(event.RRule); err != nil {
+			invalid(common.FieldRRule, err.Error(), err)
+		}
+	}
+	if len(fields) > 0 {
+		return &common.ValidationError{Fields: fields}
+	}
+	return nil
+}
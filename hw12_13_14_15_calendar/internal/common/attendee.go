@@ -0,0 +1,69 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotInvited    = errors.New("user is not invited to the event")
+	ErrInvalidStatus = errors.New("invalid invitation status")
+)
+
+// AttendeeStatus is the answer of an attendee to the invitation.
+type AttendeeStatus string
+
+const (
+	StatusPending   AttendeeStatus = "pending"
+	StatusAccepted  AttendeeStatus = "accepted"
+	StatusDeclined  AttendeeStatus = "declined"
+	StatusTentative AttendeeStatus = "tentative"
+)
+
+// ParseAttendeeStatus returns ErrInvalidStatus unless s is one of the statuses.
+func ParseAttendeeStatus(s string) (AttendeeStatus, error) {
+	switch status := AttendeeStatus(s); status {
+	case StatusPending, StatusAccepted, StatusDeclined, StatusTentative:
+		return status, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidStatus, s)
+}
+
+// Attendee is a user invited to an event. The attendees of a recurring series attend
+// all of its occurrences, overrides included.
+type Attendee struct {
+	EventID int64          `json:"-" db:"event_id"`
+	User    int64          `json:"user" db:"user_id"`
+	Status  AttendeeStatus `json:"status" db:"status"`
+	Updated time.Time      `json:"updated" db:"updated"`
+}
+
+// InvitationID returns the ID of the event the attendees are invited to, the series for an override.
+func (e *Event) InvitationID() int64 {
+	if e.ParentID != 0 {
+		return e.ParentID
+	}
+	return e.ID
+}
+
+// Recipients returns the users to notify of the event: the owner and the attendees who accepted the invitation.
+func (e *Event) Recipients() []int64 {
+	recipients := []int64{e.Owner}
+	for _, attendee := range e.Attendees {
+		if attendee.Status == StatusAccepted && attendee.User != e.Owner {
+			recipients = append(recipients, attendee.User)
+		}
+	}
+	return recipients
+}
+
+// AttendeeStatus returns the status of the user's invitation to the event, false if the user isn't invited.
+func (e *Event) AttendeeStatus(user int64) (AttendeeStatus, bool) {
+	for _, attendee := range e.Attendees {
+		if attendee.User == user {
+			return attendee.Status, true
+		}
+	}
+	return "", false
+}
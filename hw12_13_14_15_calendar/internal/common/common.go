@@ -8,18 +8,99 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 )
 
 const PgTimestampFmt = `2006-01-02 15:04:05`
 
-var ErrNoSuchEvent = errors.New("no such event")
+const secondsPerDay = 24 * 60 * 60
+
+var (
+	ErrNoSuchEvent = errors.New("no such event")
+	ErrForbidden   = errors.New("event belongs to another owner")
+	ErrNoOwner     = errors.New("owner is not specified")
+	ErrDateBusy    = errors.New("time is already taken by another event")
+	// ErrVersionConflict means the event has been changed since the version the request expects.
+	ErrVersionConflict = errors.New("event version has changed")
+	// ErrInvalidAttendee means the owner invites themselves or a user ID isn't positive.
+	ErrInvalidAttendee = errors.New("invalid attendee")
+)
+
+type ownerKey struct{}
+
+// WithOwner returns a copy of ctx carrying the ID of the user the request is made on behalf of.
+func WithOwner(ctx context.Context, owner int64) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+func OwnerFromContext(ctx context.Context) (int64, bool) {
+	owner, ok := ctx.Value(ownerKey{}).(int64)
+	return owner, ok
+}
+
+type allowOverlapKey struct{}
+
+// WithAllowOverlap returns a copy of ctx which lets the request book an already taken time.
+func WithAllowOverlap(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allowOverlapKey{}, true)
+}
+
+func AllowOverlap(ctx context.Context) bool {
+	allow, _ := ctx.Value(allowOverlapKey{}).(bool)
+	return allow
+}
+
+type expectedVersionKey struct{}
+
+// WithExpectedVersion returns a copy of ctx which makes updates and deletes fail with ErrVersionConflict
+// unless the stored event has the version.
+func WithExpectedVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, expectedVersionKey{}, version)
+}
+
+// ExpectedVersion returns the version set by WithExpectedVersion, zero if there is none.
+func ExpectedVersion(ctx context.Context) int64 {
+	version, _ := ctx.Value(expectedVersionKey{}).(int64)
+	return version
+}
 
 type Notification struct {
-	ID        int64     `json:"id"`
-	Title     string    `json:"title"`
-	EventTime time.Time `json:"event_time"`
-	Owner     int64     `json:"owner"`
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	EventTime   time.Time `json:"event_time"`
+	// Duration of the event in seconds.
+	Duration int64 `json:"duration,omitempty"`
+	Owner    int64 `json:"owner"`
+	// Recipient is the user to notify, the owner or an attendee who accepted the invitation.
+	Recipient int64 `json:"recipient,omitempty"`
+	// Reminder is the offset in seconds before the event the notification is sent at.
+	Reminder int32 `json:"reminder"`
+	// TimeZone is the time zone of the event.
+	TimeZone string `json:"timeZone,omitempty"`
+	// Key identifies the notification on the reminder of the occurrence, a notification may be delivered
+	// more than once and the repeated ones have the same key.
+	Key string `json:"key,omitempty"`
+}
+
+// IdempotencyKey returns the key of the notification made of the event ID, the occurrence start and the reminder,
+// followed by the recipient if it isn't the owner.
+func (n *Notification) IdempotencyKey() string {
+	key := fmt.Sprintf("%d:%d:%d", n.ID, n.EventTime.Unix(), n.Reminder)
+	if recipient := n.RecipientID(); recipient != n.Owner {
+		key += ":" + strconv.FormatInt(recipient, 10)
+	}
+	return key
+}
+
+// RecipientID returns the recipient of the notification, the owner for the ones enqueued without it.
+func (n *Notification) RecipientID() int64 {
+	if n.Recipient == 0 {
+		return n.Owner
+	}
+	return n.Recipient
 }
 
 func (n *Notification) Encode() ([]byte, error) {
@@ -29,7 +110,7 @@ func (n *Notification) Encode() ([]byte, error) {
 }
 
 func (n *Notification) String() string {
-	return fmt.Sprintf("id: %d, title: %s, time: %s, onwer: %d", n.ID, n.Title, n.EventTime.Format(PgTimestampFmt), n.Owner)
+	return fmt.Sprintf("id: %d, title: %s, time: %s, onwer: %d", n.ID, n.Title, n.EventTime.Format(time.RFC3339), n.Owner)
 }
 
 type Event struct {
@@ -42,14 +123,123 @@ type Event struct {
 	NotifyTime  int32     `json:"notifyTime" db:"notify_time"`
 	Created     time.Time `json:"created" db:"created"`
 	Updated     time.Time `json:"updated" db:"updated"`
+	// TimeZone is the IANA name of the time zone the event is scheduled in, UTC if it is empty.
+	// The occurrences of a recurring event keep their local time across DST transitions.
+	TimeZone string `json:"timeZone,omitempty" db:"time_zone"`
+	// AllDay events take whole days of their time zone, Duration is rounded up to the days.
+	AllDay bool `json:"allDay,omitempty" db:"all_day"`
+	// Version is incremented on every update of the event.
+	Version int64 `json:"version" db:"version"`
+	// NotifiedAt is the time the last notification of the event, or of an occurrence of it, was enqueued at.
+	NotifiedAt *time.Time `json:"-" db:"notified_at"`
+	// Reminders are offsets in seconds before the start of the event to notify at,
+	// NotifyTime is one more reminder kept for the clients which set a single one.
+	Reminders []int32 `json:"reminders,omitempty" db:"-"`
+	// RRule makes the event a recurring series, see RRule for the supported subset.
+	RRule   string  `json:"rrule,omitempty" db:"rrule"`
+	ExDates ExDates `json:"exdates,omitempty" db:"exdates"`
+	// ParentID and RecurrenceID mark an override of a single occurrence of the parent series.
+	// For expanded occurrences RecurrenceID holds the original start time of the occurrence.
+	ParentID     int64      `json:"parentId,omitempty" db:"parent_id"`
+	RecurrenceID *time.Time `json:"recurrenceId,omitempty" db:"recurrence_id"`
+	// Attendees are the invited users, they are managed by the invitations rather than the event updates.
+	Attendees []Attendee `json:"attendees,omitempty" db:"-"`
+}
+
+// End returns the end of the event, the midnight the last day of an all-day event ends at.
+func (e *Event) End() time.Time {
+	if e.AllDay {
+		return e.StartTime.In(e.Location()).AddDate(0, 0, e.days())
+	}
+	return e.StartTime.Add(time.Duration(e.Duration) * time.Second)
+}
+
+// days returns the number of the days an all-day event takes, one at least.
+func (e *Event) days() int {
+	days := int((e.Duration + secondsPerDay - 1) / secondsPerDay)
+	if days < 1 {
+		return 1
+	}
+	return days
+}
+
+// maxLength returns the longest an occurrence of the event may last, an all-day occurrence
+// is an hour longer than the first one when the clocks go back.
+func (e *Event) maxLength() time.Duration {
+	if e.AllDay {
+		return time.Duration(e.days())*24*time.Hour + time.Hour
+	}
+	return time.Duration(e.Duration) * time.Second
+}
+
+// AlignAllDay makes an all-day event start at the midnight of its start day in its time zone
+// and last whole days.
+func (e *Event) AlignAllDay() {
+	if !e.AllDay {
+		return
+	}
+	start := e.StartTime.In(e.Location())
+	e.StartTime = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	e.Duration = int64(e.days()) * secondsPerDay
+}
+
+// Overlaps reports whether the event takes some time of [from, to).
+// An event without duration and an empty range take the instant they start at.
+func (e *Event) Overlaps(from, to time.Time) bool {
+	end := e.End()
+	if !end.After(e.StartTime) {
+		end = e.StartTime.Add(time.Nanosecond)
+	}
+	if !to.After(from) {
+		to = from.Add(time.Nanosecond)
+	}
+	return e.StartTime.Before(to) && end.After(from)
+}
+
+// ReminderOffsets returns the distinct positive reminder offsets of the event, NotifyTime included, largest first.
+func (e *Event) ReminderOffsets() []int32 {
+	offsets := make([]int32, 0, len(e.Reminders)+1)
+	for _, offset := range append([]int32{e.NotifyTime}, e.Reminders...) {
+		if offset > 0 {
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	distinct := offsets[:0]
+	for i, offset := range offsets {
+		if i == 0 || offset != offsets[i-1] {
+			distinct = append(distinct, offset)
+		}
+	}
+	return distinct
+}
+
+// DueReminders returns the reminders of the event whose notify windows have opened by now and which
+// haven't been enqueued yet. The notification enqueued at NotifiedAt covered the reminders
+// whose windows had opened by then.
+func (e *Event) DueReminders(now time.Time) []int32 {
+	if e.StartTime.Before(now) {
+		return nil
+	}
+	var due []int32
+	for _, offset := range e.ReminderOffsets() {
+		opens := e.StartTime.Add(-time.Duration(offset) * time.Second)
+		if opens.Before(now) && (e.NotifiedAt == nil || !e.NotifiedAt.After(opens)) {
+			due = append(due, offset)
+		}
+	}
+	return due
 }
 
 func (e *Event) Notification() *Notification {
 	return &Notification{
-		ID:        e.ID,
-		Title:     e.Title,
-		EventTime: e.StartTime,
-		Owner:     e.Owner,
+		ID:          e.ID,
+		Title:       e.Title,
+		Description: e.Description,
+		EventTime:   e.StartTime,
+		Duration:    e.Duration,
+		Owner:       e.Owner,
+		TimeZone:    e.TimeZone,
 	}
 }
 
@@ -63,16 +253,38 @@ func (e *Event) ParseEvent(r *http.Request) error {
 type Application interface {
 	CreateEvent(ctx context.Context, event *Event) (id int64, err error)
 	UpdateEvent(ctx context.Context, id int64, event *Event) (err error)
+	// PatchEvent updates the listed fields of the stored event, see Event.Apply, and returns the result.
+	PatchEvent(ctx context.Context, id int64, patch *Event, fields []string) (event *Event, err error)
 	DeleteEvent(ctx context.Context, id int64) (err error)
+	GetEvent(ctx context.Context, id int64) (event *Event, err error)
 	ListEventsByDay(ctx context.Context, date time.Time) (events []Event, err error)
 	ListEventsByWeek(ctx context.Context, date time.Time) (events []Event, err error)
 	ListEventsByMonth(ctx context.Context, date time.Time) (events []Event, err error)
+	// ListEvents returns a page of the events overlapping [from, to) ordered by start time
+	// and the cursor of the next page, empty if it is the last one.
+	ListEvents(ctx context.Context, from, to time.Time, filter EventFilter) (events []Event, next string, err error)
+	// InviteAttendees invites the users to the event of the owner of the request.
+	InviteAttendees(ctx context.Context, id int64, users []int64) (err error)
+	// RespondToInvitation sets the answer of the user of the request to the invitation to the event.
+	RespondToInvitation(ctx context.Context, id int64, status AttendeeStatus) (err error)
+	// ListInvitations returns the events overlapping [from, to) the user of the request is invited to,
+	// answered with status unless it is empty.
+	ListInvitations(ctx context.Context, from, to time.Time, status AttendeeStatus) (events []Event, err error)
+	// FreeBusy returns the merged busy intervals within [from, to) of every owner.
+	FreeBusy(ctx context.Context, owners []int64, from, to time.Time) (busy []FreeBusy, err error)
+	// FindSlots proposes the slots the owners of the query are all free at.
+	FindSlots(ctx context.Context, query SlotQuery) (slots []Interval, err error)
 }
 
 type TestApp struct{}
 
 func (t TestApp) CreateEvent(_ context.Context, event *Event) (int64, error) {
-	if event.ID == 0 {
+	switch {
+	case event.Title == "":
+		return 0, &ValidationError{Fields: []FieldError{{Field: FieldTitle, Reason: "must not be empty"}}}
+	case event.ID == 0 && event.StartTime.IsZero():
+		return 0, &ValidationError{Fields: []FieldError{{Field: FieldStartTime, Reason: "must be set"}}}
+	case event.ID == 0:
 		return 1, nil
 	}
 	return 0, io.ErrShortBuffer
@@ -84,22 +296,67 @@ func (t TestApp) UpdateEvent(_ context.Context, id int64, _ *Event) (err error)
 		err = ErrNoSuchEvent
 	case 1:
 		err = io.ErrShortBuffer
+	case 3:
+		err = ErrForbidden
+	case 4:
+		err = ErrDateBusy
+	case 5:
+		err = ErrVersionConflict
 	default:
 	}
 	return err
 }
 
+func (t TestApp) PatchEvent(ctx context.Context, id int64, patch *Event, fields []string) (*Event, error) {
+	event, err := t.GetEvent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch id {
+	case 4:
+		return nil, ErrDateBusy
+	case 5:
+		return nil, ErrVersionConflict
+	}
+	if err = event.Apply(patch, fields); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
 func (t TestApp) DeleteEvent(_ context.Context, id int64) (err error) {
 	switch id {
 	case 0:
 		err = ErrNoSuchEvent
 	case 1:
 		err = io.ErrShortBuffer
+	case 3:
+		err = ErrForbidden
+	case 5:
+		err = ErrVersionConflict
 	default:
 	}
 	return err
 }
 
+func (t TestApp) GetEvent(_ context.Context, id int64) (*Event, error) {
+	switch id {
+	case 0:
+		return nil, ErrNoSuchEvent
+	case 1:
+		return nil, io.ErrShortBuffer
+	case 3:
+		return nil, ErrForbidden
+	}
+	events, err := t.listEvents(time.Date(1987, 10, 16, 0, 0, 0, 0, time.UTC), 1)
+	if err != nil {
+		return nil, err
+	}
+	events[0].ID = id
+	events[0].Version = 1
+	return &events[0], nil
+}
+
 func (t TestApp) ListEventsByDay(_ context.Context, date time.Time) ([]Event, error) {
 	return t.listEvents(date, 5)
 }
@@ -112,6 +369,73 @@ func (t TestApp) ListEventsByMonth(_ context.Context, date time.Time) ([]Event,
 	return t.listEvents(date, 50)
 }
 
+func (t TestApp) ListEvents(_ context.Context, from, _ time.Time, filter EventFilter) ([]Event, string, error) {
+	if filter.Cursor != "" {
+		if _, _, err := DecodeCursor(filter.Cursor); err != nil {
+			return nil, "", err
+		}
+		return []Event{}, "", nil
+	}
+	events, err := t.listEvents(from, 10)
+	if err != nil {
+		return nil, "", err
+	}
+	return events, EncodeCursor(&events[len(events)-1]), nil
+}
+
+func (t TestApp) InviteAttendees(_ context.Context, id int64, users []int64) error {
+	for _, user := range users {
+		if user <= 0 {
+			return ErrInvalidAttendee
+		}
+	}
+	switch id {
+	case 0:
+		return ErrNoSuchEvent
+	case 3:
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (t TestApp) RespondToInvitation(_ context.Context, id int64, status AttendeeStatus) error {
+	if _, err := ParseAttendeeStatus(string(status)); err != nil || status == StatusPending {
+		return ErrInvalidStatus
+	}
+	switch id {
+	case 0:
+		return ErrNoSuchEvent
+	case 3:
+		return ErrNotInvited
+	}
+	return nil
+}
+
+func (t TestApp) ListInvitations(_ context.Context, from, to time.Time, _ AttendeeStatus) ([]Event, error) {
+	if !to.After(from) {
+		return nil, ErrInvalidPeriod
+	}
+	return t.listEvents(from, 3)
+}
+
+func (t TestApp) FreeBusy(_ context.Context, owners []int64, from, to time.Time) ([]FreeBusy, error) {
+	if !to.After(from) {
+		return nil, ErrInvalidPeriod
+	}
+	result := make([]FreeBusy, 0, len(owners))
+	for _, owner := range owners {
+		result = append(result, FreeBusy{Owner: owner, Busy: []Interval{{Start: from, End: from.Add(time.Hour)}}})
+	}
+	return result, nil
+}
+
+func (t TestApp) FindSlots(_ context.Context, query SlotQuery) ([]Interval, error) {
+	if query.Duration <= 0 {
+		return nil, fmt.Errorf("%w: duration must be positive", ErrInvalidQuery)
+	}
+	return []Interval{{Start: query.From, End: query.From.Add(query.Duration)}}, nil
+}
+
 func (t TestApp) listEvents(dateTime time.Time, cnt int) ([]Event, error) {
 	result := make([]Event, cnt)
 	for i := 0; i < cnt; i++ {
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidPeriod = errors.New("the end of the period must be after its start")
+)
+
+// EventFilter narrows down and paginates ListEvents.
+type EventFilter struct {
+	// Owner of the events, zero stands for the owner of the request.
+	Owner int64
+	// Title is a case-insensitive substring of the event title.
+	Title string
+	// Cursor is the next page cursor returned by the previous call, empty for the first page.
+	Cursor string
+	Limit  int
+}
+
+func (f *EventFilter) Matches(event *Event) bool {
+	return f.Title == "" || strings.Contains(strings.ToLower(event.Title), strings.ToLower(f.Title))
+}
+
+// EncodeCursor returns a cursor pointing right after the event in the order of ListEvents.
+func EncodeCursor(event *Event) string {
+	key := strconv.FormatInt(event.StartTime.UnixNano(), 10) + ":" + strconv.FormatInt(event.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodeCursor returns the start time and the ID of the event the cursor points after.
+func DecodeCursor(cursor string) (time.Time, int64, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	parts := strings.SplitN(string(key), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	return time.Unix(0, nsec).UTC(), id, nil
+}
+
+// less orders events by start time and then by ID, occurrences of a series differ in start time.
+func less(a, b *Event) bool {
+	if !a.StartTime.Equal(b.StartTime) {
+		return a.StartTime.Before(b.StartTime)
+	}
+	return a.ID < b.ID
+}
+
+// PageEvents sorts events by start time, drops the ones up to the cursor and
+// returns at most limit of the rest along with the cursor of the next page, empty if there is none.
+func PageEvents(events []Event, cursor string, limit int) ([]Event, string, error) {
+	sort.Slice(events, func(i, j int) bool { return less(&events[i], &events[j]) })
+	if cursor != "" {
+		start, id, err := DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after := &Event{ID: id, StartTime: start}
+		i := sort.Search(len(events), func(i int) bool { return less(after, &events[i]) })
+		events = events[i:]
+	}
+	if limit <= 0 || len(events) <= limit {
+		return events, "", nil
+	}
+	events = events[:limit]
+	return events, EncodeCursor(&events[limit-1]), nil
+}
@@ -0,0 +1,204 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+var ErrInvalidQuery = errors.New("invalid free/busy query")
+
+// Interval is the time within [Start, End).
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// FreeBusy is the time the owner is busy at, the intervals are ordered and don't overlap.
+type FreeBusy struct {
+	Owner int64      `json:"owner"`
+	Busy  []Interval `json:"busy"`
+}
+
+// MergeIntervals returns the ordered union of the intervals, the touching ones are joined and the empty ones dropped.
+func MergeIntervals(intervals []Interval) []Interval {
+	sorted := make([]Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		if interval.End.After(interval.Start) {
+			sorted = append(sorted, interval)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	merged := make([]Interval, 0, len(sorted))
+	for _, interval := range sorted {
+		if last := len(merged) - 1; last >= 0 && !interval.Start.After(merged[last].End) {
+			if interval.End.After(merged[last].End) {
+				merged[last].End = interval.End
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// WorkingHours limit the proposed slots to a daily period of the working days in a time zone.
+type WorkingHours struct {
+	// Start and End are the minutes since midnight, zero End stands for the end of the day.
+	Start int
+	End   int
+	// Days are the working days of the week, all of them if it is empty.
+	Days []time.Weekday
+	// Location is UTC if it is nil.
+	Location *time.Location
+}
+
+// Validate returns ErrInvalidQuery unless the period is within a day.
+func (h *WorkingHours) Validate() error {
+	end := h.end()
+	if h.Start < 0 || end > minutesPerDay || h.Start >= end {
+		return fmt.Errorf("%w: working hours must be a period within a day", ErrInvalidQuery)
+	}
+	return nil
+}
+
+func (h *WorkingHours) end() int {
+	if h.End == 0 {
+		return minutesPerDay
+	}
+	return h.End
+}
+
+func (h *WorkingHours) location() *time.Location {
+	if h.Location == nil {
+		return time.UTC
+	}
+	return h.Location
+}
+
+func (h *WorkingHours) works(day time.Weekday) bool {
+	if len(h.Days) == 0 {
+		return true
+	}
+	for _, working := range h.Days {
+		if working == day {
+			return true
+		}
+	}
+	return false
+}
+
+// periods returns the working periods overlapping [from, to) clipped to it. The days are counted
+// in the time zone of the working hours, so a period is shorter or longer on the days of DST changes.
+func (h *WorkingHours) periods(from, to time.Time) []Interval {
+	location := h.location()
+	local := from.In(location)
+	var periods []Interval
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !h.works(day.Weekday()) {
+			continue
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, h.Start, 0, 0, location)
+		end := time.Date(day.Year(), day.Month(), day.Day(), 0, h.end(), 0, 0, location)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			periods = append(periods, Interval{Start: start, End: end})
+		}
+	}
+	return periods
+}
+
+// SlotQuery describes the meeting FindSlots looks for.
+type SlotQuery struct {
+	// Owners are the participants, the owner of the request if it is empty.
+	Owners   []int64
+	Duration time.Duration
+	// From and To bound the window to look within.
+	From         time.Time
+	To           time.Time
+	WorkingHours WorkingHours
+	// Step is the granularity of the slot starts counted from the start of a free period.
+	Step time.Duration
+	// Limit is the maximal number of the proposed slots.
+	Limit int
+}
+
+// FreeSlots returns up to query.Limit slots of query.Duration within the working hours of the window
+// which don't overlap the busy intervals, ordered by start. Consecutive slots are query.Step apart.
+func FreeSlots(busy []Interval, query SlotQuery) []Interval {
+	busy = MergeIntervals(busy)
+	var slots []Interval
+	for _, period := range query.WorkingHours.periods(query.From, query.To) {
+		for _, free := range subtract(period, busy) {
+			for start := free.Start; !start.Add(query.Duration).After(free.End); start = start.Add(query.Step) {
+				if len(slots) == query.Limit {
+					return slots
+				}
+				slots = append(slots, Interval{Start: start, End: start.Add(query.Duration)})
+			}
+		}
+	}
+	return slots
+}
+
+// subtract returns the parts of the period not covered by the ordered non-overlapping busy intervals.
+func subtract(period Interval, busy []Interval) []Interval {
+	var free []Interval
+	start := period.Start
+	for _, interval := range busy {
+		if !interval.End.After(start) {
+			continue
+		}
+		if !interval.Start.Before(period.End) {
+			break
+		}
+		if interval.Start.After(start) {
+			free = append(free, Interval{Start: start, End: interval.Start})
+		}
+		start = interval.End
+	}
+	if period.End.After(start) {
+		free = append(free, Interval{Start: start, End: period.End})
+	}
+	return free
+}
+
+// ParseClock parses a time of the day such as 09:30 into the minutes since midnight, 24:00 included.
+func ParseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("%w: unparsable time of the day %q, use HH:MM", ErrInvalidQuery, s)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("%w: unparsable time of the day %q, use HH:MM", ErrInvalidQuery, s)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > minutesPerDay {
+		return 0, fmt.Errorf("%w: unparsable time of the day %q, use HH:MM", ErrInvalidQuery, s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// ParseWeekdays parses a comma-separated list of the iCalendar week days such as MO,TU,WE.
+func ParseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, code := range strings.Split(s, ",") {
+		day, ok := weekdayCodes[strings.ToUpper(strings.TrimSpace(code))]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown week day %q", ErrInvalidQuery, code)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeIntervals(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2021, 3, 1, hour, 0, 0, 0, time.UTC) }
+	merged := MergeIntervals([]Interval{
+		{Start: at(14), End: at(15)},
+		{Start: at(9), End: at(11)},
+		{Start: at(10), End: at(12)},
+		{Start: at(12), End: at(13)},
+		{Start: at(16), End: at(16)},
+		{Start: at(14), End: at(14)},
+	})
+	require.Equal(t, []Interval{{Start: at(9), End: at(13)}, {Start: at(14), End: at(15)}}, merged)
+	require.Empty(t, MergeIntervals(nil))
+}
+
+func TestFreeSlots(t *testing.T) {
+	monday := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(day, hour, minute int) time.Time {
+		return monday.AddDate(0, 0, day).Add(time.Duration(hour*60+minute) * time.Minute)
+	}
+	query := SlotQuery{
+		Duration: time.Hour,
+		From:     at(0, 10, 30),
+		To:       at(7, 0, 0),
+		WorkingHours: WorkingHours{
+			Start: 9 * 60, End: 13 * 60,
+			Days: []time.Weekday{time.Monday, time.Wednesday},
+		},
+		Step:  30 * time.Minute,
+		Limit: 3,
+	}
+	busy := []Interval{{Start: at(0, 11, 0), End: at(0, 12, 0)}, {Start: at(2, 8, 0), End: at(2, 10, 15)}}
+	require.Equal(t, []Interval{
+		{Start: at(0, 12, 0), End: at(0, 13, 0)},
+		{Start: at(2, 10, 15), End: at(2, 11, 15)},
+		{Start: at(2, 10, 45), End: at(2, 11, 45)},
+	}, FreeSlots(busy, query))
+
+	query.Limit = 100
+	slots := FreeSlots(busy, query)
+	require.Len(t, slots, 5, "a slot must end within the working hours")
+	require.Equal(t, at(2, 11, 45), slots[4].Start)
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	query.WorkingHours = WorkingHours{Start: 9 * 60, End: 10 * 60, Location: moscow}
+	query.From, query.To = at(0, 0, 0), at(1, 0, 0)
+	slots = FreeSlots(nil, query)
+	require.Len(t, slots, 1)
+	require.True(t, slots[0].Start.Equal(at(0, 6, 0)))
+}
+
+func TestWorkingHoursDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	hours := WorkingHours{Start: 0, Location: berlin}
+	require.NoError(t, hours.Validate())
+	periods := hours.periods(time.Date(2021, 3, 27, 0, 0, 0, 0, berlin), time.Date(2021, 3, 29, 0, 0, 0, 0, berlin))
+	require.Len(t, periods, 2)
+	require.Equal(t, 24*time.Hour, periods[0].End.Sub(periods[0].Start))
+	require.Equal(t, 23*time.Hour, periods[1].End.Sub(periods[1].Start), "the clocks go forward on the 28th")
+
+	for _, invalid := range []WorkingHours{{Start: -1}, {Start: 10 * 60, End: 9 * 60}, {End: 25 * 60}} {
+		require.ErrorIs(t, invalid.Validate(), ErrInvalidQuery)
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for value, expected := range map[string]int{"09:30": 570, "0:00": 0, "24:00": minutesPerDay} {
+		minutes, err := ParseClock(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, minutes, value)
+	}
+	for _, value := range []string{"", "9", "09:60", "24:01", "-1:00", "aa:bb"} {
+		_, err := ParseClock(value)
+		require.ErrorIs(t, err, ErrInvalidQuery, value)
+	}
+	days, err := ParseWeekdays("mo, FR")
+	require.NoError(t, err)
+	require.Equal(t, []time.Weekday{time.Monday, time.Friday}, days)
+	_, err = ParseWeekdays("MO,XX")
+	require.ErrorIs(t, err, ErrInvalidQuery)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownField = errors.New("unknown or read-only event field")
+
+// Patchable fields of an event named as in its JSON representation.
+const (
+	FieldTitle        = "title"
+	FieldStartTime    = "startTime"
+	FieldTimeZone     = "timeZone"
+	FieldAllDay       = "allDay"
+	FieldDuration     = "duration"
+	FieldDescription  = "description"
+	FieldNotifyTime   = "notifyTime"
+	FieldReminders    = "reminders"
+	FieldRRule        = "rrule"
+	FieldExDates      = "exdates"
+	FieldParentID     = "parentId"
+	FieldRecurrenceID = "recurrenceId"
+)
+
+// Apply copies the listed fields of patch into the event.
+func (e *Event) Apply(patch *Event, fields []string) error {
+	for _, field := range fields {
+		switch field {
+		case FieldTitle:
+			e.Title = patch.Title
+		case FieldStartTime:
+			e.StartTime = patch.StartTime
+		case FieldTimeZone:
+			e.TimeZone = patch.TimeZone
+		case FieldAllDay:
+			e.AllDay = patch.AllDay
+		case FieldDuration:
+			e.Duration = patch.Duration
+		case FieldDescription:
+			e.Description = patch.Description
+		case FieldNotifyTime:
+			e.NotifyTime = patch.NotifyTime
+		case FieldReminders:
+			e.Reminders = patch.Reminders
+		case FieldRRule:
+			e.RRule = patch.RRule
+		case FieldExDates:
+			e.ExDates = patch.ExDates
+		case FieldParentID:
+			e.ParentID = patch.ParentID
+		case FieldRecurrenceID:
+			e.RecurrenceID = patch.RecurrenceID
+		default:
+			return fmt.Errorf("%w: %s", ErrUnknownField, field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	event := Event{ID: 1, Title: "standup", StartTime: start, Duration: 900, Description: "daily", Owner: 2, NotifyTime: 60}
+	patch := Event{Title: "ignored", StartTime: start.Add(time.Hour), Description: ""}
+
+	require.NoError(t, event.Apply(&patch, []string{FieldStartTime, FieldDescription}))
+	require.Equal(t, Event{ID: 1, Title: "standup", StartTime: start.Add(time.Hour), Duration: 900, Owner: 2, NotifyTime: 60}, event)
+
+	require.NoError(t, event.Apply(&Event{Reminders: []int32{3600, 600}}, []string{FieldReminders}))
+	require.Equal(t, []int32{3600, 600}, event.Reminders)
+
+	for _, field := range []string{"owner", "id", "start_time"} {
+		require.ErrorIs(t, event.Apply(&patch, []string{field}), ErrUnknownField)
+	}
+}
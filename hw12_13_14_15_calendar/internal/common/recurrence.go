@@ -0,0 +1,534 @@
+package common
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icalDateFmt     = "20060102"
+	icalDateTimeFmt = "20060102T150405Z"
+	icalLocalFmt    = "20060102T150405"
+
+	// maxPeriods guards the expansion loop against rules which never produce an occurrence
+	// and the windows too long to expand.
+	maxPeriods = 100000
+)
+
+var (
+	ErrInvalidRRule    = errors.New("invalid recurrence rule")
+	ErrRecurrenceLimit = fmt.Errorf("recurrence rule expands into more than %d periods", maxPeriods)
+)
+
+type Frequency string
+
+const (
+	FreqDaily   Frequency = "DAILY"
+	FreqWeekly  Frequency = "WEEKLY"
+	FreqMonthly Frequency = "MONTHLY"
+	FreqYearly  Frequency = "YEARLY"
+)
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// RRule is the supported subset of an iCalendar (RFC 5545) recurrence rule:
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY (without ordinals) and BYMONTHDAY.
+type RRule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []time.Weekday
+	ByMonthDay []int
+}
+
+func ParseRRule(s string) (*RRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty rule", ErrInvalidRRule)
+	}
+	r := &RRule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("%w: malformed part %q", ErrInvalidRRule, part)
+		}
+		if err := r.setPart(strings.ToUpper(kv[0]), strings.ToUpper(kv[1])); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case r.Freq == "":
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRRule)
+	case r.Count != 0 && !r.Until.IsZero():
+		return nil, fmt.Errorf("%w: COUNT and UNTIL are mutually exclusive", ErrInvalidRRule)
+	}
+	return r, nil
+}
+
+func (r *RRule) setPart(key, value string) error {
+	var err error
+	switch key {
+	case "FREQ":
+		switch f := Frequency(value); f {
+		case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+			r.Freq = f
+		default:
+			return fmt.Errorf("%w: unsupported FREQ %s", ErrInvalidRRule, value)
+		}
+	case "INTERVAL":
+		if r.Interval, err = strconv.Atoi(value); err != nil || r.Interval < 1 {
+			return fmt.Errorf("%w: INTERVAL must be a positive integer", ErrInvalidRRule)
+		}
+	case "COUNT":
+		if r.Count, err = strconv.Atoi(value); err != nil || r.Count < 1 {
+			return fmt.Errorf("%w: COUNT must be a positive integer", ErrInvalidRRule)
+		}
+	case "UNTIL":
+		if r.Until, err = parseICalTime(value); err != nil {
+			return fmt.Errorf("%w: unparsable UNTIL %s", ErrInvalidRRule, value)
+		}
+	case "BYDAY":
+		for _, code := range strings.Split(value, ",") {
+			day, ok := weekdayCodes[code]
+			if !ok {
+				return fmt.Errorf("%w: unsupported BYDAY %s", ErrInvalidRRule, code)
+			}
+			r.ByDay = append(r.ByDay, day)
+		}
+	case "BYMONTHDAY":
+		for _, dayStr := range strings.Split(value, ",") {
+			day, err := strconv.Atoi(dayStr)
+			if err != nil || day == 0 || day < -31 || day > 31 {
+				return fmt.Errorf("%w: invalid BYMONTHDAY %s", ErrInvalidRRule, dayStr)
+			}
+			r.ByMonthDay = append(r.ByMonthDay, day)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported part %s", ErrInvalidRRule, key)
+	}
+	return nil
+}
+
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(icalDateTimeFmt))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, 0, len(r.ByDay))
+		for _, day := range r.ByDay {
+			codes = append(codes, strings.ToUpper(day.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, 0, len(r.ByMonthDay))
+		for _, day := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Between returns start times of the occurrences within [from, to) of a series starting at dtstart,
+// ErrRecurrenceLimit if it takes more than maxPeriods periods of the rule to tell.
+func (r *RRule) Between(dtstart, from, to time.Time) ([]time.Time, error) {
+	var result []time.Time
+	err := r.iterate(dtstart, from, to, func(t time.Time) {
+		if !t.Before(from) {
+			result = append(result, t)
+		}
+	})
+	return result, err
+}
+
+// iterate yields the occurrences before to. The rules without COUNT start from the period
+// preceding the one of from, the occurrences of the counted ones are counted from dtstart.
+func (r *RRule) iterate(dtstart, from, to time.Time, yield func(time.Time)) error {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	first := 0
+	if r.Count == 0 {
+		if first = r.periodsBefore(dtstart, from)/interval - 1; first < 0 {
+			first = 0
+		}
+	}
+	emitted := 0
+	for period := first; period < first+maxPeriods; period++ {
+		start, candidates := r.candidates(dtstart, period*interval)
+		if !start.Before(to) {
+			return nil
+		}
+		for _, t := range candidates {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !t.Before(to) || (!r.Until.IsZero() && t.After(r.Until)) || (r.Count > 0 && emitted >= r.Count) {
+				return nil
+			}
+			emitted++
+			yield(t)
+		}
+	}
+	return ErrRecurrenceLimit
+}
+
+// periodsBefore returns the number of the periods of the rule frequency from the one of dtstart
+// to the one of t in the time zone of dtstart, zero if t is before dtstart.
+func (r *RRule) periodsBefore(dtstart, t time.Time) int {
+	if !t.After(dtstart) {
+		return 0
+	}
+	y, m, d := dtstart.Date()
+	ty, tm, td := t.In(dtstart.Location()).Date()
+	days := int(time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC).Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+	switch r.Freq {
+	case FreqDaily:
+		return days
+	case FreqWeekly:
+		return (days + (int(dtstart.Weekday())+6)%7) / 7
+	case FreqMonthly:
+		return (ty-y)*12 + int(tm-m)
+	case FreqYearly:
+		return ty - y
+	}
+	return 0
+}
+
+// candidates returns the beginning of the n-th period after dtstart and the sorted occurrences within it.
+func (r *RRule) candidates(dtstart time.Time, n int) (time.Time, []time.Time) {
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, dtstart.Nanosecond(), loc)
+	}
+	var days []time.Time
+	var start time.Time
+	switch r.Freq {
+	case FreqDaily:
+		start = at(y, m, d+n)
+		days = []time.Time{start}
+	case FreqWeekly:
+		start = at(y, m, d-(int(dtstart.Weekday())+6)%7+7*n)
+		weekdays := r.ByDay
+		if len(weekdays) == 0 {
+			weekdays = []time.Weekday{dtstart.Weekday()}
+		}
+		for _, wd := range weekdays {
+			days = append(days, start.AddDate(0, 0, (int(wd)+6)%7))
+		}
+	case FreqMonthly:
+		start = at(y, m+time.Month(n), 1)
+		days = r.monthDays(start, d)
+	case FreqYearly:
+		start = at(y+n, 1, 1)
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+			if t := at(y+n, m, d); t.Day() == d {
+				days = []time.Time{t}
+			}
+		} else {
+			// Without BYMONTH, BYDAY expands over the whole year and BYMONTHDAY over every month of it.
+			for month := time.January; month <= time.December; month++ {
+				days = append(days, r.monthDays(at(y+n, month, 1), d)...)
+			}
+		}
+	}
+	result := make([]time.Time, 0, len(days))
+	for _, t := range days {
+		if r.matches(t) {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return start, result
+}
+
+// monthDays expands a period into the days of the month starting at first.
+func (r *RRule) monthDays(first time.Time, dtstartDay int) []time.Time {
+	last := daysIn(first)
+	var days []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, md := range r.ByMonthDay {
+			if md < 0 {
+				md = last + md + 1
+			}
+			if md >= 1 && md <= last {
+				days = append(days, first.AddDate(0, 0, md-1))
+			}
+		}
+	case len(r.ByDay) > 0:
+		for i := 0; i < last; i++ {
+			days = append(days, first.AddDate(0, 0, i))
+		}
+	case dtstartDay <= last:
+		days = []time.Time{first.AddDate(0, 0, dtstartDay-1)}
+	}
+	return days
+}
+
+func (r *RRule) matches(t time.Time) bool {
+	if len(r.ByDay) > 0 {
+		ok := false
+		for _, wd := range r.ByDay {
+			if t.Weekday() == wd {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.ByMonthDay) > 0 {
+		last := daysIn(t)
+		for _, md := range r.ByMonthDay {
+			if md == t.Day() || last+md+1 == t.Day() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func parseICalTime(s string) (time.Time, error) {
+	for _, layout := range []string{icalDateTimeFmt, icalLocalFmt, icalDateFmt} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unparsable time %s", s)
+}
+
+// ExDates is a list of excluded occurrences, stored as an iCalendar EXDATE value.
+type ExDates []time.Time
+
+func (d ExDates) Contains(t time.Time) bool {
+	for _, exdate := range d {
+		if exdate.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d ExDates) String() string {
+	dates := make([]string, 0, len(d))
+	for _, t := range d {
+		dates = append(dates, t.UTC().Format(icalDateTimeFmt))
+	}
+	return strings.Join(dates, ",")
+}
+
+func (d ExDates) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *ExDates) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("unsupported exdates type %T", src)
+	}
+	*d = nil
+	if s == "" {
+		return nil
+	}
+	for _, dateStr := range strings.Split(s, ",") {
+		t, err := parseICalTime(dateStr)
+		if err != nil {
+			return err
+		}
+		*d = append(*d, t)
+	}
+	return nil
+}
+
+// Expand returns occurrences of a recurring event starting within [from, to), skipping
+// exception dates and the occurrences replaced by overrides. A non-recurring event is
+// returned as is if it starts within the window. The rule is applied in the time zone
+// of the event and the times of the result are in it.
+func (e *Event) Expand(from, to time.Time, overrides []Event) ([]Event, error) {
+	if e.RRule == "" {
+		if !e.StartTime.Before(from) && e.StartTime.Before(to) {
+			event := *e
+			event.Localize()
+			return []Event{event}, nil
+		}
+		return nil, nil
+	}
+	rule, err := ParseRRule(e.RRule)
+	if err != nil {
+		return nil, err
+	}
+	starts, err := rule.Between(e.StartTime.In(e.Location()), from, to)
+	if err != nil {
+		return nil, err
+	}
+	var result []Event
+	for _, t := range starts {
+		if e.ExDates.Contains(t) || overridden(overrides, e.ID, t) {
+			continue
+		}
+		occurrence := *e
+		occurrence.StartTime = t
+		recurrenceID := t
+		occurrence.RecurrenceID = &recurrenceID
+		result = append(result, occurrence)
+	}
+	return result, nil
+}
+
+// EndedBefore reports whether the event, all the occurrences of a recurring one, ended before t.
+// A series without COUNT or UNTIL never ends, nor does the one too long to expand.
+func (e *Event) EndedBefore(t time.Time) bool {
+	if e.RRule == "" {
+		return e.End().Before(t)
+	}
+	rule, err := ParseRRule(e.RRule)
+	if err != nil {
+		return false
+	}
+	start := e.StartTime.In(e.Location())
+	var occurrences []time.Time
+	switch {
+	case rule.Count != 0:
+		if occurrences, err = rule.Between(start, start, t); err != nil || len(occurrences) < rule.Count {
+			return false
+		}
+	case !rule.Until.IsZero():
+		if !rule.Until.Before(t) {
+			return false
+		}
+		if occurrences, err = rule.Between(start, start, rule.Until.Add(time.Nanosecond)); err != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	if len(occurrences) == 0 {
+		return true
+	}
+	last := *e
+	last.StartTime = occurrences[len(occurrences)-1]
+	return last.End().Before(t)
+}
+
+func overridden(overrides []Event, parentID int64, t time.Time) bool {
+	for _, o := range overrides {
+		if o.ParentID == parentID && o.RecurrenceID != nil && o.RecurrenceID.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func overridesOf(events []Event) []Event {
+	var overrides []Event
+	for _, event := range events {
+		if event.ParentID != 0 {
+			overrides = append(overrides, event)
+		}
+	}
+	return overrides
+}
+
+// ExpandEvents expands events into the occurrences overlapping [from, to), so an event started
+// before the window and lasting into it is there. Overrides (events with ParentID) replace
+// the matching occurrence of their parent series. Fails if any of the series can't be expanded,
+// e.g. with ErrRecurrenceLimit, rather than leave it out.
+func ExpandEvents(events []Event, from, to time.Time) ([]Event, error) {
+	overrides := overridesOf(events)
+	result := make([]Event, 0, len(events))
+	for _, event := range events {
+		occurrences, err := event.Occurrences(from, to, overrides)
+		if err != nil {
+			return nil, fmt.Errorf("expanding event %d: %w", event.ID, err)
+		}
+		result = append(result, occurrences...)
+	}
+	return result, nil
+}
+
+// Occurrences returns the occurrences of the event overlapping [from, to) in its time zone,
+// the event itself if it is a single one overlapping the window.
+func (e *Event) Occurrences(from, to time.Time, overrides []Event) ([]Event, error) {
+	occurrences := []Event{*e}
+	if e.RRule != "" {
+		var err error
+		if occurrences, err = e.Expand(from.Add(-e.maxLength()), to, overrides); err != nil {
+			return nil, err
+		}
+	}
+	result := occurrences[:0]
+	for _, occurrence := range occurrences {
+		if occurrence.Overlaps(from, to) {
+			occurrence.Localize()
+			result = append(result, occurrence)
+		}
+	}
+	return result, nil
+}
+
+// EventsToNotify expands events into the occurrences and returns a notification per reminder due at now
+// and recipient of the occurrence. Fails if any of the series can't be expanded like ExpandEvents.
+func EventsToNotify(events []Event, now time.Time) ([]Notification, error) {
+	overrides := overridesOf(events)
+	var result []Notification
+	for _, event := range events {
+		offsets := event.ReminderOffsets()
+		if len(offsets) == 0 {
+			continue
+		}
+		occurrences, err := event.Expand(now, now.Add(time.Duration(offsets[0])*time.Second), overrides)
+		if err != nil {
+			return nil, fmt.Errorf("expanding event %d: %w", event.ID, err)
+		}
+		for _, occurrence := range occurrences {
+			for _, offset := range occurrence.DueReminders(now) {
+				for _, recipient := range occurrence.Recipients() {
+					notification := occurrence.Notification()
+					notification.Reminder = offset
+					notification.Recipient = recipient
+					notification.Key = notification.IdempotencyKey()
+					result = append(result, *notification)
+				}
+			}
+		}
+	}
+	return result, nil
+}
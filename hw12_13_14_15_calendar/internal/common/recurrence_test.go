@@ -0,0 +1,376 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+		err  bool
+	}{
+		{rule: "FREQ=WEEKLY;BYDAY=MO,WE", want: "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{rule: "RRULE:freq=daily;interval=2;count=5", want: "FREQ=DAILY;INTERVAL=2;COUNT=5"},
+		{rule: "FREQ=MONTHLY;BYMONTHDAY=1,-1;UNTIL=20210101", want: "FREQ=MONTHLY;UNTIL=20210101T000000Z;BYMONTHDAY=1,-1"},
+		{rule: "", err: true},
+		{rule: "INTERVAL=2", err: true},
+		{rule: "FREQ=HOURLY", err: true},
+		{rule: "FREQ=DAILY;COUNT=2;UNTIL=20210101", err: true},
+		{rule: "FREQ=MONTHLY;BYDAY=1MO", err: true},
+		{rule: "FREQ=MONTHLY;BYMONTHDAY=32", err: true},
+		{rule: "FREQ=DAILY;BYSETPOS=1", err: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.rule, func(t *testing.T) {
+			r, err := ParseRRule(test.rule)
+			if test.err {
+				require.ErrorIs(t, err, ErrInvalidRRule)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, r.String())
+		})
+	}
+}
+
+func TestRRuleBetween(t *testing.T) {
+	// 2021-03-01 is a Monday.
+	dtstart := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2021, month, d, 10, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		from    time.Time
+		to      time.Time
+		want    []time.Time
+	}{
+		{
+			name: "daily count",
+			rule: "FREQ=DAILY;COUNT=3",
+			from: dtstart, to: day(4, 1),
+			want: []time.Time{day(3, 1), day(3, 2), day(3, 3)},
+		},
+		{
+			name: "count is taken from dtstart",
+			rule: "FREQ=DAILY;COUNT=3",
+			from: day(3, 2), to: day(4, 1),
+			want: []time.Time{day(3, 2), day(3, 3)},
+		},
+		{
+			name: "weekly by day",
+			rule: "FREQ=WEEKLY;BYDAY=MO,FR",
+			from: dtstart, to: day(3, 13),
+			want: []time.Time{day(3, 1), day(3, 5), day(3, 8), day(3, 12)},
+		},
+		{
+			name: "biweekly until",
+			rule: "FREQ=WEEKLY;INTERVAL=2;UNTIL=20210329T100000Z",
+			from: dtstart, to: day(5, 1),
+			want: []time.Time{day(3, 1), day(3, 15), day(3, 29)},
+		},
+		{
+			name: "monthly by month day",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=15,-1",
+			from: dtstart, to: day(5, 1),
+			want: []time.Time{day(3, 15), day(3, 31), day(4, 15), day(4, 30)},
+		},
+		{
+			name:    "monthly skips short months",
+			rule:    "FREQ=MONTHLY",
+			dtstart: time.Date(2021, 1, 31, 10, 0, 0, 0, time.UTC),
+			from:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), to: day(6, 1),
+			want: []time.Time{time.Date(2021, 1, 31, 10, 0, 0, 0, time.UTC), day(3, 31), day(5, 31)},
+		},
+		{
+			name: "yearly",
+			rule: "FREQ=YEARLY;COUNT=2",
+			from: dtstart, to: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{day(3, 1), time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "yearly by day expands over the year",
+			rule: "FREQ=YEARLY;BYDAY=MO",
+			from: day(4, 1), to: day(5, 1),
+			want: []time.Time{day(4, 5), day(4, 12), day(4, 19), day(4, 26)},
+		},
+		{
+			name: "yearly by month day expands over the months",
+			rule: "FREQ=YEARLY;BYMONTHDAY=15,-1",
+			from: dtstart, to: day(5, 1),
+			want: []time.Time{day(3, 15), day(3, 31), day(4, 15), day(4, 30)},
+		},
+		{
+			name: "yearly by day and month day",
+			rule: "FREQ=YEARLY;BYDAY=FR;BYMONTHDAY=13",
+			from: dtstart, to: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{day(8, 13)},
+		},
+		{
+			name: "empty window",
+			rule: "FREQ=DAILY",
+			from: day(2, 1), to: day(2, 20),
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			r, err := ParseRRule(test.rule)
+			require.NoError(t, err)
+			if test.dtstart.IsZero() {
+				test.dtstart = dtstart
+			}
+			got, err := r.Between(test.dtstart, test.from, test.to)
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestRRuleBetweenFarAhead(t *testing.T) {
+	berlin, err := LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	dtstart := time.Date(2021, 3, 3, 10, 0, 0, 0, berlin)
+	// The expansion starting from dtstart is still within the limit of the periods.
+	from := time.Date(2221, 3, 1, 0, 0, 0, 0, berlin)
+	to := from.AddDate(0, 0, 40)
+	for _, rule := range []string{
+		"FREQ=DAILY;INTERVAL=3", "FREQ=WEEKLY;BYDAY=MO,TH", "FREQ=WEEKLY;INTERVAL=5", "FREQ=MONTHLY;BYMONTHDAY=2,-1", "FREQ=YEARLY;BYDAY=TU",
+	} {
+		r, err := ParseRRule(rule)
+		require.NoError(t, err)
+		all, err := r.Between(dtstart, dtstart, to)
+		require.NoError(t, err, rule)
+		var want []time.Time
+		for _, start := range all {
+			if !start.Before(from) {
+				want = append(want, start)
+			}
+		}
+		got, err := r.Between(dtstart, from, to)
+		require.NoError(t, err, rule)
+		require.NotEmpty(t, got, rule)
+		require.Equal(t, want, got, rule)
+	}
+
+	r, err := ParseRRule("FREQ=DAILY")
+	require.NoError(t, err)
+	_, err = r.Between(dtstart, dtstart, dtstart.AddDate(300, 0, 0))
+	require.ErrorIs(t, err, ErrRecurrenceLimit, "the window is too long")
+	event := Event{StartTime: dtstart, RRule: "FREQ=DAILY"}
+	_, err = event.Expand(dtstart, dtstart.AddDate(300, 0, 0), nil)
+	require.ErrorIs(t, err, ErrRecurrenceLimit)
+}
+
+func TestExpandEvents(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	moved := start.AddDate(0, 0, 2)
+	events := []Event{
+		{ID: 1, Title: "standup", StartTime: start, RRule: "FREQ=DAILY", ExDates: ExDates{start.AddDate(0, 0, 1)}},
+		{ID: 2, Title: "standup moved", StartTime: moved.Add(time.Hour), ParentID: 1, RecurrenceID: &moved},
+		{ID: 3, Title: "single", StartTime: start.Add(time.Hour)},
+		{ID: 4, Title: "outside", StartTime: start.AddDate(0, 1, 0)},
+	}
+	result, err := ExpandEvents(events, start, start.AddDate(0, 0, 4))
+	require.NoError(t, err)
+	titles := make(map[string][]time.Time)
+	for _, event := range result {
+		titles[event.Title] = append(titles[event.Title], event.StartTime)
+	}
+	require.Equal(t, map[string][]time.Time{
+		"standup":       {start, start.AddDate(0, 0, 3)},
+		"standup moved": {moved.Add(time.Hour)},
+		"single":        {start.Add(time.Hour)},
+	}, titles)
+
+	// A series hitting the period limit fails the expansion rather than disappear from it.
+	events = append(events, Event{ID: 5, Title: "counted", StartTime: start.AddDate(-300, 0, 0), RRule: "FREQ=DAILY;COUNT=200000"})
+	_, err = ExpandEvents(events, start, start.AddDate(0, 0, 4))
+	require.ErrorIs(t, err, ErrRecurrenceLimit)
+}
+
+func TestExpandTimeZone(t *testing.T) {
+	berlin, err := LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	start := time.Date(2021, 3, 22, 9, 0, 0, 0, time.UTC)
+	event := Event{ID: 1, StartTime: start, Duration: 3600, TimeZone: "Europe/Berlin", RRule: "FREQ=WEEKLY;COUNT=2"}
+	occurrences, err := event.Expand(start, start.AddDate(0, 1, 0), nil)
+	require.NoError(t, err)
+	require.Len(t, occurrences, 2)
+	for _, occurrence := range occurrences {
+		require.Equal(t, berlin, occurrence.StartTime.Location())
+		require.Equal(t, 10, occurrence.StartTime.Hour(), "the local time is kept across DST")
+	}
+	require.True(t, occurrences[1].StartTime.Equal(time.Date(2021, 3, 29, 8, 0, 0, 0, time.UTC)))
+	require.True(t, event.EndedBefore(time.Date(2021, 3, 29, 9, 30, 0, 0, time.UTC)))
+
+	event.TimeZone = ""
+	occurrences, err = event.Expand(start, start.AddDate(0, 1, 0), nil)
+	require.NoError(t, err)
+	require.Equal(t, 9, occurrences[1].StartTime.Hour())
+
+	_, err = LoadLocation("Mars/Olympus")
+	require.ErrorIs(t, err, ErrUnknownTimeZone)
+	event.TimeZone = "Mars/Olympus"
+	require.Equal(t, time.UTC, event.Location())
+}
+
+func TestAllDay(t *testing.T) {
+	berlin, err := LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// The clocks go forward in Berlin on the 28th of March.
+	event := Event{
+		ID: 1, StartTime: time.Date(2021, 3, 27, 15, 0, 0, 0, berlin), Duration: 36 * 3600,
+		TimeZone: "Europe/Berlin", AllDay: true,
+	}
+	event.AlignAllDay()
+	require.Equal(t, time.Date(2021, 3, 27, 0, 0, 0, 0, berlin), event.StartTime)
+	require.Equal(t, int64(2*24*3600), event.Duration)
+	require.Equal(t, time.Date(2021, 3, 29, 0, 0, 0, 0, berlin), event.End())
+	require.True(t, event.Overlaps(time.Date(2021, 3, 28, 23, 30, 0, 0, berlin), time.Date(2021, 3, 29, 1, 0, 0, 0, berlin)))
+
+	event.RRule = "FREQ=WEEKLY"
+	sunday := time.Date(2021, 4, 4, 0, 0, 0, 0, berlin)
+	occurrences, err := ExpandEvents([]Event{event}, sunday, sunday.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Len(t, occurrences, 1, "the occurrence started on Saturday lasts the Sunday")
+	require.Equal(t, time.Date(2021, 4, 3, 0, 0, 0, 0, berlin), occurrences[0].StartTime)
+	occurrences, err = ExpandEvents([]Event{event}, sunday.AddDate(0, 0, 1), sunday.AddDate(0, 0, 6))
+	require.NoError(t, err)
+	require.Empty(t, occurrences)
+}
+
+func TestExDatesScan(t *testing.T) {
+	var d ExDates
+	require.NoError(t, d.Scan("20210301T100000Z,20210302"))
+	require.Equal(t, ExDates{
+		time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+	}, d)
+	require.Equal(t, "20210301T100000Z,20210302T000000Z", d.String())
+	require.NoError(t, d.Scan(nil))
+	require.Empty(t, d)
+}
+
+func TestExpandOverlapping(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	events := []Event{
+		{ID: 1, Title: "standup", StartTime: start, Duration: 1800, RRule: "FREQ=DAILY"},
+		{ID: 2, Title: "single", StartTime: start.AddDate(0, 0, 1).Add(time.Hour), Duration: 3600},
+		{ID: 3, Title: "instant", StartTime: start.AddDate(0, 0, 1).Add(2 * time.Hour)},
+	}
+	// The window starts in the middle of the second standup.
+	from := start.AddDate(0, 0, 1).Add(15 * time.Minute)
+	result, err := ExpandEvents(events, from, from.Add(2*time.Hour))
+	require.NoError(t, err)
+	titles := make(map[string][]time.Time)
+	for _, event := range result {
+		titles[event.Title] = append(titles[event.Title], event.StartTime)
+	}
+	require.Equal(t, map[string][]time.Time{
+		"standup": {start.AddDate(0, 0, 1)},
+		"single":  {start.AddDate(0, 0, 1).Add(time.Hour)},
+		"instant": {start.AddDate(0, 0, 1).Add(2 * time.Hour)},
+	}, titles)
+}
+
+func TestEndedBefore(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		event Event
+		ended bool
+	}{
+		{"single", Event{StartTime: start, Duration: 3600}, true},
+		{"single in progress", Event{StartTime: start.AddDate(0, 0, 2).Add(-time.Minute), Duration: 3600}, false},
+		{"infinite series", Event{StartTime: start, RRule: "FREQ=DAILY"}, false},
+		{"finished by count", Event{StartTime: start, Duration: 3600, RRule: "FREQ=DAILY;COUNT=2"}, true},
+		{"unfinished by count", Event{StartTime: start, Duration: 3600, RRule: "FREQ=DAILY;COUNT=3"}, false},
+		{"finished by until", Event{StartTime: start, RRule: "FREQ=DAILY;UNTIL=20210302T100000Z"}, true},
+		{"last occurrence in progress", Event{StartTime: start, Duration: 86400, RRule: "FREQ=DAILY;UNTIL=20210302T100000Z"}, false},
+		{"unfinished by until", Event{StartTime: start, RRule: "FREQ=DAILY;UNTIL=20210303T100000Z"}, false},
+		{"invalid rule", Event{StartTime: start, RRule: "FREQ=HOURLY;COUNT=1"}, false},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.ended, test.event.EndedBefore(start.AddDate(0, 0, 2)))
+		})
+	}
+}
+
+func TestEventsToNotify(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	notified := now.Add(-time.Minute)
+	events := []Event{
+		{ID: 1, Title: "due", StartTime: now.Add(time.Minute), NotifyTime: 120},
+		{ID: 2, Title: "notified", StartTime: now.Add(time.Minute), NotifyTime: 180, NotifiedAt: &notified},
+		{ID: 3, Title: "renotified", StartTime: now.Add(time.Minute), NotifyTime: 90, NotifiedAt: &notified},
+		{ID: 4, Title: "started", StartTime: now.Add(-time.Minute), NotifyTime: 120},
+		{ID: 5, Title: "later", StartTime: now.Add(time.Hour), NotifyTime: 120},
+		{ID: 6, Title: "silent", StartTime: now.Add(time.Minute)},
+		{ID: 7, Title: "reminders", StartTime: now.Add(time.Minute), Reminders: []int32{3600, 90, 30}, NotifiedAt: &notified},
+	}
+	type reminder struct {
+		title  string
+		offset int32
+	}
+	var reminders []reminder
+	notifications, err := EventsToNotify(events, now)
+	require.NoError(t, err)
+	for _, n := range notifications {
+		reminders = append(reminders, reminder{n.Title, n.Reminder})
+	}
+	require.Equal(t, []reminder{{"due", 120}, {"renotified", 90}, {"reminders", 90}}, reminders)
+
+	series := Event{ID: 8, StartTime: now.AddDate(0, 0, -1).Add(time.Minute), NotifyTime: 120, RRule: "FREQ=DAILY"}
+	// The notification enqueued yesterday doesn't cover today's occurrence.
+	yesterday := now.AddDate(0, 0, -1).Add(-30 * time.Second)
+	series.NotifiedAt = &yesterday
+	notifications, err = EventsToNotify([]Event{series}, now)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	require.Equal(t, now.Add(time.Minute), notifications[0].EventTime)
+	require.Equal(t, fmt.Sprintf("8:%d:120", now.Add(time.Minute).Unix()), notifications[0].Key)
+	// The notification enqueued after today's window opened does.
+	justNow := now.Add(-30 * time.Second)
+	series.NotifiedAt = &justNow
+	notifications, err = EventsToNotify([]Event{series}, now)
+	require.NoError(t, err)
+	require.Empty(t, notifications)
+
+	meeting := Event{ID: 9, Owner: 1, StartTime: now.Add(time.Minute), NotifyTime: 120, Attendees: []Attendee{
+		{User: 2, Status: StatusAccepted},
+		{User: 3, Status: StatusDeclined},
+		{User: 4, Status: StatusPending},
+		{User: 5, Status: StatusAccepted},
+	}}
+	notifications, err = EventsToNotify([]Event{meeting}, now)
+	require.NoError(t, err)
+	recipients := make([]int64, 0, len(notifications))
+	for _, n := range notifications {
+		require.Equal(t, int64(1), n.Owner)
+		recipients = append(recipients, n.Recipient)
+	}
+	require.Equal(t, []int64{1, 2, 5}, recipients, "the owner and the attendees who accepted")
+	require.Equal(t, fmt.Sprintf("9:%d:120", now.Add(time.Minute).Unix()), notifications[0].Key)
+	require.Equal(t, fmt.Sprintf("9:%d:120:2", now.Add(time.Minute).Unix()), notifications[1].Key)
+
+	counted := Event{ID: 10, StartTime: now.AddDate(-300, 0, 0).Add(time.Minute), NotifyTime: 120, RRule: "FREQ=DAILY;COUNT=200000"}
+	_, err = EventsToNotify([]Event{meeting, counted}, now)
+	require.ErrorIs(t, err, ErrRecurrenceLimit)
+}
+
+func TestReminderOffsets(t *testing.T) {
+	event := Event{NotifyTime: 600, Reminders: []int32{60, 86400, 600, 0, 3600}}
+	require.Equal(t, []int32{86400, 3600, 600, 60}, event.ReminderOffsets())
+	require.Empty(t, (&Event{}).ReminderOffsets())
+}
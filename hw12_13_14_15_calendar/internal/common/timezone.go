@@ -0,0 +1,48 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrUnknownTimeZone = errors.New("unknown time zone")
+
+// locations caches the loaded time zones, time.LoadLocation reads the zone database every time.
+var locations sync.Map
+
+// LoadLocation returns the IANA time zone with the name, UTC for the empty name.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if location, ok := locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTimeZone, name)
+	}
+	locations.Store(name, location)
+	return location, nil
+}
+
+// Location returns the time zone of the event, UTC if it is not set or unknown.
+func (e *Event) Location() *time.Location {
+	location, err := LoadLocation(e.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// Localize converts the start time and the recurrence ID of the event into its time zone.
+func (e *Event) Localize() {
+	location := e.Location()
+	e.StartTime = e.StartTime.In(location)
+	if e.RecurrenceID != nil {
+		recurrenceID := e.RecurrenceID.In(location)
+		e.RecurrenceID = &recurrenceID
+	}
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldError describes why a field of an event is invalid, the field is named as in JSON.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+	// Err is the sentinel error of the reason if there is one.
+	Err error `json:"-"`
+}
+
+// ValidationError lists all the invalid fields of an event.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		reasons = append(reasons, field.Field+": "+field.Reason)
+	}
+	return "invalid event: " + strings.Join(reasons, "; ")
+}
+
+// Is reports whether any of the field errors is target.
+func (e *ValidationError) Is(target error) bool {
+	for _, field := range e.Fields {
+		if field.Err != nil && errors.Is(field.Err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/notifier"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCapacity = 10000
+	defaultTTL      = 48 * time.Hour
+	defaultLease    = 20 * time.Second
+)
+
+// ErrInFlight is returned by Store.Claim if the notification is being delivered by another claim.
+var ErrInFlight = errors.New("notification is being delivered")
+
+// Store remembers the keys of the delivered notifications for a while.
+type Store interface {
+	// Claim leases the key atomically and reports whether it was neither delivered within the TTL
+	// of the store nor leased within the lease, so of the concurrent claims of a key only one succeeds.
+	// Returns ErrInFlight if the key is leased.
+	Claim(ctx context.Context, key string) (bool, error)
+	// Mark remembers the claimed key as delivered for the TTL of the store.
+	Mark(ctx context.Context, key string) error
+	// Release forgets the claimed key of a notification which failed to be delivered.
+	Release(ctx context.Context, key string) error
+}
+
+// Conf configures the store of the delivered notifications.
+type Conf struct {
+	// Type is "memory" (the default), "postgres" or "none" to deliver the duplicates.
+	Type string `json:"type"`
+	// Capacity of the memory store, the least recently delivered keys are evicted first.
+	Capacity int `json:"capacity"`
+	// TTL of a key, such as "48h", it should exceed the time a notification may spend in the queue.
+	TTL string `json:"ttl"`
+	// Lease of a key claimed for a delivery, such as "20s", it should exceed the time of a delivery
+	// and fall within the retries of the queue. The duplicates arriving within it are retried,
+	// so a sender crashed during a delivery delays the notification by the lease rather than lose it.
+	Lease string `json:"lease"`
+}
+
+// NewStore returns the store selected by conf, nil if deduplication is off. dsn is the database of the postgres store.
+func NewStore(ctx context.Context, log *logrus.Logger, conf Conf, dsn string) (Store, error) {
+	ttl := defaultTTL
+	if conf.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(conf.TTL); err != nil {
+			return nil, fmt.Errorf("invalid ttl of deduplication: %w", err)
+		}
+	}
+	lease := defaultLease
+	if conf.Lease != "" {
+		var err error
+		if lease, err = time.ParseDuration(conf.Lease); err != nil {
+			return nil, fmt.Errorf("invalid lease of deduplication: %w", err)
+		}
+	}
+	switch strings.ToLower(conf.Type) {
+	case "", "memory":
+		return NewLRU(conf.Capacity, ttl, lease), nil
+	case "postgres":
+		return NewPostgres(ctx, log, dsn, ttl, lease)
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown deduplication store %q", conf.Type)
+	}
+}
+
+// Notifier drops the notifications delivered already, the key of a notification is made
+// of the event ID and the reminder time.
+type Notifier struct {
+	log   *logrus.Logger
+	store Store
+	next  notifier.Notifier
+	// channel tells apart the keys of the deliveries via the channels sharing the store.
+	channel    string
+	suppressed *int64
+}
+
+func NewNotifier(log *logrus.Logger, store Store, next notifier.Notifier) *Notifier {
+	return &Notifier{log: log, store: store, next: next, suppressed: new(int64)}
+}
+
+// Channels deduplicates the deliveries via each of the channels apart, so a retry of a notification
+// which failed on some of the channels skips the ones it was delivered via.
+type Channels struct {
+	log        *logrus.Logger
+	store      Store
+	suppressed int64
+}
+
+func NewChannels(log *logrus.Logger, store Store) *Channels {
+	return &Channels{log: log, store: store}
+}
+
+// Wrap returns the notifier of the named channel dropping the notifications delivered via it already.
+func (c *Channels) Wrap(name string, channel notifier.Notifier) notifier.Notifier {
+	return &Notifier{log: c.log, store: c.store, next: channel, channel: name, suppressed: &c.suppressed}
+}
+
+// Suppressed returns the number of the dropped duplicates of all the channels.
+func (c *Channels) Suppressed() int64 {
+	return atomic.LoadInt64(&c.suppressed)
+}
+
+// Notify delivers the notification via the next notifier if it claims the key of the notification
+// and marks the key delivered afterwards, the key is released if the delivery fails to let the retry
+// through. A notification being delivered by another claim fails with ErrInFlight to be retried
+// once the delivery is marked or its lease expires. A failing store lets the notification through,
+// a duplicate is preferred to a loss.
+func (n *Notifier) Notify(ctx context.Context, notification *common.Notification) error {
+	key := notification.Key
+	if key == "" {
+		key = notification.IdempotencyKey()
+	}
+	if n.channel != "" {
+		key += "/" + n.channel
+	}
+	claimed, err := n.store.Claim(ctx, key)
+	if errors.Is(err, ErrInFlight) {
+		return fmt.Errorf("%s%s: %w", notification, n.via(), err)
+	}
+	if err != nil {
+		n.log.Warn("failed to check for a duplicate notification: ", err)
+		return n.next.Notify(ctx, notification)
+	}
+	if !claimed {
+		suppressed := atomic.AddInt64(n.suppressed, 1)
+		n.log.Debugf("suppressed duplicate notification %s%s (%d in total)", notification, n.via(), suppressed)
+		return nil
+	}
+	if err = n.next.Notify(ctx, notification); err != nil {
+		if releaseErr := n.store.Release(ctx, key); releaseErr != nil {
+			n.log.Warn("failed to release an undelivered notification: ", releaseErr)
+		}
+		return err
+	}
+	if err = n.store.Mark(ctx, key); err != nil {
+		n.log.Warn("failed to mark a delivered notification: ", err)
+	}
+	return nil
+}
+
+// Suppressed returns the number of the dropped duplicates.
+func (n *Notifier) Suppressed() int64 {
+	return atomic.LoadInt64(n.suppressed)
+}
+
+func (n *Notifier) via() string {
+	if n.channel == "" {
+		return ""
+	}
+	return " via " + n.channel
+}
@@ -0,0 +1,174 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/notifier"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type testNotifier struct {
+	mu        sync.Mutex
+	delivered []common.Notification
+	err       error
+}
+
+func (n *testNotifier) Notify(_ context.Context, notification *common.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.delivered = append(n.delivered, *notification)
+	return nil
+}
+
+func TestNotifier(t *testing.T) {
+	ctx := context.Background()
+	next := &testNotifier{}
+	n := NewNotifier(logrus.New(), NewLRU(10, time.Hour, time.Minute), next)
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	reminder := common.Notification{ID: 1, EventTime: start, Reminder: 300}
+	reminder.Key = reminder.IdempotencyKey()
+
+	next.err = errors.New("smtp is down")
+	require.Error(t, n.Notify(ctx, &reminder))
+	next.err = nil
+	require.NoError(t, n.Notify(ctx, &reminder), "a failed notification is not remembered")
+	require.NoError(t, n.Notify(ctx, &reminder))
+	unkeyed := common.Notification{ID: 1, EventTime: start, Reminder: 300}
+	require.NoError(t, n.Notify(ctx, &unkeyed), "the key of the notifications without one is made the same way")
+	another := common.Notification{ID: 1, EventTime: start, Reminder: 600}
+	require.NoError(t, n.Notify(ctx, &another))
+	nextDay := common.Notification{ID: 1, EventTime: start.AddDate(0, 0, 1), Reminder: 300}
+	require.NoError(t, n.Notify(ctx, &nextDay))
+
+	require.Len(t, next.delivered, 3)
+	require.Equal(t, int64(2), n.Suppressed())
+}
+
+func TestNotifierConcurrent(t *testing.T) {
+	ctx := context.Background()
+	next := &testNotifier{}
+	n := NewNotifier(logrus.New(), NewLRU(10, time.Hour, time.Minute), next)
+	reminder := common.Notification{ID: 1, EventTime: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC), Reminder: 300}
+	var wg sync.WaitGroup
+	var inFlight int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notification := reminder
+			if err := n.Notify(ctx, &notification); err != nil {
+				require.ErrorIs(t, err, ErrInFlight)
+				atomic.AddInt64(&inFlight, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, next.delivered, 1)
+	require.Equal(t, int64(49), n.Suppressed()+inFlight, "the duplicates are either dropped or retried")
+}
+
+func TestNotifierCrash(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	store := NewLRU(10, time.Hour, time.Minute)
+	store.now = func() time.Time { return now }
+	next := &testNotifier{}
+	n := NewNotifier(logrus.New(), store, next)
+	reminder := common.Notification{ID: 1, EventTime: now, Reminder: 300}
+	reminder.Key = reminder.IdempotencyKey()
+
+	// The sender crashed after claiming the key.
+	claimed, err := store.Claim(ctx, reminder.Key)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	require.ErrorIs(t, n.Notify(ctx, &reminder), ErrInFlight, "the redelivery is retried rather than dropped")
+	now = now.Add(time.Minute)
+	require.NoError(t, n.Notify(ctx, &reminder), "the lease expires")
+	require.Len(t, next.delivered, 1)
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, n.Notify(ctx, &reminder))
+	require.Len(t, next.delivered, 1, "the delivered key is kept for the ttl")
+	require.Equal(t, int64(1), n.Suppressed())
+}
+
+func TestChannels(t *testing.T) {
+	ctx := context.Background()
+	log := logrus.New()
+	email, webhook := &testNotifier{}, &testNotifier{err: errors.New("webhook is down")}
+	multi, err := notifier.NewMulti(log, nil, nil)
+	require.NoError(t, err)
+	multi.Add("email", email)
+	multi.Add("webhook", webhook)
+	channels := NewChannels(log, NewLRU(10, time.Hour, time.Minute))
+	multi.Wrap(channels.Wrap)
+	reminder := common.Notification{ID: 1, EventTime: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC), Reminder: 300}
+
+	require.Error(t, multi.Notify(ctx, &reminder))
+	webhook.err = nil
+	require.NoError(t, multi.Notify(ctx, &reminder), "the retry goes to the failed channel only")
+	require.NoError(t, multi.Notify(ctx, &reminder))
+	require.Len(t, email.delivered, 1)
+	require.Len(t, webhook.delivered, 1)
+	require.Equal(t, int64(3), channels.Suppressed())
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := NewLRU(2, time.Hour, time.Minute)
+	s.now = func() time.Time { return now }
+	claim := func(key string) bool {
+		claimed, err := s.Claim(ctx, key)
+		require.NoError(t, err)
+		return claimed
+	}
+	deliver := func(key string) {
+		require.True(t, claim(key))
+		require.NoError(t, s.Mark(ctx, key))
+	}
+
+	deliver("a")
+	deliver("b")
+	require.False(t, claim("a"))
+	now = now.Add(time.Minute)
+	deliver("c")
+	deliver("a")
+	require.False(t, claim("c"))
+	require.Equal(t, 2, s.Len(), "the least recently stored key is evicted")
+
+	require.NoError(t, s.Release(ctx, "c"))
+	require.True(t, claim("c"), "the released key is claimed again")
+	_, err := s.Claim(ctx, "c")
+	require.ErrorIs(t, err, ErrInFlight)
+	now = now.Add(time.Minute)
+	require.True(t, claim("c"), "the lease expires")
+
+	now = now.Add(time.Hour)
+	require.True(t, claim("a"), "the key expires")
+	require.Equal(t, 2, s.Len())
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := NewStore(context.Background(), logrus.New(), Conf{}, "")
+	require.NoError(t, err)
+	require.IsType(t, &LRU{}, store)
+	store, err = NewStore(context.Background(), logrus.New(), Conf{Type: "none"}, "")
+	require.NoError(t, err)
+	require.Nil(t, store)
+	_, err = NewStore(context.Background(), logrus.New(), Conf{TTL: "two days"}, "")
+	require.Error(t, err)
+	_, err = NewStore(context.Background(), logrus.New(), Conf{Lease: "a minute"}, "")
+	require.Error(t, err)
+	_, err = NewStore(context.Background(), logrus.New(), Conf{Type: "redis"}, "")
+	require.Error(t, err)
+}
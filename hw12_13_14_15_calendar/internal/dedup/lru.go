@@ -0,0 +1,98 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key       string
+	expires   time.Time
+	delivered bool
+}
+
+// LRU is a store of a limited number of keys within the process memory.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	lease    time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+// NewLRU returns a store of up to capacity keys, defaultCapacity if it isn't positive,
+// the claimed keys are kept for lease and the delivered ones for ttl.
+func NewLRU(capacity int, ttl, lease time.Duration) *LRU {
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	return &LRU{
+		capacity: capacity,
+		ttl:      ttl,
+		lease:    lease,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+func (s *LRU) Claim(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		e := element.Value.(*entry)
+		if s.now().Before(e.expires) {
+			if !e.delivered {
+				return false, ErrInFlight
+			}
+			return false, nil
+		}
+	}
+	s.put(key, s.lease, false)
+	return true, nil
+}
+
+func (s *LRU) Mark(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, s.ttl, true)
+	return nil
+}
+
+func (s *LRU) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *LRU) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// put stores the key for ttl evicting the least recently stored key if the store is full,
+// it must be called with the lock held.
+func (s *LRU) put(key string, ttl time.Duration, delivered bool) {
+	if element, ok := s.entries[key]; ok {
+		e := element.Value.(*entry)
+		e.expires = s.now().Add(ttl)
+		e.delivered = delivered
+		s.order.MoveToFront(element)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&entry{key: key, expires: s.now().Add(ttl), delivered: delivered})
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).key)
+	}
+}
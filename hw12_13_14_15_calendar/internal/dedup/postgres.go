@@ -0,0 +1,103 @@
+package dedup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	purgeInterval = time.Minute
+
+	// claimQuery leases the key or renews the expired one, it affects no rows if the key is delivered
+	// within $2 seconds or leased within $3 seconds.
+	claimQuery = `
+INSERT INTO delivered_notifications (key, delivered) VALUES ($1, false)
+ON CONFLICT (key) DO UPDATE SET delivered_at = now(), delivered = false
+WHERE delivered_notifications.delivered_at <= now() -
+      CASE WHEN delivered_notifications.delivered THEN $2 ELSE $3 END * interval '1 second'`
+	deliveredQuery = `SELECT delivered FROM delivered_notifications WHERE key = $1`
+	markQuery      = `
+INSERT INTO delivered_notifications (key, delivered) VALUES ($1, true)
+ON CONFLICT (key) DO UPDATE SET delivered_at = now(), delivered = true`
+	releaseQuery = `DELETE FROM delivered_notifications WHERE key = $1`
+	purgeQuery   = `
+DELETE FROM delivered_notifications
+WHERE delivered_at <= now() - CASE WHEN delivered THEN $1 ELSE $2 END * interval '1 second'`
+)
+
+// Postgres is a store in the delivered_notifications table shared by the senders.
+type Postgres struct {
+	db  *sqlx.DB
+	log *logrus.Logger
+	ttl time.Duration
+	// lease is the time a claimed key is kept for its delivery to be marked.
+	lease time.Duration
+	// mu guards purged, the time of the last removal of the expired keys.
+	mu     sync.Mutex
+	purged time.Time
+}
+
+func NewPostgres(ctx context.Context, log *logrus.Logger, dsn string, ttl, lease time.Duration) (*Postgres, error) {
+	db, err := sqlx.ConnectContext(ctx, "pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{db: db, log: log, ttl: ttl, lease: lease}, nil
+}
+
+// Claim leases the key unless it is delivered or leased and removes the expired keys at most once
+// per purgeInterval, a failure to remove them is only logged as it doesn't affect the claim.
+func (s *Postgres) Claim(ctx context.Context, key string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, claimQuery, key, s.ttl.Seconds(), s.lease.Seconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	purge := time.Since(s.purged) >= purgeInterval
+	if purge {
+		s.purged = time.Now()
+	}
+	s.mu.Unlock()
+	if purge {
+		if _, err = s.db.ExecContext(ctx, purgeQuery, s.ttl.Seconds(), s.lease.Seconds()); err != nil {
+			s.log.Warn("failed to purge the expired notification keys: ", err)
+		}
+	}
+	if n > 0 {
+		return true, nil
+	}
+	var delivered bool
+	err = sqlx.GetContext(ctx, s.db, &delivered, deliveredQuery, key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if !delivered {
+		// The key released or purged since the claim is retried later as well.
+		return false, ErrInFlight
+	}
+	return false, nil
+}
+
+func (s *Postgres) Mark(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, markQuery, key)
+	return err
+}
+
+func (s *Postgres) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, releaseQuery, key)
+	return err
+}
+
+func (s *Postgres) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,349 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+var (
+	ErrInvalidCalendar = errors.New("invalid iCalendar object")
+	ErrInvalidEvent    = errors.New("invalid VEVENT")
+)
+
+var durationRe = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Item is a VEVENT of an imported calendar, Err tells why it can't be turned into an event.
+// Event.RecurrenceID is set for a modified occurrence of the recurring event with the same UID,
+// it is to be imported as an override of that event.
+type Item struct {
+	UID   string
+	Event common.Event
+	Err   error
+}
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func (p *property) param(name string) string {
+	return p.params[name]
+}
+
+type component struct {
+	props  []property
+	alarms [][]property
+	err    error
+}
+
+// Decode returns the VEVENTs of a VCALENDAR in the order they appear in. DTSTART is required,
+// the length comes from DTEND or DURATION, a date without either lasts a day. Times with a TZID
+// are read in that IANA time zone, which becomes the time zone of the event, and floating times in UTC. The VALARMs triggering before
+// the start become reminders, the rest of them are ignored as are the other components.
+// It fails with ErrInvalidCalendar if the object is malformed as a whole, the errors
+// of particular VEVENTs are reported by their items.
+func Decode(r io.Reader) ([]Item, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		items    []Item
+		stack    []string
+		event    *component
+		calendar bool
+	)
+	for i, line := range lines {
+		prop, err := parseProperty(line)
+		if err != nil {
+			if event == nil {
+				return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidCalendar, i+1, err)
+			}
+			if event.err == nil {
+				event.err = err
+			}
+			continue
+		}
+		switch prop.name {
+		case "BEGIN":
+			name := strings.ToUpper(prop.value)
+			switch {
+			case len(stack) == 0 && name != "VCALENDAR":
+				return nil, fmt.Errorf("%w: line %d: %s outside of VCALENDAR", ErrInvalidCalendar, i+1, name)
+			case len(stack) == 1 && name == "VEVENT":
+				event = &component{}
+			case len(stack) == 2 && event != nil && name == "VALARM":
+				event.alarms = append(event.alarms, nil)
+			}
+			calendar = true
+			stack = append(stack, name)
+		case "END":
+			name := strings.ToUpper(prop.value)
+			if len(stack) == 0 || stack[len(stack)-1] != name {
+				return nil, fmt.Errorf("%w: line %d: unexpected END:%s", ErrInvalidCalendar, i+1, name)
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 1 && name == "VEVENT" {
+				items = append(items, decodeEvent(event))
+				event = nil
+			}
+		default:
+			switch {
+			case event == nil:
+			case len(stack) == 2:
+				event.props = append(event.props, prop)
+			case len(stack) == 3 && stack[2] == "VALARM":
+				event.alarms[len(event.alarms)-1] = append(event.alarms[len(event.alarms)-1], prop)
+			}
+		}
+	}
+	if !calendar {
+		return nil, fmt.Errorf("%w: no VCALENDAR", ErrInvalidCalendar)
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%w: %s is not closed", ErrInvalidCalendar, stack[len(stack)-1])
+	}
+	return items, nil
+}
+
+// unfold returns the content lines joining the folded ones.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case line == "":
+		case (line[0] == ' ' || line[0] == '\t') && len(lines) > 0:
+			lines[len(lines)-1] += line[1:]
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCalendar, err)
+	}
+	return lines, nil
+}
+
+// parseProperty splits a content line into the upper-cased name, the parameters and the value.
+func parseProperty(line string) (property, error) {
+	prop := property{params: make(map[string]string)}
+	quoted := false
+	start, param := 0, ""
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '=' && prop.name != "" && param == "":
+			param = strings.ToUpper(line[start:i])
+			start = i + 1
+		case r == ';' || r == ':':
+			if prop.name == "" {
+				prop.name = strings.ToUpper(line[:i])
+			} else if param != "" {
+				prop.params[param] = strings.Trim(line[start:i], `"`)
+				param = ""
+			}
+			start = i + 1
+			if r == ':' {
+				prop.value = line[i+1:]
+				if prop.name == "" {
+					return prop, fmt.Errorf("no property name in %q", line)
+				}
+				return prop, nil
+			}
+		}
+	}
+	return prop, fmt.Errorf("no value in %q", line)
+}
+
+func decodeEvent(c *component) Item {
+	item := Item{Err: c.err}
+	var start, end, duration *property
+	fail := func(format string, args ...interface{}) {
+		if item.Err == nil {
+			item.Err = fmt.Errorf("%w: %s", ErrInvalidEvent, fmt.Sprintf(format, args...))
+		}
+	}
+	for i := range c.props {
+		prop := &c.props[i]
+		switch prop.name {
+		case "UID":
+			item.UID = prop.value
+		case "DTSTART":
+			start = prop
+		case "DTEND":
+			end = prop
+		case "DURATION":
+			duration = prop
+		case "SUMMARY":
+			item.Event.Title = unescapeText(prop.value)
+		case "DESCRIPTION":
+			item.Event.Description = unescapeText(prop.value)
+		case "RRULE":
+			item.Event.RRule = prop.value
+		case "RECURRENCE-ID":
+			recurrenceID, _, err := parseTime(prop, prop.value)
+			if err != nil {
+				fail("RECURRENCE-ID: %s", err)
+				break
+			}
+			item.Event.RecurrenceID = &recurrenceID
+		case "EXDATE":
+			for _, value := range strings.Split(prop.value, ",") {
+				exdate, _, err := parseTime(prop, value)
+				if err != nil {
+					fail("EXDATE: %s", err)
+					break
+				}
+				item.Event.ExDates = append(item.Event.ExDates, exdate)
+			}
+		}
+	}
+	if start == nil {
+		fail("DTSTART is missing")
+		return item
+	}
+	startTime, allDay, err := parseTime(start, start.value)
+	if err != nil {
+		fail("DTSTART: %s", err)
+		return item
+	}
+	item.Event.StartTime = startTime
+	item.Event.TimeZone = start.param("TZID")
+	item.Event.AllDay = allDay
+	var length time.Duration
+	switch {
+	case end != nil && duration != nil:
+		fail("both DTEND and DURATION are set")
+	case end != nil:
+		endTime, _, err := parseTime(end, end.value)
+		if err != nil {
+			fail("DTEND: %s", err)
+		}
+		length = endTime.Sub(startTime)
+	case duration != nil:
+		if length, err = parseDuration(duration.value); err != nil {
+			fail("DURATION: %s", err)
+		}
+	case allDay:
+		length = 24 * time.Hour
+	}
+	if length < 0 {
+		fail("the event ends before it starts")
+	}
+	item.Event.Duration = int64(length / time.Second)
+	item.Event.Reminders = reminders(c.alarms, startTime, length)
+	return item
+}
+
+// reminders returns the offsets before the start of the alarms triggering before it.
+func reminders(alarms [][]property, start time.Time, length time.Duration) []int32 {
+	var offsets []int32
+	for _, alarm := range alarms {
+		for i := range alarm {
+			trigger := &alarm[i]
+			if trigger.name != "TRIGGER" {
+				continue
+			}
+			var at time.Time
+			if strings.EqualFold(trigger.param("VALUE"), "DATE-TIME") {
+				t, _, err := parseTime(trigger, trigger.value)
+				if err != nil {
+					continue
+				}
+				at = t
+			} else {
+				d, err := parseDuration(trigger.value)
+				if err != nil {
+					continue
+				}
+				at = start.Add(d)
+				if strings.EqualFold(trigger.param("RELATED"), "END") {
+					at = at.Add(length)
+				}
+			}
+			if offset := start.Sub(at) / time.Second; offset > 0 {
+				offsets = append(offsets, int32(offset))
+			}
+		}
+	}
+	return offsets
+}
+
+// parseTime parses a DATE or DATE-TIME value of the property and reports whether it is a date.
+func parseTime(prop *property, value string) (time.Time, bool, error) {
+	location := time.UTC
+	if tzid := prop.param("TZID"); tzid != "" {
+		var err error
+		if location, err = common.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown time zone %q", tzid)
+		}
+	}
+	if strings.EqualFold(prop.param("VALUE"), "DATE") || len(value) == len(dateFmt) {
+		t, err := time.ParseInLocation(dateFmt, value, location)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(dateTimeFmt, value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation(localFmt, value, location)
+	return t, false, err
+}
+
+// parseDuration parses an RFC 5545 duration such as -PT15M or P1W.
+func parseDuration(value string) (time.Duration, error) {
+	match := durationRe.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("unparsable duration %q", value)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(match[i+2], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("unparsable duration %q", value)
+		}
+		d += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// unescapeText reverts the escaping of a TEXT value.
+func unescapeText(value string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped && (r == 'n' || r == 'N'):
+			b.WriteRune('\n')
+		case escaped:
+			b.WriteRune(r)
+		case r == '\\':
+			escaped = true
+			continue
+		default:
+			b.WriteRune(r)
+		}
+		escaped = false
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+// Package ical converts events to and from iCalendar (RFC 5545) VCALENDAR objects.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+const (
+	ContentType = "text/calendar; charset=utf-8"
+	prodID      = "-//otus//calendar//EN"
+	// uidDomain makes the UIDs of the exported events globally unique as RFC 5545 recommends.
+	uidDomain = "calendar"
+
+	dateFmt     = "20060102"
+	dateTimeFmt = "20060102T150405Z"
+	localFmt    = "20060102T150405"
+	// maxLineLength is the limit of a content line in octets, longer lines are folded.
+	maxLineLength = 75
+)
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// Encode writes the events as a VCALENDAR stamped with now. Every occurrence of a recurring series
+// is a VEVENT of its own, its UID is made of the series ID and the original start of the occurrence,
+// so an override has the UID of the occurrence it replaces. Reminders become display VALARMs.
+func Encode(w io.Writer, events []common.Event, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	l := &lineWriter{w: bw}
+	l.line("BEGIN", "VCALENDAR")
+	l.line("VERSION", "2.0")
+	l.line("PRODID", prodID)
+	l.line("CALSCALE", "GREGORIAN")
+	for i := range events {
+		encodeEvent(l, &events[i], now)
+	}
+	l.line("END", "VCALENDAR")
+	if l.err != nil {
+		return l.err
+	}
+	return bw.Flush()
+}
+
+func encodeEvent(l *lineWriter, event *common.Event, now time.Time) {
+	l.line("BEGIN", "VEVENT")
+	l.line("UID", UID(event))
+	l.line("DTSTAMP", now.UTC().Format(dateTimeFmt))
+	if event.AllDay {
+		// The dates of an all-day event are the ones of its time zone.
+		l.line("DTSTART;VALUE=DATE", event.StartTime.In(event.Location()).Format(dateFmt))
+		l.line("DTEND;VALUE=DATE", event.End().In(event.Location()).Format(dateFmt))
+	} else {
+		l.line("DTSTART", event.StartTime.UTC().Format(dateTimeFmt))
+		l.line("DURATION", formatDuration(time.Duration(event.Duration)*time.Second))
+	}
+	l.line("SUMMARY", textEscaper.Replace(event.Title))
+	if event.Description != "" {
+		l.line("DESCRIPTION", textEscaper.Replace(event.Description))
+	}
+	if !event.Updated.IsZero() {
+		l.line("LAST-MODIFIED", event.Updated.UTC().Format(dateTimeFmt))
+	}
+	if event.Version != 0 {
+		l.line("SEQUENCE", strconv.FormatInt(event.Version-1, 10))
+	}
+	for _, offset := range event.ReminderOffsets() {
+		l.line("BEGIN", "VALARM")
+		l.line("ACTION", "DISPLAY")
+		l.line("TRIGGER", "-"+formatDuration(time.Duration(offset)*time.Second))
+		l.line("DESCRIPTION", textEscaper.Replace(event.Title))
+		l.line("END", "VALARM")
+	}
+	l.line("END", "VEVENT")
+}
+
+// UID returns the UID of the exported event.
+func UID(event *common.Event) string {
+	seriesID := event.ID
+	if event.ParentID != 0 {
+		seriesID = event.ParentID
+	}
+	if event.RecurrenceID == nil {
+		return fmt.Sprintf("%d@%s", seriesID, uidDomain)
+	}
+	return fmt.Sprintf("%d-%s@%s", seriesID, event.RecurrenceID.UTC().Format(dateTimeFmt), uidDomain)
+}
+
+// formatDuration returns d as an RFC 5545 duration such as P1DT2H30M.
+func formatDuration(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	if seconds == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	b.WriteString("P")
+	if days := seconds / 86400; days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+		seconds %= 86400
+	}
+	if seconds > 0 {
+		b.WriteString("T")
+		for _, unit := range []struct {
+			seconds int64
+			name    string
+		}{{3600, "H"}, {60, "M"}, {1, "S"}} {
+			if n := seconds / unit.seconds; n > 0 {
+				fmt.Fprintf(&b, "%d%s", n, unit.name)
+				seconds %= unit.seconds
+			}
+		}
+	}
+	return b.String()
+}
+
+// lineWriter writes CRLF-terminated content lines folded at maxLineLength octets and keeps the first error.
+type lineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (l *lineWriter) line(name, value string) {
+	if l.err != nil {
+		return
+	}
+	line := name + ":" + value
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		// Folding must not split a multi-octet character.
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if _, l.err = l.w.WriteString(line[:cut] + "\r\n "); l.err != nil {
+			return
+		}
+		line = line[cut:]
+		// The leading space of a continuation line counts towards its length.
+		limit = maxLineLength - 1
+	}
+	_, l.err = l.w.WriteString(line + "\r\n")
+}
@@ -0,0 +1,174 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	recurrenceID := start.AddDate(0, 0, 1)
+	events := []common.Event{
+		{
+			ID: 1, Title: "review; part 1, draft", StartTime: start, Duration: 5400, Description: "line\nnext",
+			NotifyTime: 600, Reminders: []int32{86400}, Version: 2,
+		},
+		{ID: 2, Title: "standup", StartTime: recurrenceID, Duration: 900, RRule: "FREQ=DAILY", RecurrenceID: &recurrenceID},
+		{ID: 3, Title: strings.Repeat("очень длинное название ", 5), StartTime: start},
+		{ID: 4, Title: "holiday", StartTime: start.Add(-10 * time.Hour), Duration: 2 * 86400, AllDay: true},
+	}
+	var b bytes.Buffer
+	require.NoError(t, Encode(&b, events, start))
+	calendar := b.String()
+
+	require.True(t, strings.HasPrefix(calendar, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	require.True(t, strings.HasSuffix(calendar, "END:VCALENDAR\r\n"))
+	require.Contains(t, calendar, "UID:1@calendar\r\n")
+	require.Contains(t, calendar, "DTSTART:20210301T100000Z\r\nDURATION:PT1H30M\r\n")
+	require.Contains(t, calendar, `SUMMARY:review\; part 1\, draft`)
+	require.Contains(t, calendar, `DESCRIPTION:line\nnext`)
+	require.Contains(t, calendar, "SEQUENCE:1\r\n")
+	require.Contains(t, calendar, "TRIGGER:-P1D\r\n")
+	require.Contains(t, calendar, "TRIGGER:-PT10M\r\n")
+	require.Contains(t, calendar, "UID:2-20210302T100000Z@calendar\r\n")
+	require.Contains(t, calendar, "DTSTART;VALUE=DATE:20210301\r\nDTEND;VALUE=DATE:20210303\r\n")
+	require.NotContains(t, calendar, "RRULE", "occurrences are exported one by one")
+	for _, line := range strings.Split(calendar, "\r\n") {
+		require.LessOrEqual(t, len(line), maxLineLength)
+	}
+
+	items, err := Decode(&b)
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+	require.True(t, items[3].Event.AllDay)
+	for i, item := range items {
+		require.NoError(t, item.Err)
+		require.Equal(t, events[i].Title, item.Event.Title)
+		require.Equal(t, events[i].StartTime, item.Event.StartTime)
+		require.Equal(t, events[i].Duration, item.Event.Duration)
+	}
+	require.Equal(t, "line\nnext", items[0].Event.Description)
+	require.Equal(t, []int32{86400, 600}, items[0].Event.Reminders)
+}
+
+func TestDecode(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Moscow",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:zoned",
+		"DTSTART;TZID=Europe/Moscow:20210301T100000",
+		"DTEND;TZID=Europe/Moscow:20210301T113000",
+		"SUMMARY:Zoned",
+		"DESCRIPTION:folded descrip",
+		" tion",
+		"RRULE:FREQ=WEEKLY;COUNT=3",
+		"EXDATE;TZID=Europe/Moscow:20210308T100000",
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"TRIGGER;RELATED=END:-PT2H",
+		"END:VALARM",
+		"BEGIN:VALARM",
+		"TRIGGER;VALUE=DATE-TIME:20210301T065000Z",
+		"END:VALARM",
+		"BEGIN:VALARM",
+		"TRIGGER:PT5M",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:all-day",
+		"DTSTART;VALUE=DATE:20210305",
+		"SUMMARY:Holiday",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:zoned",
+		"RECURRENCE-ID;TZID=Europe/Moscow:20210315T100000",
+		"DTSTART;TZID=Europe/Moscow:20210315T120000",
+		"SUMMARY:Zoned moved",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:no-start",
+		"SUMMARY:Broken",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:backwards",
+		"DTSTART:20210301T100000Z",
+		"DURATION:-PT1H",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:unknown-zone",
+		"DTSTART;TZID=Mars/Olympus:20210301T100000",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	items, err := Decode(strings.NewReader(calendar))
+	require.NoError(t, err)
+	require.Len(t, items, 6)
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	zoned := items[0]
+	require.NoError(t, zoned.Err)
+	require.Equal(t, "zoned", zoned.UID)
+	require.True(t, zoned.Event.StartTime.Equal(time.Date(2021, 3, 1, 7, 0, 0, 0, time.UTC)))
+	require.Equal(t, moscow, zoned.Event.StartTime.Location())
+	require.Equal(t, "Europe/Moscow", zoned.Event.TimeZone)
+	require.Equal(t, int64(5400), zoned.Event.Duration)
+	require.Equal(t, "folded description", zoned.Event.Description)
+	require.Equal(t, "FREQ=WEEKLY;COUNT=3", zoned.Event.RRule)
+	require.True(t, zoned.Event.ExDates.Contains(time.Date(2021, 3, 8, 7, 0, 0, 0, time.UTC)))
+	require.Equal(t, []int32{1800, 600}, zoned.Event.Reminders, "the alarm after the start is ignored")
+
+	require.NoError(t, items[1].Err)
+	require.Equal(t, time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC), items[1].Event.StartTime)
+	require.Equal(t, int64(86400), items[1].Event.Duration)
+	require.True(t, items[1].Event.AllDay)
+	require.NoError(t, items[2].Err)
+	require.Equal(t, "zoned", items[2].UID)
+	require.True(t, items[2].Event.RecurrenceID.Equal(time.Date(2021, 3, 15, 7, 0, 0, 0, time.UTC)))
+	require.Nil(t, zoned.Event.RecurrenceID)
+	for _, item := range items[3:] {
+		require.ErrorIs(t, item.Err, ErrInvalidEvent, item.UID)
+	}
+
+	for _, broken := range []string{
+		"",
+		"BEGIN:VEVENT\r\nEND:VEVENT",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VEVENT",
+		"BEGIN:VCALENDAR\r\ngarbage\r\nEND:VCALENDAR",
+	} {
+		_, err := Decode(strings.NewReader(broken))
+		require.ErrorIs(t, err, ErrInvalidCalendar, broken)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"PT0S":         0,
+		"PT15M":        15 * time.Minute,
+		"-PT15M":       -15 * time.Minute,
+		"P1W":          7 * 24 * time.Hour,
+		"P1DT2H3M4S":   26*time.Hour + 3*time.Minute + 4*time.Second,
+		"+P2D":         48 * time.Hour,
+		"PT1H0M30S":    time.Hour + 30*time.Second,
+		"P0DT0H0M600S": 10 * time.Minute,
+	} {
+		d, err := parseDuration(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, d, value)
+	}
+	for _, value := range []string{"", "P", "PT", "1H", "PT1.5H", "P1Y"} {
+		_, err := parseDuration(value)
+		require.Error(t, err, value)
+	}
+	require.Equal(t, "P1DT1H1M1S", formatDuration(25*time.Hour+time.Minute+time.Second))
+}
@@ -0,0 +1,40 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Log writes notifications to the log.
+type Log struct {
+	log       *logrus.Logger
+	level     logrus.Level
+	templates channelTemplates
+}
+
+// NewLog returns a notifier writing at the level, info by default.
+func NewLog(log *logrus.Logger, level string) (*Log, error) {
+	l := &Log{log: log, level: logrus.InfoLevel}
+	if level != "" {
+		var err error
+		if l.level, err = logrus.ParseLevel(level); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Notify logs the body of the notification rendered with the template or its built-in format.
+func (l *Log) Notify(_ context.Context, n *common.Notification) error {
+	msg, ok, err := l.templates.render(n)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msg.Body = n.String()
+	}
+	l.log.Log(l.level, "NOTIFICATION: ", msg.Body)
+	return nil
+}
@@ -0,0 +1,146 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnknownChannel = errors.New("unknown notification channel type")
+
+const defaultTimeout = 10 * time.Second
+
+// Notifier delivers notifications to a single channel.
+type Notifier interface {
+	Notify(ctx context.Context, n *common.Notification) error
+}
+
+// ChannelConf configures a notification channel, Type selects which of the fields apply.
+// URL, Secret and Password may refer to environment variables as $VAR or ${VAR}.
+type ChannelConf struct {
+	Name string `json:"name"`
+	// Type is one of "log", "webhook" or "smtp".
+	Type     string `json:"type"`
+	Disabled bool   `json:"disabled"`
+	// Level is the level of the log channel records.
+	Level string `json:"level"`
+	// URL and Secret of the webhook, the payloads are signed if Secret is set.
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// Timeout of a webhook request or an SMTP session, such as "5s".
+	Timeout  string `json:"timeout"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	// To is the recipient address, %d in it is replaced with the recipient of the notification.
+	To string `json:"to"`
+}
+
+// New returns the notifier of the channel rendering the notifications with the templates of conf.Name if there are any.
+func New(log *logrus.Logger, conf ChannelConf, templates *Templates) (Notifier, error) {
+	timeout := defaultTimeout
+	if conf.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(conf.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout of channel %s: %w", conf.Name, err)
+		}
+	}
+	switch strings.ToLower(conf.Type) {
+	case "log":
+		l, err := NewLog(log, conf.Level)
+		if err != nil {
+			return nil, err
+		}
+		l.templates = channelTemplates{name: conf.Name, templates: templates}
+		return l, nil
+	case "webhook":
+		if conf.URL == "" {
+			return nil, fmt.Errorf("url of channel %s is not set", conf.Name)
+		}
+		webhook := NewWebhook(os.ExpandEnv(conf.URL), os.ExpandEnv(conf.Secret), timeout)
+		webhook.templates = channelTemplates{name: conf.Name, templates: templates}
+		return webhook, nil
+	case "smtp":
+		if conf.Host == "" || conf.From == "" || conf.To == "" {
+			return nil, fmt.Errorf("host, from and to of channel %s must be set", conf.Name)
+		}
+		s := NewSMTP(conf.Host, conf.Port, conf.Username, os.ExpandEnv(conf.Password), conf.From, conf.To, timeout)
+		s.templates = channelTemplates{name: conf.Name, templates: templates}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("%w %q of channel %s", ErrUnknownChannel, conf.Type, conf.Name)
+	}
+}
+
+// channelTemplates render the notifications of a channel.
+type channelTemplates struct {
+	name      string
+	templates *Templates
+}
+
+func (c channelTemplates) render(n *common.Notification) (Message, bool, error) {
+	return c.templates.Render(c.name, n)
+}
+
+// Multi fans notifications out to several channels.
+type Multi struct {
+	log      *logrus.Logger
+	names    []string
+	channels []Notifier
+}
+
+// NewMulti returns the notifier of the enabled channels, templates may be nil.
+func NewMulti(log *logrus.Logger, confs []ChannelConf, templates *Templates) (*Multi, error) {
+	m := &Multi{log: log}
+	for _, conf := range confs {
+		if conf.Disabled {
+			continue
+		}
+		channel, err := New(log, conf, templates)
+		if err != nil {
+			return nil, err
+		}
+		m.Add(conf.Name, channel)
+	}
+	return m, nil
+}
+
+func (m *Multi) Add(name string, channel Notifier) {
+	m.names = append(m.names, name)
+	m.channels = append(m.channels, channel)
+}
+
+// Wrap replaces every channel with the notifier wrap returns for it, such as the one skipping
+// the channels a retried notification was delivered via already.
+func (m *Multi) Wrap(wrap func(name string, channel Notifier) Notifier) {
+	for i, channel := range m.channels {
+		m.channels[i] = wrap(m.names[i], channel)
+	}
+}
+
+// Notify delivers the notification to every channel, a failed channel doesn't stop the rest.
+// The returned error lists the failed channels, the retry delivers it to all the channels again
+// unless they are wrapped to skip the ones it was delivered to.
+func (m *Multi) Notify(ctx context.Context, n *common.Notification) error {
+	var failed []string
+	for i, channel := range m.channels {
+		if err := channel.Notify(ctx, n); err != nil {
+			m.log.Warnf("failed to notify via %s: %s. err: %s", m.names[i], n, err)
+			failed = append(failed, m.names[i])
+			continue
+		}
+		m.log.Debugf("notified via %s: %s", m.names[i], n)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to notify via %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var testNotification = &common.Notification{
+	ID:        1,
+	Title:     "standup",
+	EventTime: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+	Owner:     42,
+	Reminder:  600,
+}
+
+func TestWebhook(t *testing.T) {
+	var received common.Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if Sign([]byte("secret"), r.Header.Get(TimestampHeader), body) != r.Header.Get(SignatureHeader) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer server.Close()
+
+	require.NoError(t, NewWebhook(server.URL, "secret", time.Second).Notify(context.Background(), testNotification))
+	require.Equal(t, *testNotification, received)
+	err := NewWebhook(server.URL, "wrong", time.Second).Notify(context.Background(), testNotification)
+	require.EqualError(t, err, "webhook responded with 401 Unauthorized")
+}
+
+func TestSMTP(t *testing.T) {
+	s := NewSMTP("smtp.example.com", 587, "calendar", "password", "calendar@example.com", "user%d@example.com", time.Second)
+	var to []string
+	var msg string
+	s.sendMail = func(addr string, a smtp.Auth, from string, rcpt []string, body []byte) error {
+		require.Equal(t, "smtp.example.com:587", addr)
+		require.NotNil(t, a)
+		to, msg = rcpt, string(body)
+		return nil
+	}
+	require.NoError(t, s.Notify(context.Background(), testNotification))
+	require.Equal(t, []string{"user42@example.com"}, to)
+	require.Contains(t, msg, "Subject: Reminder: standup\r\n")
+
+	s.sendMail = func(string, smtp.Auth, string, []string, []byte) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+	s.timeout = time.Millisecond
+	require.ErrorIs(t, s.Notify(context.Background(), testNotification), context.DeadlineExceeded)
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(context.Context, *common.Notification) error {
+	return errors.New("unavailable")
+}
+
+func TestMulti(t *testing.T) {
+	log := logrus.New()
+	var out strings.Builder
+	log.SetOutput(&out)
+	m, err := NewMulti(log, []ChannelConf{
+		{Name: "stdout", Type: "log"},
+		{Name: "email", Type: "smtp", Disabled: true},
+	}, nil)
+	require.NoError(t, err)
+	m.Add("broken", failingNotifier{})
+	require.EqualError(t, m.Notify(context.Background(), testNotification), "failed to notify via broken")
+	require.Contains(t, out.String(), "NOTIFICATION: "+testNotification.String())
+
+	_, err = NewMulti(log, []ChannelConf{{Name: "pigeon", Type: "pigeon"}}, nil)
+	require.ErrorIs(t, err, ErrUnknownChannel)
+	_, err = NewMulti(log, []ChannelConf{{Name: "hook", Type: "webhook"}}, nil)
+	require.Error(t, err)
+}
+
+func TestTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	write("default/en.txt", `{{.Title}} starts at {{.Start.Format "15:04 MST"}}`)
+	write("email/ru.html", `{{define "subject"}}Напоминание: {{.Title}}{{end}}<p>{{.Title}} в {{.Start.Format "15:04"}}</p><p>{{.Description}}</p>`)
+	templates, err := LoadTemplates(logrus.New(), TemplatesConf{
+		Dir:    dir,
+		Owners: map[int64]RecipientConf{42: {Locale: "ru", TimeZone: "Europe/Moscow"}},
+	})
+	require.NoError(t, err)
+	n := *testNotification
+	n.Title = "standup & retro"
+	n.Description = "<b>bring coffee</b>"
+
+	msg, ok, err := templates.Render("email", &n)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Message{
+		Subject: "Напоминание: standup & retro",
+		Body:    "<p>standup &amp; retro в 13:00</p><p>&lt;b&gt;bring coffee&lt;/b&gt;</p>",
+		HTML:    true,
+	}, msg)
+
+	msg, ok, err = templates.Render("log", &n)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "standup & retro starts at 13:00 MSK", msg.Body, "the channel falls back to the default templates and locale")
+	n.Owner = 1
+	msg, _, err = templates.Render("log", &n)
+	require.NoError(t, err)
+	require.Equal(t, "standup & retro starts at 10:00 UTC", msg.Body)
+	n.TimeZone = "Asia/Tokyo"
+	msg, _, err = templates.Render("log", &n)
+	require.NoError(t, err)
+	require.Equal(t, "standup & retro starts at 19:00 JST", msg.Body, "the time zone of the event is the next best")
+	n.Owner = 42
+	msg, _, err = templates.Render("log", &n)
+	require.NoError(t, err)
+	require.Equal(t, "standup & retro starts at 13:00 MSK", msg.Body)
+	n.Owner, n.TimeZone = 1, ""
+
+	write("default/en.txt", `{{.Title}} in {{.Before}}`)
+	require.NoError(t, templates.Reload())
+	msg, _, err = templates.Render("log", &n)
+	require.NoError(t, err)
+	require.Equal(t, "standup & retro in 10m0s", msg.Body)
+	write("default/en.txt", `{{.Title`)
+	require.Error(t, templates.Reload())
+	msg, _, err = templates.Render("log", &n)
+	require.NoError(t, err)
+	require.Equal(t, "standup & retro in 10m0s", msg.Body, "the templates are kept if the reload fails")
+
+	_, ok, err = (*Templates)(nil).Render("log", &n)
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, err = LoadTemplates(logrus.New(), TemplatesConf{TimeZone: "Mars/Olympus"})
+	require.ErrorIs(t, err, common.ErrUnknownTimeZone)
+
+	s, err := New(logrus.New(), ChannelConf{Name: "email", Type: "smtp", Host: "localhost", From: "calendar@example.com", To: "user%d@example.com"}, templates)
+	require.NoError(t, err)
+	var sent string
+	s.(*SMTP).sendMail = func(_ string, _ smtp.Auth, _ string, _ []string, body []byte) error {
+		sent = string(body)
+		return nil
+	}
+	n.Owner = 42
+	require.NoError(t, s.Notify(context.Background(), &n))
+	require.Contains(t, sent, "Subject: Напоминание: standup & retro\r\n")
+	require.Contains(t, sent, "Content-Type: text/html; charset=UTF-8\r\n")
+}
@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+// SMTP emails notifications.
+type SMTP struct {
+	addr      string
+	auth      smtp.Auth
+	from      string
+	to        string
+	timeout   time.Duration
+	sendMail  func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	templates channelTemplates
+}
+
+// NewSMTP returns a notifier sending mail through host:port, authenticating if username is set.
+// %d in to is replaced with the recipient of the notification.
+func NewSMTP(host string, port int, username, password, from, to string, timeout time.Duration) *SMTP {
+	if port == 0 {
+		port = 25
+	}
+	s := &SMTP{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		from:     from,
+		to:       to,
+		timeout:  timeout,
+		sendMail: smtp.SendMail,
+	}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+// Notify gives up waiting for the server after the timeout, the session itself is left to finish in the background.
+func (s *SMTP) Notify(ctx context.Context, n *common.Notification) error {
+	to := s.to
+	if strings.Contains(to, "%d") {
+		to = fmt.Sprintf(to, n.RecipientID())
+	}
+	msg, err := s.message(to, n)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.sendMail(s.addr, s.auth, s.from, []string{to}, msg)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// message renders the email with the template of the channel, the subject defaults to "Reminder: <title>".
+func (s *SMTP) message(to string, n *common.Notification) ([]byte, error) {
+	msg, ok, err := s.templates.render(n)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		msg.Body = fmt.Sprintf("%s starts at %s.", n.Title, n.EventTime.Format(time.RFC1123Z))
+	}
+	if msg.Subject == "" {
+		msg.Subject = "Reminder: " + n.Title
+	}
+	contentType := "text/plain"
+	if msg.HTML {
+		contentType = "text/html"
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", sanitizeHeader(msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: %s; charset=UTF-8\r\n\r\n", contentType)
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+// sanitizeHeader keeps user input from injecting headers.
+func sanitizeHeader(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
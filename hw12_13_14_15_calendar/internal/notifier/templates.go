@@ -0,0 +1,236 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultChannel is the directory of the templates of the channels without their own.
+	DefaultChannel = "default"
+	defaultLocale  = "en"
+	// bodyTemplate is the name of the content of a template file outside of the {{define}} blocks.
+	bodyTemplate    = "body"
+	subjectTemplate = "subject"
+)
+
+// TemplatesConf configures rendering notifications with templates. A channel without a template
+// for the notification keeps its built-in format.
+type TemplatesConf struct {
+	// Dir holds a directory per channel name with a template per locale, such as email/ru.html or default/en.txt.
+	// The .html templates are rendered with html/template and the rest with text/template.
+	// A template may {{define "subject"}} of the email, the rest of it is the body.
+	Dir string `json:"dir"`
+	// Locale and TimeZone of the recipients missing in Owners, "en" and UTC by default.
+	Locale   string `json:"locale"`
+	TimeZone string `json:"timezone"`
+	// Owners configure the locale and the IANA time zone of the particular users, owners and attendees alike.
+	Owners map[int64]RecipientConf `json:"owners"`
+}
+
+type RecipientConf struct {
+	Locale   string `json:"locale"`
+	TimeZone string `json:"timezone"`
+}
+
+// Message is a notification rendered for a channel.
+type Message struct {
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+	HTML    bool   `json:"html,omitempty"`
+}
+
+// TemplateData is what the templates see, Start and End are in the time zone of the recipient.
+type TemplateData struct {
+	*common.Notification
+	Start    time.Time
+	End      time.Time
+	Before   time.Duration
+	Locale   string
+	TimeZone string
+}
+
+type recipient struct {
+	locale   string
+	location *time.Location
+}
+
+type template struct {
+	html    bool
+	execute func(w io.Writer, name string, data interface{}) error
+	defines func(name string) bool
+}
+
+// Templates render notifications per channel and locale, Reload picks up the changes of the files.
+type Templates struct {
+	log    *logrus.Logger
+	dir    string
+	owners map[int64]recipient
+	// fallback applies to the recipients missing in owners.
+	fallback recipient
+	mu       sync.RWMutex
+	// sets are the templates by channel and locale.
+	sets map[string]map[string]template
+}
+
+// LoadTemplates parses the templates of conf.Dir, there are none if it is empty.
+func LoadTemplates(log *logrus.Logger, conf TemplatesConf) (*Templates, error) {
+	fallback, err := newRecipient(conf.Locale, conf.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	t := &Templates{log: log, dir: conf.Dir, fallback: fallback, owners: make(map[int64]recipient, len(conf.Owners))}
+	for owner, recipientConf := range conf.Owners {
+		if recipientConf.Locale == "" {
+			recipientConf.Locale = fallback.locale
+		}
+		if recipientConf.TimeZone == "" {
+			recipientConf.TimeZone = fallback.location.String()
+		}
+		if t.owners[owner], err = newRecipient(recipientConf.Locale, recipientConf.TimeZone); err != nil {
+			return nil, fmt.Errorf("owner %d: %w", owner, err)
+		}
+	}
+	if err = t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func newRecipient(locale, timeZone string) (recipient, error) {
+	if locale == "" {
+		locale = defaultLocale
+	}
+	location, err := common.LoadLocation(timeZone)
+	if err != nil {
+		return recipient{}, err
+	}
+	return recipient{locale: locale, location: location}, nil
+}
+
+// Reload parses the templates anew, the loaded ones are kept if any of the files fails to parse.
+func (t *Templates) Reload() error {
+	sets := make(map[string]map[string]template)
+	if t.dir != "" {
+		files, err := filepath.Glob(filepath.Join(t.dir, "*", "*"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			channel := filepath.Base(filepath.Dir(file))
+			ext := filepath.Ext(file)
+			locale := strings.TrimSuffix(filepath.Base(file), ext)
+			parsed, err := parseTemplate(file, ext == ".html")
+			if err != nil {
+				return fmt.Errorf("failed to parse template %s: %w", file, err)
+			}
+			if sets[channel] == nil {
+				sets[channel] = make(map[string]template)
+			}
+			sets[channel][locale] = parsed
+		}
+	}
+	t.mu.Lock()
+	t.sets = sets
+	t.mu.Unlock()
+	t.log.Infof("loaded the templates of %d channels", len(sets))
+	return nil
+}
+
+func parseTemplate(file string, isHTML bool) (template, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return template{}, err
+	}
+	if isHTML {
+		parsed, err := htmltemplate.New(bodyTemplate).Parse(string(content))
+		if err != nil {
+			return template{}, err
+		}
+		return template{
+			html:    true,
+			execute: parsed.ExecuteTemplate,
+			defines: func(name string) bool { return parsed.Lookup(name) != nil },
+		}, nil
+	}
+	parsed, err := texttemplate.New(bodyTemplate).Parse(string(content))
+	if err != nil {
+		return template{}, err
+	}
+	return template{
+		execute: parsed.ExecuteTemplate,
+		defines: func(name string) bool { return parsed.Lookup(name) != nil },
+	}, nil
+}
+
+// Render renders the notification in the recipient's locale, or else the default locale, with the templates
+// of the channel preferred to the default ones. The time is in the recipient's time zone, or else the one
+// of the event, or else the default one. It reports false if there is no such template.
+func (t *Templates) Render(channel string, n *common.Notification) (Message, bool, error) {
+	if t == nil {
+		return Message{}, false, nil
+	}
+	r, ok := t.owners[n.RecipientID()]
+	if !ok {
+		r = t.fallback
+		if location, err := common.LoadLocation(n.TimeZone); n.TimeZone != "" && err == nil {
+			r.location = location
+		}
+	}
+	tmpl, ok := t.lookup(channel, r.locale)
+	if !ok {
+		return Message{}, false, nil
+	}
+	start := n.EventTime.In(r.location)
+	data := TemplateData{
+		Notification: n,
+		Start:        start,
+		End:          start.Add(time.Duration(n.Duration) * time.Second),
+		Before:       time.Duration(n.Reminder) * time.Second,
+		Locale:       r.locale,
+		TimeZone:     r.location.String(),
+	}
+	msg := Message{HTML: tmpl.html}
+	var b bytes.Buffer
+	if err := tmpl.execute(&b, bodyTemplate, data); err != nil {
+		return Message{}, false, err
+	}
+	msg.Body = strings.TrimSpace(b.String())
+	if tmpl.defines(subjectTemplate) {
+		b.Reset()
+		if err := tmpl.execute(&b, subjectTemplate, data); err != nil {
+			return Message{}, false, err
+		}
+		msg.Subject = strings.TrimSpace(b.String())
+		if tmpl.html {
+			// The subject is plain text even within an HTML template.
+			msg.Subject = html.UnescapeString(msg.Subject)
+		}
+	}
+	return msg, true, nil
+}
+
+func (t *Templates) lookup(channel, locale string) (template, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, c := range []string{channel, DefaultChannel} {
+		for _, l := range []string{locale, t.fallback.locale} {
+			if tmpl, ok := t.sets[c][l]; ok {
+				return tmpl, true
+			}
+		}
+	}
+	return template{}, false
+}
@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body.
+	SignatureHeader = "X-Calendar-Signature"
+	// TimestampHeader carries the unix time the payload was signed at, so receivers can reject replays.
+	TimestampHeader = "X-Calendar-Timestamp"
+)
+
+// Webhook posts notifications as JSON to a URL.
+type Webhook struct {
+	url       string
+	secret    []byte
+	client    *http.Client
+	now       func() time.Time
+	templates channelTemplates
+}
+
+// webhookPayload is the notification along with its message if the channel has a template.
+type webhookPayload struct {
+	*common.Notification
+	Message *Message `json:"message,omitempty"`
+}
+
+// NewWebhook returns a webhook signing the payloads with the secret unless it is empty.
+func NewWebhook(url, secret string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, secret: []byte(secret), client: &http.Client{Timeout: timeout}, now: time.Now}
+}
+
+// Sign returns the value of SignatureHeader for the payload.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Notify(ctx context.Context, n *common.Notification) error {
+	payload := webhookPayload{Notification: n}
+	msg, ok, err := w.templates.render(n)
+	if err != nil {
+		return err
+	}
+	if ok {
+		payload.Message = &msg
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		timestamp := strconv.FormatInt(w.now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign(w.secret, timestamp, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
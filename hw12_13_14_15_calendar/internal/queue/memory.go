@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultCapacity = 1000
+
+var ErrFull = errors.New("the queue is full")
+
+type message struct {
+	body    []byte
+	retries int
+}
+
+// Memory is a queue within the process, it only delivers the messages published by the same process,
+// so it is meant for tests and running the scheduler and the sender together.
+type Memory struct {
+	log      *logrus.Logger
+	opts     Options
+	messages chan message
+	mu       sync.Mutex
+	dead     [][]byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewMemory returns a queue holding up to capacity messages, defaultCapacity if it is zero.
+func NewMemory(log *logrus.Logger, capacity int, opts Options) *Memory {
+	if capacity == 0 {
+		capacity = defaultCapacity
+	}
+	return &Memory{
+		log:      log,
+		opts:     opts.withDefaults(),
+		messages: make(chan message, capacity),
+		done:     make(chan struct{}),
+	}
+}
+
+func (q *Memory) Publish(notification *common.Notification) error {
+	body, err := notification.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode notification %s: %w", notification, err)
+	}
+	return q.push(message{body: body})
+}
+
+func (q *Memory) push(msg message) error {
+	if q.closed() {
+		return ErrClosed
+	}
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrFull
+	}
+}
+
+// Consume passes the messages to handler until ctx is done or the queue is closed.
+func (q *Memory) Consume(ctx context.Context, handler Handler) error {
+	for !q.closed() {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.done:
+			return nil
+		case msg := <-q.messages:
+			q.handle(msg, handler(ctx, msg.body))
+		}
+	}
+	return nil
+}
+
+func (q *Memory) closed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Memory) handle(msg message, err error) {
+	if err == nil {
+		return
+	}
+	if q.opts.dead(err, msg.retries) {
+		q.log.Warnf("dead-lettering a message after %d retries: %s", msg.retries, err)
+		q.mu.Lock()
+		q.dead = append(q.dead, msg.body)
+		q.mu.Unlock()
+		return
+	}
+	delay := q.opts.retryDelay(msg.retries)
+	q.log.Debugf("retrying a message in %s: %s", delay, err)
+	msg.retries++
+	time.AfterFunc(delay, func() {
+		if err := q.push(msg); err != nil && !errors.Is(err, ErrClosed) {
+			q.log.Warn("failed to requeue a message: ", err)
+		}
+	})
+}
+
+// Dead returns the bodies of the dead-lettered messages.
+func (q *Memory) Dead() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([][]byte(nil), q.dead...)
+}
+
+// Close stops the consumers, the messages left in the queue are lost.
+func (q *Memory) Close() error {
+	q.once.Do(func() { close(q.done) })
+	return nil
+}
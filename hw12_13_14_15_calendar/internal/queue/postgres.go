@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	enqueueQuery = `INSERT INTO notification_queue (body) VALUES ($1)`
+	// lockMessagesQuery locks the messages due for delivery, concurrent consumers skip the locked ones.
+	lockMessagesQuery = `
+SELECT id, body, retries
+FROM notification_queue
+WHERE dead_at IS NULL
+  AND available_at <= now()
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED`
+	deleteMessageQuery = `DELETE FROM notification_queue WHERE id = $1`
+	retryMessageQuery  = `
+UPDATE notification_queue
+SET retries      = retries + 1,
+    available_at = now() + $2 * interval '1 millisecond',
+    last_error   = $3
+WHERE id = $1`
+	buryMessageQuery = `
+UPDATE notification_queue
+SET dead_at    = now(),
+    last_error = $2
+WHERE id = $1`
+)
+
+type storedMessage struct {
+	ID      int64  `db:"id"`
+	Body    []byte `db:"body"`
+	Retries int    `db:"retries"`
+}
+
+// Postgres is a queue kept in the notification_queue table, which lets the scheduler and the sender
+// do without a message broker. The dead-lettered messages stay in the table with dead_at set.
+type Postgres struct {
+	db   *sqlx.DB
+	log  *logrus.Logger
+	opts Options
+}
+
+func NewPostgres(ctx context.Context, log *logrus.Logger, dsn string, opts Options) (*Postgres, error) {
+	db, err := sqlx.ConnectContext(ctx, "pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{db: db, log: log, opts: opts.withDefaults()}, nil
+}
+
+func (q *Postgres) Publish(notification *common.Notification) error {
+	body, err := notification.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode notification %s: %w", notification, err)
+	}
+	if _, err = q.db.Exec(enqueueQuery, body); err != nil {
+		return fmt.Errorf("failed to publish a notification: %w", err)
+	}
+	return nil
+}
+
+// Consume polls the queue until ctx is done, the batches following a full one are taken at once.
+func (q *Postgres) Consume(ctx context.Context, handler Handler) error {
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		consumed, err := q.consumeBatch(ctx, handler)
+		if err != nil && ctx.Err() == nil {
+			q.log.Warn("failed to consume notifications: ", err)
+		}
+		if consumed == q.opts.Batch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// consumeBatch handles a batch of messages within a transaction holding their locks,
+// the messages are redelivered if it fails to commit.
+func (q *Postgres) consumeBatch(ctx context.Context, handler Handler) (consumed int, err error) {
+	tx, err := q.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	var messages []storedMessage
+	if err = tx.SelectContext(ctx, &messages, lockMessagesQuery, q.opts.Batch); err != nil {
+		return 0, err
+	}
+	for _, msg := range messages {
+		if err = q.handle(ctx, tx, msg, handler(ctx, msg.Body)); err != nil {
+			return 0, err
+		}
+	}
+	return len(messages), tx.Commit()
+}
+
+func (q *Postgres) handle(ctx context.Context, tx *sqlx.Tx, msg storedMessage, err error) error {
+	switch {
+	case err == nil:
+		_, err = tx.ExecContext(ctx, deleteMessageQuery, msg.ID)
+		return err
+	case q.opts.dead(err, msg.Retries):
+		q.log.Warnf("dead-lettering message %d after %d retries: %s", msg.ID, msg.Retries, err)
+		_, err = tx.ExecContext(ctx, buryMessageQuery, msg.ID, err.Error())
+		return err
+	default:
+		delay := q.opts.retryDelay(msg.Retries)
+		q.log.Debugf("retrying message %d in %s: %s", msg.ID, delay, err)
+		_, err = tx.ExecContext(ctx, retryMessageQuery, msg.ID, delay.Milliseconds(), err.Error())
+		return err
+	}
+}
+
+func (q *Postgres) Close() error {
+	return q.db.Close()
+}
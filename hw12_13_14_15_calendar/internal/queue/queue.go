@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+const (
+	defaultRetryDelay   = time.Second
+	defaultBatch        = 10
+	defaultPollInterval = time.Second
+)
+
+var (
+	// ErrPoison marks the messages which fail however many times retried, they are dead-lettered at once.
+	ErrPoison = errors.New("poison message")
+	ErrClosed = errors.New("the queue is closed")
+)
+
+// Handler handles the body of a message, the message is acknowledged if it returns no error.
+type Handler func(ctx context.Context, body []byte) error
+
+// Queue carries the notifications from the scheduler to the sender.
+type Queue interface {
+	Publish(notification *common.Notification) error
+	// Consume passes the messages to handler until ctx is done. A message is removed from the queue once
+	// handler succeeds, retried after a delay if it fails and dead-lettered once the retries are exhausted
+	// or handler fails with ErrPoison.
+	Consume(ctx context.Context, handler Handler) error
+	Close() error
+}
+
+// Options configure the queues backed by the process memory and Postgres.
+type Options struct {
+	// MaxRetries is the number of redeliveries of a failed message before it is dead-lettered.
+	MaxRetries int
+	// RetryDelay is the delay of the first redelivery, every next one is twice as long.
+	RetryDelay time.Duration
+	// Batch is the number of messages the Postgres queue locks at once.
+	Batch int
+	// PollInterval is the period of polling the Postgres queue while it is empty.
+	PollInterval time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.RetryDelay == 0 {
+		o.RetryDelay = defaultRetryDelay
+	}
+	if o.Batch == 0 {
+		o.Batch = defaultBatch
+	}
+	if o.PollInterval == 0 {
+		o.PollInterval = defaultPollInterval
+	}
+	return o
+}
+
+// retryDelay returns the delay of the redelivery following the given number of retries.
+func (o Options) retryDelay(retries int) time.Duration {
+	return o.RetryDelay << retries
+}
+
+// dead reports whether a message which failed with err after the given number of retries is dead-lettered.
+func (o Options) dead(err error, retries int) bool {
+	return errors.Is(err, ErrPoison) || retries >= o.MaxRetries
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	q := NewMemory(logrus.New(), 4, Options{MaxRetries: 2, RetryDelay: time.Millisecond})
+	testQueue(t, q, func() bool {
+		return len(q.Dead()) == 2
+	})
+
+	for id := int64(1); id <= 4; id++ {
+		require.NoError(t, q.Publish(&common.Notification{ID: id}))
+	}
+	require.ErrorIs(t, q.Publish(&common.Notification{ID: 5}), ErrFull)
+	require.NoError(t, q.Close())
+	require.ErrorIs(t, q.Publish(&common.Notification{ID: 5}), ErrClosed)
+	require.NoError(t, q.Consume(context.Background(), nil), "a closed queue is not consumed")
+}
+
+func TestPostgres(t *testing.T) {
+	// run ONLY on empty DB
+	// goose -dir internal/storage/sql/migrations postgres "user=calendar password=calendar dbname=postgres sslmode=disable" up
+	t.Skip()
+	ctx := context.Background()
+	q, err := NewPostgres(ctx, logrus.New(), "host=localhost port=5432 user=calendar password=calendar dbname=postgres sslmode=disable",
+		Options{MaxRetries: 2, RetryDelay: time.Millisecond, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer q.Close()
+	testQueue(t, q, func() bool {
+		var left, dead int
+		err := q.db.QueryRow(`SELECT count(*), count(dead_at) FROM notification_queue`).Scan(&left, &dead)
+		return err == nil && left == 2 && dead == 2
+	})
+}
+
+// testQueue publishes a notification handled at once, one failing once, one failing every time and a poison one,
+// then consumes them until settled reports the queue is in the expected state.
+func testQueue(t *testing.T, q Queue, settled func() bool) {
+	t.Helper()
+	for id := int64(1); id <= 4; id++ {
+		require.NoError(t, q.Publish(&common.Notification{ID: id}))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	attempts := make(map[int64]int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := q.Consume(ctx, func(_ context.Context, body []byte) error {
+			var notification common.Notification
+			if err := json.Unmarshal(body, &notification); err != nil {
+				return err
+			}
+			mu.Lock()
+			attempts[notification.ID]++
+			attempt := attempts[notification.ID]
+			mu.Unlock()
+			switch {
+			case notification.ID == 2 && attempt == 1:
+				return errors.New("smtp is down")
+			case notification.ID == 3:
+				return errors.New("no such mailbox")
+			case notification.ID == 4:
+				return fmt.Errorf("%w: unexpected EOF", ErrPoison)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}()
+	expected := map[int64]int{1: 1, 2: 2, 3: 3, 4: 1}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return reflect.DeepEqual(expected, attempts) && settled()
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
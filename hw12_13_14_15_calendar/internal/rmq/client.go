@@ -2,90 +2,405 @@ package rmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/queue"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
+const (
+	queueName     = "notifications"
+	deadQueueName = queueName + ".dead"
+	// retriesHeader counts the redeliveries of a message.
+	retriesHeader = "x-retries"
+	// errorHeader carries the error a message was dead-lettered with.
+	errorHeader       = "x-error"
+	defaultRetryDelay = time.Second
+	publishRetries    = 5
+	publishBackoff    = 100 * time.Millisecond
+
+	defaultReconnectDelay = time.Second
+	maxReconnectDelay     = 30 * time.Second
+)
+
+var ErrNotConfirmed = errors.New("the broker has not confirmed the message")
+
+var _ queue.Queue = (*Client)(nil)
+
+// Options configure the queues of the client.
+type Options struct {
+	// TTL of the notifications in milliseconds, zero for unlimited.
+	TTL int64
+	// MaxRetries is the number of redeliveries of a failed message before it is dead-lettered.
+	MaxRetries int
+	// RetryDelay is the delay of the first redelivery, every next one is twice as long.
+	RetryDelay time.Duration
+	// Prefetch limits the number of unacknowledged messages delivered to the consumer, zero for unlimited.
+	Prefetch int
+	// ReconnectDelay is the delay of the first reconnection attempt, every next one is twice as long.
+	ReconnectDelay time.Duration
+}
+
 type Client struct {
 	log  *logrus.Logger
-	conn *amqp.Connection
-	ch   *amqp.Channel
-	q    amqp.Queue
-	busy chan struct{}
+	dsn  string
+	dial dialer
+	opts Options
+	// pubMu serializes publishing, so that a confirmation matches the message waiting for it.
+	pubMu sync.Mutex
+	// mu guards the current connection.
+	mu       sync.RWMutex
+	conn     connection
+	ch       channel
+	confirms chan amqp.Confirmation
+	// ready is closed once the client is connected and replaced when the connection is lost.
+	ready     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	consumers sync.WaitGroup
 }
 
-const retry = 5
+// GetRMQConnectionAndDeclare connects to the broker, declares the queues and puts the channel
+// into the confirm mode. The queues are durable, a non-durable queue of notifications
+// declared by the earlier versions has to be deleted first.
+// Once connected the client reconnects whenever the connection is lost until it is closed.
+func GetRMQConnectionAndDeclare(log *logrus.Logger, dsn string, opts Options) (*Client, error) {
+	return newClient(log, dsn, opts, dialAMQP)
+}
 
-func GetRMQConnectionAndDeclare(log *logrus.Logger, dsn string, ttl int64) (*Client, error) {
-	conn, err := amqp.Dial(dsn)
+func newClient(log *logrus.Logger, dsn string, opts Options, dial dialer) (*Client, error) {
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	if opts.ReconnectDelay == 0 {
+		opts.ReconnectDelay = defaultReconnectDelay
+	}
+	c := &Client{
+		log:   log,
+		dsn:   dsn,
+		dial:  dial,
+		opts:  opts,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect dials the broker, sets the channel up and starts watching the connection.
+func (c *Client) connect() error {
+	conn, err := c.dial(c.dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create connection: %w", err)
+		return fmt.Errorf("failed to create connection: %w", err)
 	}
 	ch, err := conn.Channel()
+	if err == nil {
+		err = c.setup(ch)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create channel: %w", err)
+		_ = conn.Close()
+		return err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed() {
+		// Close has run while connecting.
+		return conn.Close()
 	}
+	c.conn, c.ch, c.confirms = conn, ch, confirms
+	close(c.ready)
+	go c.watch(conn, connClosed, chClosed)
+	return nil
+}
+
+func (c *Client) setup(ch channel) error {
+	if err := declare(ch, c.opts); err != nil {
+		return fmt.Errorf("failed to declare queue: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	if c.opts.Prefetch > 0 {
+		if err := ch.Qos(c.opts.Prefetch, 0, false); err != nil {
+			return fmt.Errorf("failed to set prefetch: %w", err)
+		}
+	}
+	return nil
+}
+
+// watch reconnects once the connection or its channel is closed by anything but Close.
+func (c *Client) watch(conn connection, connClosed, chClosed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case <-c.done:
+		return
+	case reason = <-connClosed:
+	case reason = <-chClosed:
+	}
+	if c.closed() {
+		return
+	}
+	c.mu.Lock()
+	c.ready = make(chan struct{})
+	c.conn, c.ch = nil, nil
+	c.mu.Unlock()
+	_ = conn.Close()
+	c.log.Warn("lost connection to rmq: ", reason)
+	delay := c.opts.ReconnectDelay
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+		err := c.connect()
+		if err == nil {
+			c.log.Info("reconnected to rmq")
+			return
+		}
+		c.log.Warnf("failed to reconnect to rmq, retrying in %s: %s", delay, err)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// declare declares the queue of notifications, the dead-letter queue and a delay queue per retry,
+// the expired messages of which return to the queue of notifications.
+func declare(ch channel, opts Options) error {
 	var args amqp.Table
-	if ttl != 0 {
-		args = amqp.Table{"x-message-ttl": ttl}
+	if opts.TTL != 0 {
+		args = amqp.Table{"x-message-ttl": opts.TTL}
 	}
-	topic, err := ch.QueueDeclare("notifications", false, false, false, false, args)
-	if err != nil {
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+	if _, err := ch.QueueDeclare(queueName, true, false, false, false, args); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(deadQueueName, true, false, false, false, nil); err != nil {
+		return err
+	}
+	for i := 0; i < opts.MaxRetries; i++ {
+		_, err := ch.QueueDeclare(retryQueueName(i), true, false, false, false, amqp.Table{
+			"x-message-ttl":             retryDelay(opts, i).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queueName,
+		})
+		if err != nil {
+			return err
+		}
 	}
-	return &Client{
-		log:  log,
-		conn: conn,
-		ch:   ch,
-		q:    topic,
-		busy: make(chan struct{}, 1),
-	}, nil
+	return nil
+}
+
+func retryQueueName(retry int) string {
+	return fmt.Sprintf("%s.retry.%d", queueName, retry)
 }
 
+// retryDelay returns the delay of the redelivery following the given number of retries.
+func retryDelay(opts Options, retries int) time.Duration {
+	return opts.RetryDelay << retries
+}
+
+// Close stops reconnecting and the consumers and waits for them to return.
 func (c *Client) Close() error {
-	if err := c.ch.Close(); err != nil {
-		c.log.Warn("err closing rmq channel: ", err)
+	c.closeOnce.Do(func() { close(c.done) })
+	c.mu.Lock()
+	conn, ch := c.conn, c.ch
+	c.mu.Unlock()
+	if ch != nil {
+		if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			c.log.Warn("err closing rmq channel: ", err)
+		}
 	}
-	<-c.busy
-	close(c.busy)
-	return c.conn.Close()
+	c.consumers.Wait()
+	if conn == nil {
+		return nil
+	}
+	// The connection may be lost before the watcher has noticed it.
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
 }
 
-func (c *Client) Notify(events []common.Event) {
-	for _, event := range events {
-		msg, err := event.Notification().Encode()
-		if err != nil {
-			c.log.Warn("failed to encode msg: ", event.Notification().String())
+// Publish enqueues the notification as a persistent message, retrying the attempts the broker doesn't confirm
+// with a growing delay, so that a lost connection has a chance to be restored.
+func (c *Client) Publish(notification *common.Notification) error {
+	msg, err := notification.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode notification %s: %w", notification, err)
+	}
+	for i := 0; i < publishRetries; i++ {
+		if i > 0 {
+			time.Sleep(publishBackoff << i)
+		}
+		if err = c.publish(queueName, amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         msg,
+		}); err != nil {
 			continue
 		}
-		for i := 0; i < retry; i++ {
-			if err := c.ch.Publish("", c.q.Name, false, false,
-				amqp.Publishing{
-					ContentType: "application/json",
-					Body:        msg,
-				}); err != nil {
-				continue
+		c.log.Debugf("sent notification on %d: %s", notification.ID, notification.Title)
+		return nil
+	}
+	return fmt.Errorf("failed to publish a notification: %w", err)
+}
+
+// publish sends the message to the queue and waits for the broker to confirm it.
+func (c *Client) publish(queue string, msg amqp.Publishing) error {
+	c.pubMu.Lock()
+	defer c.pubMu.Unlock()
+	c.mu.RLock()
+	ch, confirms := c.ch, c.confirms
+	c.mu.RUnlock()
+	if ch == nil {
+		return amqp.ErrClosed
+	}
+	if err := ch.Publish("", queue, false, false, msg); err != nil {
+		return err
+	}
+	confirmation, ok := <-confirms
+	switch {
+	case !ok:
+		return amqp.ErrClosed
+	case !confirmation.Ack:
+		return ErrNotConfirmed
+	}
+	return nil
+}
+
+// Consume passes the messages to handler until ctx is done or the client is closed,
+// resuming after reconnections. A message is acknowledged once handler succeeds, retried after
+// a delay if it fails and dead-lettered once the retries are exhausted or handler fails with queue.ErrPoison.
+func (c *Client) Consume(ctx context.Context, handler queue.Handler) error {
+	c.consumers.Add(1)
+	defer c.consumers.Done()
+	for {
+		messages, err := c.consume(ctx)
+		if err != nil || messages == nil {
+			return err
+		}
+	deliveries:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case msg, ok := <-messages:
+				if !ok {
+					break deliveries
+				}
+				c.handle(msg, handler(ctx, msg.Body))
 			}
-			c.log.Debugf("sent notification on %d: %s", event.ID, event.Title)
-			break
 		}
-		c.log.Warn("failed to publish a notification: ")
 	}
 }
 
-func (c *Client) ConsumeAndSend(ctx context.Context, sender func(context.Context, []byte)) error {
-	c.busy <- struct{}{}
-	messages, err := c.ch.Consume(c.q.Name, "sender", true, false, false, false, nil)
+// consume waits for the connection and starts consuming, it returns no messages once ctx is done or
+// the client is closed.
+func (c *Client) consume(ctx context.Context) (<-chan amqp.Delivery, error) {
+	for {
+		c.mu.RLock()
+		ready := c.ready
+		c.mu.RUnlock()
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-c.done:
+			return nil, nil
+		case <-ready:
+		}
+		c.mu.RLock()
+		ch := c.ch
+		c.mu.RUnlock()
+		if ch == nil {
+			// The connection is lost again.
+			continue
+		}
+		messages, err := ch.Consume(queueName, "sender", false, false, false, false, nil)
+		if !errors.Is(err, amqp.ErrClosed) {
+			return messages, err
+		}
+		// The connection is lost but the watcher hasn't noticed it yet.
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-c.done:
+			return nil, nil
+		case <-time.After(c.opts.ReconnectDelay):
+		}
+	}
+}
+
+// handle acknowledges the message handled with err, rerouting it to a delay queue or the dead-letter queue
+// if err is set. The message is requeued if it can't be rerouted.
+func (c *Client) handle(msg amqp.Delivery, err error) {
+	if err == nil {
+		if err = msg.Ack(false); err != nil {
+			c.log.Warn("failed to ack a message: ", err)
+		}
+		return
+	}
+	retries := retriesOf(msg.Headers)
+	queue := c.route(err, retries)
+	headers := amqp.Table{retriesHeader: int32(retries + 1)}
+	if queue == deadQueueName {
+		headers[errorHeader] = err.Error()
+		c.log.Warnf("dead-lettering a message after %d retries: %s", retries, err)
+	} else {
+		c.log.Debugf("retrying a message in %s: %s", retryDelay(c.opts, retries), err)
+	}
+	err = c.publish(queue, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         msg.Body,
+	})
 	if err != nil {
-		return err
+		c.log.Warn("failed to reroute a message, requeueing it: ", err)
+		if err = msg.Nack(false, true); err != nil {
+			c.log.Warn("failed to nack a message: ", err)
+		}
+		return
 	}
-	<-c.busy
-	for msg := range messages {
-		sender(ctx, msg.Body)
+	if err = msg.Ack(false); err != nil {
+		c.log.Warn("failed to ack a message: ", err)
+	}
+}
+
+// route returns the queue of a message which failed with err after the given number of retries.
+func (c *Client) route(err error, retries int) string {
+	if errors.Is(err, queue.ErrPoison) || retries >= c.opts.MaxRetries {
+		return deadQueueName
+	}
+	return retryQueueName(retries)
+}
+
+func retriesOf(headers amqp.Table) int {
+	switch retries := headers[retriesHeader].(type) {
+	case int32:
+		return int(retries)
+	case int64:
+		return int(retries)
+	default:
+		return 0
 	}
-	c.busy <- struct{}{}
-	return nil
 }
@@ -0,0 +1,31 @@
+package rmq
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/queue"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	c := &Client{opts: Options{MaxRetries: 3, RetryDelay: time.Second}}
+	failure := errors.New("smtp is down")
+
+	require.Equal(t, "notifications.retry.0", c.route(failure, 0))
+	require.Equal(t, "notifications.retry.2", c.route(failure, 2))
+	require.Equal(t, deadQueueName, c.route(failure, 3))
+	require.Equal(t, deadQueueName, c.route(fmt.Errorf("%w: unexpected EOF", queue.ErrPoison), 0))
+
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		[]time.Duration{retryDelay(c.opts, 0), retryDelay(c.opts, 1), retryDelay(c.opts, 2)})
+}
+
+func TestRetriesOf(t *testing.T) {
+	require.Equal(t, 0, retriesOf(nil))
+	require.Equal(t, 2, retriesOf(amqp.Table{retriesHeader: int32(2)}))
+	require.Equal(t, 3, retriesOf(amqp.Table{retriesHeader: int64(3)}))
+}
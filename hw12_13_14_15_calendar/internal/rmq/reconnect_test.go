@@ -0,0 +1,335 @@
+package rmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnect(t *testing.T) {
+	broker := newFakeBroker()
+	c, err := newClient(logrus.New(), "amqp://fake", Options{MaxRetries: 1, ReconnectDelay: time.Millisecond}, broker.dial)
+	require.NoError(t, err)
+	require.Equal(t, []string{queueName, deadQueueName, "notifications.retry.0"}, broker.declaredQueues())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan int64, 10)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := c.Consume(ctx, func(_ context.Context, body []byte) error {
+			var notification common.Notification
+			if err := json.Unmarshal(body, &notification); err != nil {
+				return err
+			}
+			received <- notification.ID
+			return nil
+		})
+		require.NoError(t, err)
+	}()
+
+	require.NoError(t, c.Publish(&common.Notification{ID: 1}))
+	require.Equal(t, int64(1), receive(t, received))
+
+	broker.setDown(true)
+	broker.kill()
+	require.Eventually(t, func() bool { return broker.failedDials() > 0 }, time.Second, time.Millisecond)
+	broker.setDown(false)
+
+	require.NoError(t, c.Publish(&common.Notification{ID: 2}))
+	require.Equal(t, int64(2), receive(t, received))
+	require.Equal(t, 2, broker.dials())
+	require.Len(t, broker.declaredQueues(), 6, "the queues are declared again")
+
+	cancel()
+	wg.Wait()
+	require.NoError(t, c.Close())
+	require.Equal(t, 2, broker.dials(), "a closed client doesn't reconnect")
+}
+
+func TestCloseDisconnected(t *testing.T) {
+	broker := newFakeBroker()
+	c, err := newClient(logrus.New(), "amqp://fake", Options{ReconnectDelay: time.Hour}, broker.dial)
+	require.NoError(t, err)
+	broker.setDown(true)
+	broker.kill()
+
+	done := make(chan error)
+	go func() {
+		done <- c.Consume(context.Background(), func(context.Context, []byte) error { return nil })
+	}()
+	require.NoError(t, c.Close())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the consumer hasn't returned")
+	}
+}
+
+func receive(t *testing.T, received <-chan int64) int64 {
+	t.Helper()
+	select {
+	case id := <-received:
+		return id
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return 0
+	}
+}
+
+// fakeBroker is an in-process stand-in of RabbitMQ with a single queue of notifications.
+type fakeBroker struct {
+	mu       sync.Mutex
+	down     bool
+	dialed   int
+	failed   int
+	declared []string
+	conns    []*fakeConnection
+	queue    chan []byte
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{queue: make(chan []byte, 100)}
+}
+
+func (b *fakeBroker) dial(string) (connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.down {
+		b.failed++
+		return nil, errors.New("connection refused")
+	}
+	b.dialed++
+	conn := &fakeConnection{broker: b}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+func (b *fakeBroker) setDown(down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.down = down
+}
+
+// kill drops the open connections the way a restarting broker does.
+func (b *fakeBroker) kill() {
+	b.mu.Lock()
+	conns := b.conns
+	b.conns = nil
+	b.mu.Unlock()
+	for _, conn := range conns {
+		conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED", Server: true})
+	}
+}
+
+func (b *fakeBroker) dials() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dialed
+}
+
+func (b *fakeBroker) failedDials() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failed
+}
+
+func (b *fakeBroker) declaredQueues() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.declared...)
+}
+
+type fakeConnection struct {
+	broker   *fakeBroker
+	mu       sync.Mutex
+	closed   bool
+	notify   []chan *amqp.Error
+	channels []*fakeChannel
+}
+
+func (c *fakeConnection) Channel() (channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakeChannel{broker: c.broker, closed: make(chan struct{})}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *fakeConnection) Close() error {
+	if !c.shutdown(nil) {
+		return amqp.ErrClosed
+	}
+	return nil
+}
+
+func (c *fakeConnection) shutdown(reason *amqp.Error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	for _, ch := range c.channels {
+		ch.shutdown(reason)
+	}
+	for _, receiver := range c.notify {
+		if reason != nil {
+			receiver <- reason
+		}
+		close(receiver)
+	}
+	return true
+}
+
+type fakeChannel struct {
+	broker   *fakeBroker
+	mu       sync.Mutex
+	closed   chan struct{}
+	notify   []chan *amqp.Error
+	confirms []chan amqp.Confirmation
+	tag      uint64
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	ch.broker.mu.Lock()
+	defer ch.broker.mu.Unlock()
+	ch.broker.declared = append(ch.broker.declared, name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) Confirm(bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) Qos(int, int, bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+func (ch *fakeChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.notify = append(ch.notify, receiver)
+	return receiver
+}
+
+func (ch *fakeChannel) Publish(_, key string, _, _ bool, msg amqp.Publishing) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.isClosed() {
+		return amqp.ErrClosed
+	}
+	if key == queueName {
+		ch.broker.queue <- msg.Body
+	}
+	ch.tag++
+	for _, confirm := range ch.confirms {
+		confirm <- amqp.Confirmation{DeliveryTag: ch.tag, Ack: true}
+	}
+	return nil
+}
+
+func (ch *fakeChannel) Consume(string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+	if ch.isClosed() {
+		return nil, amqp.ErrClosed
+	}
+	deliveries := make(chan amqp.Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			select {
+			case <-ch.closed:
+				return
+			case body := <-ch.broker.queue:
+				select {
+				case deliveries <- amqp.Delivery{Acknowledger: fakeAcknowledger{}, Body: body}:
+				case <-ch.closed:
+					ch.broker.queue <- body
+					return
+				}
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.isClosed() {
+		return amqp.ErrClosed
+	}
+	ch.closeLocked(nil)
+	return nil
+}
+
+func (ch *fakeChannel) shutdown(reason *amqp.Error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !ch.isClosed() {
+		ch.closeLocked(reason)
+	}
+}
+
+func (ch *fakeChannel) closeLocked(reason *amqp.Error) {
+	close(ch.closed)
+	for _, receiver := range ch.notify {
+		if reason != nil {
+			receiver <- reason
+		}
+		close(receiver)
+	}
+	for _, confirm := range ch.confirms {
+		close(confirm)
+	}
+}
+
+func (ch *fakeChannel) isClosed() bool {
+	select {
+	case <-ch.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+type fakeAcknowledger struct{}
+
+func (fakeAcknowledger) Ack(uint64, bool) error {
+	return nil
+}
+
+func (fakeAcknowledger) Nack(uint64, bool, bool) error {
+	return nil
+}
+
+func (fakeAcknowledger) Reject(uint64, bool) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package rmq
+
+import "github.com/streadway/amqp"
+
+// connection and channel are the parts of amqp the client uses, so that the tests can fake the broker.
+type connection interface {
+	Channel() (channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+type channel interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Confirm(noWait bool) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Close() error
+}
+
+type dialer func(dsn string) (connection, error)
+
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c amqpConnection) Channel() (channel, error) {
+	return c.Connection.Channel()
+}
+
+func dialAMQP(dsn string) (connection, error) {
+	conn, err := amqp.Dial(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnection{conn}, nil
+}
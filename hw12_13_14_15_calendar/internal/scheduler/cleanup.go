@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+type CleanupStorage interface {
+	DeleteEventsBefore(ctx context.Context, t time.Time, limit int) (n int64, err error)
+	CountEventsBefore(ctx context.Context, t time.Time) (n int64, err error)
+}
+
+// Cleaner purges the events which ended longer than the retention period ago.
+type Cleaner struct {
+	log       *logrus.Logger
+	storage   CleanupStorage
+	retention time.Duration
+	batch     int
+	dryRun    bool
+}
+
+// NewCleaner returns a cleaner removing at most batch events per storage call, all at once if batch isn't positive.
+// In the dry-run mode it only reports how many events would be removed.
+func NewCleaner(log *logrus.Logger, storage CleanupStorage, retention time.Duration, batch int, dryRun bool) *Cleaner {
+	return &Cleaner{log: log, storage: storage, retention: retention, batch: batch, dryRun: dryRun}
+}
+
+// ParseSchedule parses a standard five-field cron expression or a descriptor such as @daily or @every 1h.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// Run cleans up on every activation of the schedule until ctx is done.
+func (c *Cleaner) Run(ctx context.Context, schedule cron.Schedule) {
+	for {
+		timer := time.NewTimer(time.Until(schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			if _, err := c.Clean(ctx, now); err != nil {
+				c.log.Warn("failed to clean up old events: ", err)
+			}
+		}
+	}
+}
+
+// Clean removes the events which ended before now minus the retention period
+// and returns the number of removed rows, or the number of rows to remove in the dry-run mode.
+func (c *Cleaner) Clean(ctx context.Context, now time.Time) (int64, error) {
+	before := now.Add(-c.retention)
+	if c.dryRun {
+		n, err := c.storage.CountEventsBefore(ctx, before)
+		if err != nil {
+			return 0, err
+		}
+		c.log.Infof("cleanup dry run: %d events ended before %s would be removed", n, before.Format(time.RFC3339))
+		return n, nil
+	}
+	start := time.Now()
+	var total int64
+	for {
+		n, err := c.storage.DeleteEventsBefore(ctx, before, c.batch)
+		total += n
+		if err != nil {
+			c.log.Warnf("cleanup removed %d events ended before %s and failed", total, before.Format(time.RFC3339))
+			return total, err
+		}
+		if c.batch <= 0 || n < int64(c.batch) {
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+	c.log.Infof("cleanup removed %d events ended before %s in %s", total, before.Format(time.RFC3339), time.Since(start))
+	return total, nil
+}
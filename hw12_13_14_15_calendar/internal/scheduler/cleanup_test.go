@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	memorystorage "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleaner(t *testing.T) {
+	log := logrus.New()
+	ctx := context.Background()
+	storage := memorystorage.New(log)
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err := storage.CreateEvent(ctx, &common.Event{Title: "old", StartTime: now.AddDate(-2, 0, i), Owner: 1})
+		require.NoError(t, err)
+	}
+	_, err := storage.CreateEvent(ctx, &common.Event{Title: "recent", StartTime: now.AddDate(0, -1, 0), Owner: 1})
+	require.NoError(t, err)
+	retention := 365 * 24 * time.Hour
+
+	n, err := NewCleaner(log, storage, retention, 2, true).Clean(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	n, err = storage.CountEventsBefore(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), n, "dry run removes nothing")
+
+	n, err = NewCleaner(log, storage, retention, 2, false).Clean(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	n, err = storage.CountEventsBefore(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("30 3 * * *")
+	require.NoError(t, err)
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.Local)
+	require.Equal(t, time.Date(2022, 3, 2, 3, 30, 0, 0, time.Local), schedule.Next(now))
+	_, err = ParseSchedule("@daily")
+	require.NoError(t, err)
+	_, err = ParseSchedule("every day")
+	require.Error(t, err)
+}
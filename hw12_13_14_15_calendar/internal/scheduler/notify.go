@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+type NotifyStorage interface {
+	ListEventsToNotify(ctx context.Context, now time.Time) (notifications []common.Notification, err error)
+	MarkNotified(ctx context.Context, ids []int64, at time.Time) (err error)
+	EnqueueNotifications(ctx context.Context, notifications []common.Notification) (err error)
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Notifier enqueues a single notification per reminder of an occurrence of an event.
+type Notifier struct {
+	log     *logrus.Logger
+	storage NotifyStorage
+}
+
+func NewNotifier(log *logrus.Logger, storage NotifyStorage) *Notifier {
+	return &Notifier{log: log, storage: storage}
+}
+
+// Notify adds the notifications due at now to the outbox and marks their events notified within a single
+// transaction, concurrent schedulers skip the events locked by it. The Relay publishes them afterwards.
+// Returns the number of enqueued notifications.
+func (n *Notifier) Notify(ctx context.Context, now time.Time) (enqueued int, err error) {
+	err = n.storage.WithTx(ctx, func(ctx context.Context) error {
+		notifications, err := n.storage.ListEventsToNotify(ctx, now)
+		if err != nil || len(notifications) == 0 {
+			return err
+		}
+		if err = n.storage.EnqueueNotifications(ctx, notifications); err != nil {
+			return err
+		}
+		ids := make([]int64, 0, len(notifications))
+		for _, notification := range notifications {
+			ids = append(ids, notification.ID)
+		}
+		if err = n.storage.MarkNotified(ctx, ids, now); err != nil {
+			return err
+		}
+		enqueued = len(notifications)
+		return nil
+	})
+	return enqueued, err
+}
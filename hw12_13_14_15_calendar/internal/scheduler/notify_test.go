@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	memorystorage "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStorage fails to mark the events notified.
+type failingStorage struct {
+	*memorystorage.Storage
+}
+
+func (failingStorage) MarkNotified(context.Context, []int64, time.Time) error {
+	return errors.New("connection reset by peer")
+}
+
+func TestNotifier(t *testing.T) {
+	log := logrus.New()
+	ctx := context.Background()
+	storage := memorystorage.New(log)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	_, err := storage.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: now.Add(time.Minute), NotifyTime: 300, Owner: 1, RRule: "FREQ=DAILY"})
+	require.NoError(t, err)
+	_, err = storage.CreateEvent(ctx, &common.Event{Title: "review", StartTime: now.Add(2 * time.Minute), NotifyTime: 300, Owner: 1})
+	require.NoError(t, err)
+	_, err = storage.CreateEvent(ctx, &common.Event{Title: "release", StartTime: now.Add(time.Hour + time.Minute), Reminders: []int32{86400, 3600, 600}, Owner: 1})
+	require.NoError(t, err)
+
+	_, err = NewNotifier(log, failingStorage{storage}).Notify(ctx, now)
+	require.Error(t, err)
+	outbox, err := storage.ListOutbox(ctx, 100)
+	require.NoError(t, err)
+	require.Empty(t, outbox, "the notifications are enqueued along with marking the events")
+
+	notifier := NewNotifier(log, storage)
+	enqueued, err := notifier.Notify(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, 3, enqueued)
+
+	enqueued, err = notifier.Notify(ctx, now.Add(2*time.Second))
+	require.NoError(t, err)
+	require.Zero(t, enqueued)
+	enqueued, err = notifier.Notify(ctx, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, enqueued, "the next reminder fires")
+	enqueued, err = notifier.Notify(ctx, now.Add(52*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, enqueued)
+	enqueued, err = notifier.Notify(ctx, now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, 1, enqueued)
+
+	outbox, err = storage.ListOutbox(ctx, 100)
+	require.NoError(t, err)
+	var titles []string
+	for _, notification := range outbox {
+		titles = append(titles, fmt.Sprintf("%s %d", notification.Title, notification.Reminder))
+	}
+	require.ElementsMatch(t, []string{
+		"standup 300", "release 86400", "review 300", "release 3600", "release 600", "standup 300",
+	}, titles)
+}
@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRelayPeriod = time.Second
+	defaultRelayBatch  = 100
+)
+
+type OutboxStorage interface {
+	ListOutbox(ctx context.Context, limit int) (notifications []common.Notification, err error)
+	DeleteFromOutbox(ctx context.Context, keys []string) (err error)
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type Publisher interface {
+	Publish(notification *common.Notification) error
+}
+
+// Relay drains the outbox of notifications to the queue.
+type Relay struct {
+	log       *logrus.Logger
+	storage   OutboxStorage
+	publisher Publisher
+	batch     int
+}
+
+// NewRelay returns a relay publishing up to batch notifications per transaction, defaultRelayBatch if it isn't positive.
+func NewRelay(log *logrus.Logger, storage OutboxStorage, publisher Publisher, batch int) *Relay {
+	if batch <= 0 {
+		batch = defaultRelayBatch
+	}
+	return &Relay{log: log, storage: storage, publisher: publisher, batch: batch}
+}
+
+// Run drains the outbox every period, defaultRelayPeriod if it isn't positive, until ctx is done.
+func (r *Relay) Run(ctx context.Context, period time.Duration) {
+	if period <= 0 {
+		period = defaultRelayPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Drain(ctx); err != nil {
+			r.log.Warn("failed to relay notifications: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain relays batches of notifications until the outbox is empty and returns the number of published ones.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	var published int
+	for {
+		n, err := r.Relay(ctx)
+		published += n
+		if err != nil || n < r.batch {
+			return published, err
+		}
+		if err = ctx.Err(); err != nil {
+			return published, err
+		}
+	}
+}
+
+// Relay publishes a batch of notifications in the order they were enqueued and removes the published ones
+// from the outbox within a transaction holding their locks, so concurrent relays skip them. The batch stops
+// at the first notification failing to publish. A notification is published again if the transaction fails
+// after publishing it, so the delivery is at least once and the consumers tell the repeated ones by the key.
+func (r *Relay) Relay(ctx context.Context) (published int, err error) {
+	var failure error
+	err = r.storage.WithTx(ctx, func(ctx context.Context) error {
+		notifications, err := r.storage.ListOutbox(ctx, r.batch)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(notifications))
+		for i := range notifications {
+			if failure = r.publisher.Publish(&notifications[i]); failure != nil {
+				break
+			}
+			keys = append(keys, notifications[i].Key)
+		}
+		published = len(keys)
+		return r.storage.DeleteFromOutbox(ctx, keys)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, failure
+}
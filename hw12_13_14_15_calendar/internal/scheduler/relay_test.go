@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	memorystorage "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/storage/memory"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type testPublisher struct {
+	published []common.Notification
+	failing   map[string]bool
+}
+
+func (p *testPublisher) Publish(notification *common.Notification) error {
+	if p.failing[notification.Title] {
+		return errors.New("connection is closed")
+	}
+	p.published = append(p.published, *notification)
+	return nil
+}
+
+func TestRelay(t *testing.T) {
+	log := logrus.New()
+	ctx := context.Background()
+	storage := memorystorage.New(log)
+	var notifications []common.Notification
+	for i := 1; i <= 5; i++ {
+		notifications = append(notifications, common.Notification{ID: int64(i), Title: "event " + strconv.Itoa(i), Key: strconv.Itoa(i)})
+	}
+	require.NoError(t, storage.EnqueueNotifications(ctx, notifications))
+	require.NoError(t, storage.EnqueueNotifications(ctx, notifications[:1]), "the repeated keys are ignored")
+	publisher := &testPublisher{failing: map[string]bool{"event 4": true}}
+	relay := NewRelay(log, storage, publisher, 2)
+
+	published, err := relay.Drain(ctx)
+	require.Error(t, err)
+	require.Equal(t, 3, published, "the relay stops at the failed notification")
+	outbox, err := storage.ListOutbox(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, outbox, 2)
+
+	publisher.failing = nil
+	published, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, published)
+	outbox, err = storage.ListOutbox(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, outbox)
+
+	var keys []string
+	for _, notification := range publisher.published {
+		keys = append(keys, notification.Key)
+	}
+	require.Equal(t, []string{"1", "2", "3", "4", "5"}, keys, "the notifications are published in order")
+}
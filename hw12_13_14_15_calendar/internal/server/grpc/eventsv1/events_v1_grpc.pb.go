@@ -25,9 +25,16 @@ type EventsHandlerClient interface {
 	ListEventsByDay(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error)
 	ListEventsByWeek(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error)
 	ListEventsByMonth(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error)
+	ListEvents(ctx context.Context, in *ListEventsPageRequest, opts ...grpc.CallOption) (*ListEventsPageResponse, error)
 	CreateEvent(ctx context.Context, in *CreateEventRequest, opts ...grpc.CallOption) (*CreateEventResponse, error)
 	UpdateEvent(ctx context.Context, in *UpdateEventRequest, opts ...grpc.CallOption) (*UpdateEventResponse, error)
 	DeleteEvent(ctx context.Context, in *DeleteEventRequest, opts ...grpc.CallOption) (*DeleteEventResponse, error)
+	GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*GetEventResponse, error)
+	InviteAttendees(ctx context.Context, in *InviteAttendeesRequest, opts ...grpc.CallOption) (*InviteAttendeesResponse, error)
+	RespondToInvitation(ctx context.Context, in *RespondToInvitationRequest, opts ...grpc.CallOption) (*RespondToInvitationResponse, error)
+	ListInvitations(ctx context.Context, in *ListInvitationsRequest, opts ...grpc.CallOption) (*ListInvitationsResponse, error)
+	FreeBusy(ctx context.Context, in *FreeBusyRequest, opts ...grpc.CallOption) (*FreeBusyResponse, error)
+	FindSlots(ctx context.Context, in *FindSlotsRequest, opts ...grpc.CallOption) (*FindSlotsResponse, error)
 }
 
 type eventsHandlerClient struct {
@@ -65,6 +72,15 @@ func (c *eventsHandlerClient) ListEventsByMonth(ctx context.Context, in *ListEve
 	return out, nil
 }
 
+func (c *eventsHandlerClient) ListEvents(ctx context.Context, in *ListEventsPageRequest, opts ...grpc.CallOption) (*ListEventsPageResponse, error) {
+	out := new(ListEventsPageResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/ListEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *eventsHandlerClient) CreateEvent(ctx context.Context, in *CreateEventRequest, opts ...grpc.CallOption) (*CreateEventResponse, error) {
 	out := new(CreateEventResponse)
 	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/CreateEvent", in, out, opts...)
@@ -92,6 +108,60 @@ func (c *eventsHandlerClient) DeleteEvent(ctx context.Context, in *DeleteEventRe
 	return out, nil
 }
 
+func (c *eventsHandlerClient) GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*GetEventResponse, error) {
+	out := new(GetEventResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/GetEvent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventsHandlerClient) InviteAttendees(ctx context.Context, in *InviteAttendeesRequest, opts ...grpc.CallOption) (*InviteAttendeesResponse, error) {
+	out := new(InviteAttendeesResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/InviteAttendees", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventsHandlerClient) RespondToInvitation(ctx context.Context, in *RespondToInvitationRequest, opts ...grpc.CallOption) (*RespondToInvitationResponse, error) {
+	out := new(RespondToInvitationResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/RespondToInvitation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventsHandlerClient) ListInvitations(ctx context.Context, in *ListInvitationsRequest, opts ...grpc.CallOption) (*ListInvitationsResponse, error) {
+	out := new(ListInvitationsResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/ListInvitations", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventsHandlerClient) FreeBusy(ctx context.Context, in *FreeBusyRequest, opts ...grpc.CallOption) (*FreeBusyResponse, error) {
+	out := new(FreeBusyResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/FreeBusy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *eventsHandlerClient) FindSlots(ctx context.Context, in *FindSlotsRequest, opts ...grpc.CallOption) (*FindSlotsResponse, error) {
+	out := new(FindSlotsResponse)
+	err := c.cc.Invoke(ctx, "/eventsv1.EventsHandler/FindSlots", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // EventsHandlerServer is the server API for EventsHandler service.
 // All implementations should embed UnimplementedEventsHandlerServer
 // for forward compatibility
@@ -99,9 +169,16 @@ type EventsHandlerServer interface {
 	ListEventsByDay(context.Context, *ListEventsRequest) (*ListEventsResponse, error)
 	ListEventsByWeek(context.Context, *ListEventsRequest) (*ListEventsResponse, error)
 	ListEventsByMonth(context.Context, *ListEventsRequest) (*ListEventsResponse, error)
+	ListEvents(context.Context, *ListEventsPageRequest) (*ListEventsPageResponse, error)
 	CreateEvent(context.Context, *CreateEventRequest) (*CreateEventResponse, error)
 	UpdateEvent(context.Context, *UpdateEventRequest) (*UpdateEventResponse, error)
 	DeleteEvent(context.Context, *DeleteEventRequest) (*DeleteEventResponse, error)
+	GetEvent(context.Context, *GetEventRequest) (*GetEventResponse, error)
+	InviteAttendees(context.Context, *InviteAttendeesRequest) (*InviteAttendeesResponse, error)
+	RespondToInvitation(context.Context, *RespondToInvitationRequest) (*RespondToInvitationResponse, error)
+	ListInvitations(context.Context, *ListInvitationsRequest) (*ListInvitationsResponse, error)
+	FreeBusy(context.Context, *FreeBusyRequest) (*FreeBusyResponse, error)
+	FindSlots(context.Context, *FindSlotsRequest) (*FindSlotsResponse, error)
 }
 
 // UnimplementedEventsHandlerServer should be embedded to have forward compatible implementations.
@@ -117,6 +194,9 @@ func (UnimplementedEventsHandlerServer) ListEventsByWeek(context.Context, *ListE
 func (UnimplementedEventsHandlerServer) ListEventsByMonth(context.Context, *ListEventsRequest) (*ListEventsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListEventsByMonth not implemented")
 }
+func (UnimplementedEventsHandlerServer) ListEvents(context.Context, *ListEventsPageRequest) (*ListEventsPageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEvents not implemented")
+}
 func (UnimplementedEventsHandlerServer) CreateEvent(context.Context, *CreateEventRequest) (*CreateEventResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateEvent not implemented")
 }
@@ -126,6 +206,24 @@ func (UnimplementedEventsHandlerServer) UpdateEvent(context.Context, *UpdateEven
 func (UnimplementedEventsHandlerServer) DeleteEvent(context.Context, *DeleteEventRequest) (*DeleteEventResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteEvent not implemented")
 }
+func (UnimplementedEventsHandlerServer) GetEvent(context.Context, *GetEventRequest) (*GetEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvent not implemented")
+}
+func (UnimplementedEventsHandlerServer) InviteAttendees(context.Context, *InviteAttendeesRequest) (*InviteAttendeesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InviteAttendees not implemented")
+}
+func (UnimplementedEventsHandlerServer) RespondToInvitation(context.Context, *RespondToInvitationRequest) (*RespondToInvitationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RespondToInvitation not implemented")
+}
+func (UnimplementedEventsHandlerServer) ListInvitations(context.Context, *ListInvitationsRequest) (*ListInvitationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListInvitations not implemented")
+}
+func (UnimplementedEventsHandlerServer) FreeBusy(context.Context, *FreeBusyRequest) (*FreeBusyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FreeBusy not implemented")
+}
+func (UnimplementedEventsHandlerServer) FindSlots(context.Context, *FindSlotsRequest) (*FindSlotsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindSlots not implemented")
+}
 
 // UnsafeEventsHandlerServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to EventsHandlerServer will
@@ -192,6 +290,24 @@ func _EventsHandler_ListEventsByMonth_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _EventsHandler_ListEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEventsPageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).ListEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/ListEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).ListEvents(ctx, req.(*ListEventsPageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _EventsHandler_CreateEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(CreateEventRequest)
 	if err := dec(in); err != nil {
@@ -246,6 +362,114 @@ func _EventsHandler_DeleteEvent_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _EventsHandler_GetEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).GetEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/GetEvent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).GetEvent(ctx, req.(*GetEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventsHandler_InviteAttendees_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InviteAttendeesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).InviteAttendees(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/InviteAttendees",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).InviteAttendees(ctx, req.(*InviteAttendeesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventsHandler_RespondToInvitation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RespondToInvitationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).RespondToInvitation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/RespondToInvitation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).RespondToInvitation(ctx, req.(*RespondToInvitationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventsHandler_ListInvitations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListInvitationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).ListInvitations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/ListInvitations",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).ListInvitations(ctx, req.(*ListInvitationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventsHandler_FreeBusy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FreeBusyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).FreeBusy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/FreeBusy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).FreeBusy(ctx, req.(*FreeBusyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EventsHandler_FindSlots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindSlotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventsHandlerServer).FindSlots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventsv1.EventsHandler/FindSlots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventsHandlerServer).FindSlots(ctx, req.(*FindSlotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // EventsHandler_ServiceDesc is the grpc.ServiceDesc for EventsHandler service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -265,6 +489,10 @@ var EventsHandler_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListEventsByMonth",
 			Handler:    _EventsHandler_ListEventsByMonth_Handler,
 		},
+		{
+			MethodName: "ListEvents",
+			Handler:    _EventsHandler_ListEvents_Handler,
+		},
 		{
 			MethodName: "CreateEvent",
 			Handler:    _EventsHandler_CreateEvent_Handler,
@@ -277,6 +505,30 @@ var EventsHandler_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteEvent",
 			Handler:    _EventsHandler_DeleteEvent_Handler,
 		},
+		{
+			MethodName: "GetEvent",
+			Handler:    _EventsHandler_GetEvent_Handler,
+		},
+		{
+			MethodName: "InviteAttendees",
+			Handler:    _EventsHandler_InviteAttendees_Handler,
+		},
+		{
+			MethodName: "RespondToInvitation",
+			Handler:    _EventsHandler_RespondToInvitation_Handler,
+		},
+		{
+			MethodName: "ListInvitations",
+			Handler:    _EventsHandler_ListInvitations_Handler,
+		},
+		{
+			MethodName: "FreeBusy",
+			Handler:    _EventsHandler_FreeBusy_Handler,
+		},
+		{
+			MethodName: "FindSlots",
+			Handler:    _EventsHandler_FindSlots_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "events_v1.proto",
@@ -2,17 +2,51 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/server/grpc/eventsv1"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// OwnerMetadataKey carries the ID of the user the request is made on behalf of.
+const OwnerMetadataKey = "x-user-id"
+
+// maskFields maps the paths of an update mask onto the patchable fields of common.Event.
+var maskFields = map[string]string{
+	"title":         common.FieldTitle,
+	"start_time":    common.FieldStartTime,
+	"time_zone":     common.FieldTimeZone,
+	"all_day":       common.FieldAllDay,
+	"duration":      common.FieldDuration,
+	"description":   common.FieldDescription,
+	"notify_time":   common.FieldNotifyTime,
+	"reminders":     common.FieldReminders,
+	"rrule":         common.FieldRRule,
+	"exdates":       common.FieldExDates,
+	"parent_id":     common.FieldParentID,
+	"recurrence_id": common.FieldRecurrenceID,
+}
+
+// attendeeStatuses maps the invitation statuses onto the proto ones.
+var attendeeStatuses = map[common.AttendeeStatus]eventsv1.AttendeeStatus{
+	common.StatusPending:   eventsv1.AttendeeStatus_ATTENDEE_STATUS_PENDING,
+	common.StatusAccepted:  eventsv1.AttendeeStatus_ATTENDEE_STATUS_ACCEPTED,
+	common.StatusDeclined:  eventsv1.AttendeeStatus_ATTENDEE_STATUS_DECLINED,
+	common.StatusTentative: eventsv1.AttendeeStatus_ATTENDEE_STATUS_TENTATIVE,
+}
+
 //go:generate protoc -I=proto/ proto/events_v1.proto --go_out=. --go-grpc_out=require_unimplemented_servers=false:.
 
 type RPCServer struct {
@@ -29,8 +63,72 @@ func NewRPCServer(app common.Application, log *logrus.Logger, network string, po
 		network: network,
 		app:     app,
 		port:    port,
-		server:  grpc.NewServer(),
+		server:  grpc.NewServer(grpc.UnaryInterceptor(ownerInterceptor)),
+	}
+}
+
+func ownerInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(OwnerMetadataKey)
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, common.ErrNoOwner.Error())
+	}
+	owner, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, common.ErrNoOwner.Error())
 	}
+	return handler(common.WithOwner(ctx, owner), req)
+}
+
+// toStatus maps an application error onto a gRPC status error.
+func toStatus(err error) error {
+	var code codes.Code
+	var validation *common.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		return validationStatus(validation)
+	case errors.Is(err, common.ErrNoSuchEvent):
+		code = codes.NotFound
+	case errors.Is(err, common.ErrForbidden), errors.Is(err, common.ErrNotInvited):
+		code = codes.PermissionDenied
+	case errors.Is(err, common.ErrNoOwner):
+		code = codes.Unauthenticated
+	case errors.Is(err, common.ErrInvalidRRule), errors.Is(err, common.ErrInvalidCursor),
+		errors.Is(err, common.ErrInvalidPeriod), errors.Is(err, common.ErrUnknownField),
+		errors.Is(err, common.ErrInvalidStatus), errors.Is(err, common.ErrInvalidAttendee),
+		errors.Is(err, common.ErrInvalidQuery), errors.Is(err, common.ErrUnknownTimeZone):
+		code = codes.InvalidArgument
+	case errors.Is(err, common.ErrDateBusy):
+		code = codes.AlreadyExists
+	case errors.Is(err, common.ErrVersionConflict):
+		code = codes.Aborted
+	default:
+		return err
+	}
+	return status.Error(code, err.Error())
+}
+
+// validationStatus returns InvalidArgument with the invalid fields named as in the proto Event.
+func validationStatus(validation *common.ValidationError) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range validation.Fields {
+		name := field.Field
+		for path, eventField := range maskFields {
+			if eventField == field.Field {
+				name = path
+				break
+			}
+		}
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       name,
+			Description: field.Reason,
+		})
+	}
+	st, err := status.New(codes.InvalidArgument, validation.Error()).WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, validation.Error())
+	}
+	return st.Err()
 }
 
 func (r *RPCServer) Start(ctx context.Context) error {
@@ -55,27 +153,72 @@ func (r *RPCServer) Stop() {
 }
 
 func (r *RPCServer) UpdateEvent(ctx context.Context, request *eventsv1.UpdateEventRequest) (*eventsv1.UpdateEventResponse, error) {
-	err := r.app.UpdateEvent(ctx, request.GetId(), pb2Event(request.GetEvent()))
-	return &eventsv1.UpdateEventResponse{}, err
+	if request.GetAllowOverlap() {
+		ctx = common.WithAllowOverlap(ctx)
+	}
+	if request.GetExpectedVersion() != 0 {
+		ctx = common.WithExpectedVersion(ctx, request.GetExpectedVersion())
+	}
+	event := pb2Event(request.GetEvent())
+	if paths := request.GetUpdateMask().GetPaths(); len(paths) > 0 {
+		fields := make([]string, 0, len(paths))
+		for _, path := range paths {
+			field, ok := maskFields[path]
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "%s: %s", common.ErrUnknownField, path)
+			}
+			fields = append(fields, field)
+		}
+		patched, err := r.app.PatchEvent(ctx, request.GetId(), event, fields)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		return &eventsv1.UpdateEventResponse{Event: Event2Pb(*patched)}, nil
+	}
+	if err := r.app.UpdateEvent(ctx, request.GetId(), event); err != nil {
+		return nil, toStatus(err)
+	}
+	event.ID = request.GetId()
+	return &eventsv1.UpdateEventResponse{Event: Event2Pb(*event)}, nil
 }
 
 func (r *RPCServer) DeleteEvent(ctx context.Context, id *eventsv1.DeleteEventRequest) (*eventsv1.DeleteEventResponse, error) {
-	err := r.app.DeleteEvent(ctx, id.GetId())
-	return &eventsv1.DeleteEventResponse{}, err
+	if id.GetExpectedVersion() != 0 {
+		ctx = common.WithExpectedVersion(ctx, id.GetExpectedVersion())
+	}
+	if err := r.app.DeleteEvent(ctx, id.GetId()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &eventsv1.DeleteEventResponse{}, nil
+}
+
+func (r *RPCServer) GetEvent(ctx context.Context, request *eventsv1.GetEventRequest) (*eventsv1.GetEventResponse, error) {
+	event, err := r.app.GetEvent(ctx, request.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &eventsv1.GetEventResponse{Event: Event2Pb(*event)}, nil
 }
 
 func (r *RPCServer) CreateEvent(ctx context.Context, event *eventsv1.CreateEventRequest) (*eventsv1.CreateEventResponse, error) {
+	if event.GetAllowOverlap() {
+		ctx = common.WithAllowOverlap(ctx)
+	}
 	id, err := r.app.CreateEvent(ctx, pb2Event(event.GetEvent()))
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
 	return &eventsv1.CreateEventResponse{Id: id}, nil
 }
 
 func (r *RPCServer) ListEventsByDay(ctx context.Context, date *eventsv1.ListEventsRequest) (*eventsv1.ListEventsResponse, error) {
-	eventsList, err := r.app.ListEventsByDay(ctx, date.GetFromDate().AsTime())
+	from, err := fromDate(date)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	eventsList, err := r.app.ListEventsByDay(ctx, from)
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
 	}
 	eventsProto := make([]*eventsv1.Event, 0, len(eventsList))
 	for _, event := range eventsList {
@@ -85,9 +228,13 @@ func (r *RPCServer) ListEventsByDay(ctx context.Context, date *eventsv1.ListEven
 }
 
 func (r *RPCServer) ListEventsByWeek(ctx context.Context, date *eventsv1.ListEventsRequest) (*eventsv1.ListEventsResponse, error) {
-	eventsList, err := r.app.ListEventsByWeek(ctx, date.GetFromDate().AsTime())
+	from, err := fromDate(date)
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
+	}
+	eventsList, err := r.app.ListEventsByWeek(ctx, from)
+	if err != nil {
+		return nil, toStatus(err)
 	}
 	eventsProto := make([]*eventsv1.Event, 0, len(eventsList))
 	for _, event := range eventsList {
@@ -97,9 +244,13 @@ func (r *RPCServer) ListEventsByWeek(ctx context.Context, date *eventsv1.ListEve
 }
 
 func (r *RPCServer) ListEventsByMonth(ctx context.Context, date *eventsv1.ListEventsRequest) (*eventsv1.ListEventsResponse, error) {
-	eventsList, err := r.app.ListEventsByMonth(ctx, date.GetFromDate().AsTime())
+	from, err := fromDate(date)
 	if err != nil {
-		return nil, err
+		return nil, toStatus(err)
+	}
+	eventsList, err := r.app.ListEventsByMonth(ctx, from)
+	if err != nil {
+		return nil, toStatus(err)
 	}
 	eventsProto := make([]*eventsv1.Event, 0, len(eventsList))
 	for _, event := range eventsList {
@@ -108,28 +259,192 @@ func (r *RPCServer) ListEventsByMonth(ctx context.Context, date *eventsv1.ListEv
 	return &eventsv1.ListEventsResponse{Events: eventsProto}, nil
 }
 
+// fromDate returns the start of the listed period, the midnight of the day from_date falls on
+// in the time zone of the request.
+func fromDate(request *eventsv1.ListEventsRequest) (time.Time, error) {
+	location, err := common.LoadLocation(request.GetTimeZone())
+	if err != nil {
+		return time.Time{}, err
+	}
+	date := request.GetFromDate().AsTime().In(location)
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location), nil
+}
+
+func (r *RPCServer) ListEvents(ctx context.Context, request *eventsv1.ListEventsPageRequest) (*eventsv1.ListEventsPageResponse, error) {
+	filter := common.EventFilter{
+		Owner:  request.GetOwner(),
+		Title:  request.GetTitle(),
+		Cursor: request.GetCursor(),
+		Limit:  int(request.GetLimit()),
+	}
+	eventsList, next, err := r.app.ListEvents(ctx, request.GetFrom().AsTime(), request.GetTo().AsTime(), filter)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	eventsProto := make([]*eventsv1.Event, 0, len(eventsList))
+	for _, event := range eventsList {
+		eventsProto = append(eventsProto, Event2Pb(event))
+	}
+	return &eventsv1.ListEventsPageResponse{Events: eventsProto, NextCursor: next}, nil
+}
+
+func (r *RPCServer) InviteAttendees(ctx context.Context, request *eventsv1.InviteAttendeesRequest) (*eventsv1.InviteAttendeesResponse, error) {
+	if err := r.app.InviteAttendees(ctx, request.GetId(), request.GetUsers()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &eventsv1.InviteAttendeesResponse{}, nil
+}
+
+func (r *RPCServer) RespondToInvitation(ctx context.Context, request *eventsv1.RespondToInvitationRequest) (*eventsv1.RespondToInvitationResponse, error) {
+	if err := r.app.RespondToInvitation(ctx, request.GetId(), pb2Status(request.GetStatus())); err != nil {
+		return nil, toStatus(err)
+	}
+	return &eventsv1.RespondToInvitationResponse{}, nil
+}
+
+func (r *RPCServer) ListInvitations(ctx context.Context, request *eventsv1.ListInvitationsRequest) (*eventsv1.ListInvitationsResponse, error) {
+	eventsList, err := r.app.ListInvitations(ctx, request.GetFrom().AsTime(), request.GetTo().AsTime(), pb2Status(request.GetStatus()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	eventsProto := make([]*eventsv1.Event, 0, len(eventsList))
+	for _, event := range eventsList {
+		eventsProto = append(eventsProto, Event2Pb(event))
+	}
+	return &eventsv1.ListInvitationsResponse{Events: eventsProto}, nil
+}
+
+func (r *RPCServer) FreeBusy(ctx context.Context, request *eventsv1.FreeBusyRequest) (*eventsv1.FreeBusyResponse, error) {
+	busy, err := r.app.FreeBusy(ctx, request.GetOwners(), request.GetFrom().AsTime(), request.GetTo().AsTime())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	owners := make([]*eventsv1.FreeBusy, 0, len(busy))
+	for _, owner := range busy {
+		owners = append(owners, &eventsv1.FreeBusy{Owner: owner.Owner, Busy: intervals2Pb(owner.Busy)})
+	}
+	return &eventsv1.FreeBusyResponse{Owners: owners}, nil
+}
+
+func (r *RPCServer) FindSlots(ctx context.Context, request *eventsv1.FindSlotsRequest) (*eventsv1.FindSlotsResponse, error) {
+	hours, err := pb2WorkingHours(request.GetWorkingHours())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	slots, err := r.app.FindSlots(ctx, common.SlotQuery{
+		Owners:       request.GetOwners(),
+		Duration:     time.Duration(request.GetDuration()) * time.Second,
+		From:         request.GetFrom().AsTime(),
+		To:           request.GetTo().AsTime(),
+		WorkingHours: hours,
+		Step:         time.Duration(request.GetStep()) * time.Second,
+		Limit:        int(request.GetLimit()),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &eventsv1.FindSlotsResponse{Slots: intervals2Pb(slots)}, nil
+}
+
+func pb2WorkingHours(source *eventsv1.WorkingHours) (common.WorkingHours, error) {
+	hours := common.WorkingHours{Start: int(source.GetStartMinute()), End: int(source.GetEndMinute())}
+	for _, day := range source.GetDays() {
+		if day < int32(time.Sunday) || day > int32(time.Saturday) {
+			return hours, fmt.Errorf("%w: unknown week day %d", common.ErrInvalidQuery, day)
+		}
+		hours.Days = append(hours.Days, time.Weekday(day))
+	}
+	if tz := source.GetTimeZone(); tz != "" {
+		location, err := common.LoadLocation(tz)
+		if err != nil {
+			return hours, err
+		}
+		hours.Location = location
+	}
+	return hours, nil
+}
+
+func intervals2Pb(source []common.Interval) []*eventsv1.Interval {
+	intervals := make([]*eventsv1.Interval, 0, len(source))
+	for _, interval := range source {
+		intervals = append(intervals, &eventsv1.Interval{Start: timestamppb.New(interval.Start), End: timestamppb.New(interval.End)})
+	}
+	return intervals
+}
+
+// pb2Status returns the invitation status, empty for the unspecified one and the unknown ones
+// are passed on as their names to be rejected by the application.
+func pb2Status(source eventsv1.AttendeeStatus) common.AttendeeStatus {
+	if source == eventsv1.AttendeeStatus_ATTENDEE_STATUS_UNSPECIFIED {
+		return ""
+	}
+	for status, pb := range attendeeStatuses {
+		if pb == source {
+			return status
+		}
+	}
+	return common.AttendeeStatus(source.String())
+}
+
 func Event2Pb(source common.Event) *eventsv1.Event {
-	return &eventsv1.Event{
+	event := &eventsv1.Event{
 		Id:          source.ID,
 		Title:       source.Title,
 		StartTime:   timestamppb.New(source.StartTime),
+		TimeZone:    source.TimeZone,
+		AllDay:      source.AllDay,
 		Duration:    source.Duration,
 		Description: source.Description,
 		Owner:       source.Owner,
 		NotifyTime:  source.NotifyTime,
 		Created:     timestamppb.New(source.Created),
 		Updated:     timestamppb.New(source.Updated),
+		Version:     source.Version,
+		Reminders:   source.Reminders,
+		Rrule:       source.RRule,
+		ParentId:    source.ParentID,
+	}
+	for _, exdate := range source.ExDates {
+		event.Exdates = append(event.Exdates, timestamppb.New(exdate))
 	}
+	if source.RecurrenceID != nil {
+		event.RecurrenceId = timestamppb.New(*source.RecurrenceID)
+	}
+	for _, attendee := range source.Attendees {
+		event.Attendees = append(event.Attendees, &eventsv1.Attendee{
+			User:    attendee.User,
+			Status:  attendeeStatuses[attendee.Status],
+			Updated: timestamppb.New(attendee.Updated),
+		})
+	}
+	return event
 }
 
 func pb2Event(source *eventsv1.Event) *common.Event {
-	return &common.Event{
+	event := &common.Event{
 		ID:          source.GetId(),
 		Title:       source.GetTitle(),
-		StartTime:   source.GetStartTime().AsTime(),
+		TimeZone:    source.GetTimeZone(),
+		AllDay:      source.GetAllDay(),
 		Duration:    source.GetDuration(),
 		Description: source.GetDescription(),
 		Owner:       source.GetOwner(),
 		NotifyTime:  source.GetNotifyTime(),
+		Version:     source.GetVersion(),
+		Reminders:   source.GetReminders(),
+		RRule:       source.GetRrule(),
+		ParentID:    source.GetParentId(),
+	}
+	// A missing start_time is left zero to be rejected by the validation rather than taken for the epoch.
+	if source.GetStartTime() != nil {
+		event.StartTime = source.GetStartTime().AsTime()
+	}
+	for _, exdate := range source.GetExdates() {
+		event.ExDates = append(event.ExDates, exdate.AsTime())
+	}
+	if source.GetRecurrenceId() != nil {
+		recurrenceID := source.GetRecurrenceId().AsTime()
+		event.RecurrenceID = &recurrenceID
 	}
+	return event
 }
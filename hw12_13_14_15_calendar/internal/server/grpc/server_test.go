@@ -13,7 +13,12 @@ import (
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/server/grpc/eventsv1"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -41,6 +46,12 @@ func TestRPCServer(t *testing.T) {
 	}()
 	require.NoError(t, err)
 	st, _ := time.Parse(common.PgTimestampFmt, common.PgTimestampFmt)
+	// The days are listed since the midnight.
+	day := time.Date(st.Year(), st.Month(), st.Day(), 0, 0, 0, 0, time.UTC)
+	_, err = client.ListEventsByDay(ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(st)})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, OwnerMetadataKey, "2")
 	events, err := client.ListEventsByDay(ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(st)})
 	require.NoError(t, err)
 
@@ -49,7 +60,7 @@ func TestRPCServer(t *testing.T) {
 		require.Equal(t, tmp, &common.Event{
 			ID:          int64(i),
 			Title:       "goga",
-			StartTime:   st,
+			StartTime:   day,
 			Duration:    60 * 60,
 			Description: "description",
 			Owner:       int64(i * 2),
@@ -65,7 +76,7 @@ func TestRPCServer(t *testing.T) {
 		require.Equal(t, tmp, &common.Event{
 			ID:          int64(i),
 			Title:       "goga",
-			StartTime:   st,
+			StartTime:   day,
 			Duration:    60 * 60,
 			Description: "description",
 			Owner:       int64(i * 2),
@@ -81,7 +92,7 @@ func TestRPCServer(t *testing.T) {
 		require.Equal(t, tmp, &common.Event{
 			ID:          int64(i),
 			Title:       "goga",
-			StartTime:   st,
+			StartTime:   day,
 			Duration:    60 * 60,
 			Description: "description",
 			Owner:       int64(i * 2),
@@ -89,6 +100,24 @@ func TestRPCServer(t *testing.T) {
 		})
 	}
 
+	page, err := client.ListEvents(ctx, &eventsv1.ListEventsPageRequest{From: timestamppb.New(st), To: timestamppb.New(st.AddDate(0, 0, 1))})
+	require.NoError(t, err)
+	require.Len(t, page.GetEvents(), 10)
+	require.NotEmpty(t, page.GetNextCursor())
+	page, err = client.ListEvents(ctx, &eventsv1.ListEventsPageRequest{Cursor: page.GetNextCursor()})
+	require.NoError(t, err)
+	require.Empty(t, page.GetEvents())
+	_, err = client.ListEvents(ctx, &eventsv1.ListEventsPageRequest{Cursor: "@"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	got, err := client.GetEvent(ctx, &eventsv1.GetEventRequest{Id: 2})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), got.GetEvent().GetId())
+	_, err = client.GetEvent(ctx, &eventsv1.GetEventRequest{Id: 0})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.GetEvent(ctx, &eventsv1.GetEventRequest{Id: 3})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
 	testEvent := common.Event{
 		ID:          1,
 		Title:       "goga",
@@ -102,6 +131,20 @@ func TestRPCServer(t *testing.T) {
 	_, err = client.CreateEvent(ctx, &eventsv1.CreateEventRequest{Event: Event2Pb(testEvent)})
 	require.Error(t, err)
 	require.True(t, strings.Contains(err.Error(), io.ErrShortBuffer.Error()))
+	_, err = client.CreateEvent(ctx, &eventsv1.CreateEventRequest{Event: &eventsv1.Event{}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	badRequest, ok := details[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Equal(t, "title", badRequest.GetFieldViolations()[0].GetField())
+	require.Equal(t, "must not be empty", badRequest.GetFieldViolations()[0].GetDescription())
+	require.True(t, pb2Event(&eventsv1.Event{Title: "goga"}).StartTime.IsZero())
+	_, err = client.CreateEvent(ctx, &eventsv1.CreateEventRequest{Event: &eventsv1.Event{Title: "goga"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	badRequest, ok = status.Convert(err).Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Equal(t, "start_time", badRequest.GetFieldViolations()[0].GetField())
 	testEvent.ID = 0
 	id, err := client.CreateEvent(ctx, &eventsv1.CreateEventRequest{Event: Event2Pb(testEvent)})
 	require.NoError(t, err)
@@ -114,25 +157,98 @@ func TestRPCServer(t *testing.T) {
 	_, err = client.UpdateEvent(ctx, &eventsv1.UpdateEventRequest{Event: Event2Pb(testEvent), Id: 0})
 	require.Error(t, err)
 	require.True(t, strings.Contains(err.Error(), common.ErrNoSuchEvent.Error()))
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.UpdateEvent(ctx, &eventsv1.UpdateEventRequest{Event: Event2Pb(testEvent), Id: 4})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = client.UpdateEvent(ctx, &eventsv1.UpdateEventRequest{Event: Event2Pb(testEvent), Id: 5, ExpectedVersion: 1})
+	require.Equal(t, codes.Aborted, status.Code(err))
 	testEvent.ID = 2
 	_, err = client.UpdateEvent(ctx, &eventsv1.UpdateEventRequest{Event: Event2Pb(testEvent), Id: 2})
 	require.NoError(t, err)
 
+	patched, err := client.UpdateEvent(ctx, &eventsv1.UpdateEventRequest{
+		Event:      &eventsv1.Event{Title: "patched", Description: "ignored", Reminders: []int32{3600, 600}},
+		Id:         2,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title", "notify_time", "reminders"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int32{3600, 600}, patched.GetEvent().GetReminders())
+	require.Equal(t, "patched", patched.GetEvent().GetTitle())
+	require.Equal(t, "description", patched.GetEvent().GetDescription())
+	require.Equal(t, int32(0), patched.GetEvent().GetNotifyTime())
+	_, err = client.UpdateEvent(ctx, &eventsv1.UpdateEventRequest{
+		Event:      &eventsv1.Event{},
+		Id:         2,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"owner"}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
 	_, err = client.DeleteEvent(ctx, &eventsv1.DeleteEventRequest{Id: 0})
 	require.Error(t, err)
 	require.True(t, strings.Contains(err.Error(), common.ErrNoSuchEvent.Error()))
 	_, err = client.DeleteEvent(ctx, &eventsv1.DeleteEventRequest{Id: 1})
 	require.Error(t, err)
 	require.True(t, strings.Contains(err.Error(), io.ErrShortBuffer.Error()))
+	_, err = client.DeleteEvent(ctx, &eventsv1.DeleteEventRequest{Id: 5, ExpectedVersion: 1})
+	require.Equal(t, codes.Aborted, status.Code(err))
 	_, err = client.DeleteEvent(ctx, &eventsv1.DeleteEventRequest{Id: 2})
 	require.NoError(t, err)
 
+	_, err = client.InviteAttendees(ctx, &eventsv1.InviteAttendeesRequest{Id: 2, Users: []int64{3, 4}})
+	require.NoError(t, err)
+	_, err = client.InviteAttendees(ctx, &eventsv1.InviteAttendeesRequest{Id: 2, Users: []int64{-1}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.RespondToInvitation(ctx, &eventsv1.RespondToInvitationRequest{Id: 2, Status: eventsv1.AttendeeStatus_ATTENDEE_STATUS_ACCEPTED})
+	require.NoError(t, err)
+	_, err = client.RespondToInvitation(ctx, &eventsv1.RespondToInvitationRequest{Id: 2})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.RespondToInvitation(ctx, &eventsv1.RespondToInvitationRequest{Id: 3, Status: eventsv1.AttendeeStatus_ATTENDEE_STATUS_DECLINED})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	invitations, err := client.ListInvitations(ctx, &eventsv1.ListInvitationsRequest{From: timestamppb.New(st), To: timestamppb.New(st.AddDate(0, 0, 1))})
+	require.NoError(t, err)
+	require.Len(t, invitations.GetEvents(), 3)
+	_, err = client.ListInvitations(ctx, &eventsv1.ListInvitationsRequest{From: timestamppb.New(st), To: timestamppb.New(st)})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	busy, err := client.FreeBusy(ctx, &eventsv1.FreeBusyRequest{Owners: []int64{1, 2}, From: timestamppb.New(st), To: timestamppb.New(st.AddDate(0, 0, 1))})
+	require.NoError(t, err)
+	require.Len(t, busy.GetOwners(), 2)
+	require.Equal(t, st.Add(time.Hour), busy.GetOwners()[1].GetBusy()[0].GetEnd().AsTime())
+	slots, err := client.FindSlots(ctx, &eventsv1.FindSlotsRequest{
+		Duration: 1800, From: timestamppb.New(st), To: timestamppb.New(st.AddDate(0, 0, 1)),
+		WorkingHours: &eventsv1.WorkingHours{StartMinute: 540, EndMinute: 1080, Days: []int32{1, 2}, TimeZone: "Europe/Moscow"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, st.Add(30*time.Minute), slots.GetSlots()[0].GetEnd().AsTime())
+	for _, hours := range []*eventsv1.WorkingHours{{Days: []int32{7}}, {TimeZone: "Mars/Olympus"}} {
+		_, err = client.FindSlots(ctx, &eventsv1.FindSlotsRequest{Duration: 1800, From: timestamppb.New(st), To: timestamppb.New(st.AddDate(0, 0, 1)), WorkingHours: hours})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	_, err = client.FindSlots(ctx, &eventsv1.FindSlotsRequest{From: timestamppb.New(st), To: timestamppb.New(st.AddDate(0, 0, 1))})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ListEventsByMonth(ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(st), TimeZone: "Mars/Olympus"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	events, err = client.ListEventsByDay(ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(st), TimeZone: "Europe/Moscow"})
+	require.NoError(t, err)
+	require.NotEmpty(t, events.GetEvents())
+	// It is already the 2nd of March in Moscow, the day starts at 21:00 UTC of the 1st.
+	lateEvening := time.Date(2021, 3, 1, 22, 30, 0, 0, time.UTC)
+	events, err = client.ListEventsByDay(ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(lateEvening), TimeZone: "Europe/Moscow"})
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 3, 1, 21, 0, 0, 0, time.UTC), events.GetEvents()[0].GetStartTime().AsTime())
+
+	attended := Event2Pb(common.Event{Attendees: []common.Attendee{{User: 3, Status: common.StatusTentative, Updated: st}}})
+	require.Equal(t, eventsv1.AttendeeStatus_ATTENDEE_STATUS_TENTATIVE, attended.GetAttendees()[0].GetStatus())
+
 	r.Stop()
 	wg.Wait()
 }
 
 func StartClient() (eventsv1.EventsHandlerClient, *grpc.ClientConn, error) {
-	cc, err := grpc.Dial("localhost:"+strconv.Itoa(testPort), grpc.WithInsecure())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, "localhost:"+strconv.Itoa(testPort), grpc.WithInsecure(), grpc.WithBlock())
 	if err != nil {
 		return nil, nil, err
 	}
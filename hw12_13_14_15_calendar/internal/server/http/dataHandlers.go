@@ -1,79 +1,151 @@
 package internalhttp
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
 	"github.com/go-chi/chi"
 )
 
-const dateFormat = "2006-01-02"
+const (
+	dateFormat = "2006-01-02"
+	// allowOverlapParam lets addEvent and editEvent book the time taken by another event.
+	allowOverlapParam = "allowOverlap"
+	// tzParam is the IANA time zone the dates of a query are in, UTC if it is omitted.
+	tzParam = "tz"
+)
 
 var (
 	ErrWrongEventID     = errors.New("invalid or empty id")
 	ErrEmptyRequestBody = errors.New("empty request body")
 	ErrUnparsableEvent  = errors.New("err parsing event")
+	ErrWrongIfMatch     = errors.New("invalid If-Match header, use the ETag of the event")
+	ErrWeakIfMatch      = errors.New("weak entity tags never match If-Match")
+	ErrUnparsableBody   = errors.New("err parsing request body")
+	ErrUnknownTimeZone  = errors.New("unknown time zone, use an IANA name such as Europe/Moscow")
 )
 
 type JSONResponse struct {
 	Data  interface{} `json:"data,omitempty"`
 	Error *string     `json:"error,omitempty"`
-	Code  int         `json:"code"`
+	// Details lists the invalid fields of an event.
+	Details []common.FieldError `json:"details,omitempty"`
+	Code    int                 `json:"code"`
 }
 
 type ID struct {
 	ID int64 `json:"id"`
 }
 
+// Invitation is the body of the requests inviting users to an event.
+type Invitation struct {
+	Users []int64 `json:"users"`
+}
+
+// Answer is the body of the responses to an invitation.
+type Answer struct {
+	Status common.AttendeeStatus `json:"status"`
+}
+
+type EventsPage struct {
+	Events     []common.Event `json:"events"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// listEventsHandler accepts from and to as RFC 3339 times or YYYY-MM-DD dates,
+// optional owner, title, limit and the cursor returned with the previous page.
+func (h *EventHandler) listEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := periodParams(r)
+	if err != nil {
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := common.EventFilter{Title: query.Get("title"), Cursor: query.Get("cursor")}
+	if ownerStr := query.Get("owner"); ownerStr != "" {
+		if filter.Owner, err = strconv.ParseInt(ownerStr, 10, 64); err != nil {
+			writeErrResponse(w, "unparsable owner", http.StatusBadRequest)
+			return
+		}
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if filter.Limit, err = strconv.Atoi(limitStr); err != nil {
+			writeErrResponse(w, "unparsable limit", http.StatusBadRequest)
+			return
+		}
+	}
+	events, next, err := h.app.ListEvents(r.Context(), from, to, filter)
+	if err != nil {
+		h.writeAppErr(w, err, "failed to get list of events")
+		return
+	}
+	writeOkResponse(w, EventsPage{Events: events, NextCursor: next})
+}
+
 func (h *EventHandler) listEventsByDayHandler(w http.ResponseWriter, r *http.Request) {
-	dateStr := r.URL.Query().Get("date")
-	date, err := time.Parse(dateFormat, dateStr)
+	date, err := dateParam(r)
 	if err != nil {
-		writeErrResponse(w, "unparsable date, use YYYY-MM-DD: "+dateFormat, http.StatusBadRequest)
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	events, err := h.app.ListEventsByDay(r.Context(), date)
 	if err != nil {
-		h.log.Warn("failed to get list of events: ", err)
-		writeErrResponse(w, err.Error(), http.StatusInternalServerError)
+		h.writeAppErr(w, err, "failed to get list of events")
 		return
 	}
 	writeOkResponse(w, events)
 }
 
 func (h *EventHandler) listEventsByWeekHandler(w http.ResponseWriter, r *http.Request) {
-	dateStr := r.URL.Query().Get("date")
-	date, err := time.Parse(dateFormat, dateStr)
+	date, err := dateParam(r)
 	if err != nil {
-		writeErrResponse(w, "unparsable date, use YYYY-MM-DD: "+dateFormat, http.StatusBadRequest)
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	events, err := h.app.ListEventsByWeek(r.Context(), date)
 	if err != nil {
-		h.log.Warn("failed to get list of events: ", err)
-		writeErrResponse(w, err.Error(), http.StatusInternalServerError)
+		h.writeAppErr(w, err, "failed to get list of events")
 		return
 	}
 	writeOkResponse(w, events)
 }
 
 func (h *EventHandler) listEventsByMonthHandler(w http.ResponseWriter, r *http.Request) {
-	dateStr := r.URL.Query().Get("date")
-	date, err := time.Parse(dateFormat, dateStr)
+	date, err := dateParam(r)
 	if err != nil {
-		writeErrResponse(w, "unparsable date, use YYYY-MM-DD: "+dateFormat, http.StatusBadRequest)
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	events, err := h.app.ListEventsByMonth(r.Context(), date)
 	if err != nil {
-		h.log.Warn("failed to get list of events: ", err)
-		writeErrResponse(w, err.Error(), http.StatusInternalServerError)
+		h.writeAppErr(w, err, "failed to get list of events")
 		return
 	}
 	writeOkResponse(w, events)
 }
 
+func (h *EventHandler) getEventHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeErrResponse(w, ErrWrongEventID.Error(), http.StatusBadRequest)
+		h.log.Debug(err)
+		return
+	}
+	event, err := h.app.GetEvent(r.Context(), id)
+	if err != nil {
+		h.writeAppErr(w, err, fmt.Sprintf("failed to get an event %d", id))
+		return
+	}
+	writeOkResponse(w, event)
+}
+
 func (h *EventHandler) deleteEventHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := parseIDParam(r)
 	if err != nil {
@@ -81,15 +153,14 @@ func (h *EventHandler) deleteEventHandler(w http.ResponseWriter, r *http.Request
 		h.log.Debug(err)
 		return
 	}
-	err = h.app.DeleteEvent(r.Context(), id)
+	ctx, err := ifMatchContext(r.Context(), r)
 	if err != nil {
-		if errors.Is(err, common.ErrNoSuchEvent) {
-			h.log.Debugf("failed to remove an event %d: %s", id, err.Error())
-			writeErrResponse(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		h.log.Warnf("failed to remove an event %d: %s", id, err.Error())
-		writeErrResponse(w, err.Error(), http.StatusInternalServerError)
+		writeErrResponse(w, err.Error(), ifMatchStatus(err))
+		return
+	}
+	err = h.app.DeleteEvent(ctx, id)
+	if err != nil {
+		h.writeAppErr(w, err, fmt.Sprintf("failed to remove an event %d", id))
 		return
 	}
 	writeOkResponse(w, ID{ID: id})
@@ -107,10 +178,9 @@ func (h *EventHandler) addEventHandler(w http.ResponseWriter, r *http.Request) {
 		writeErrResponse(w, ErrUnparsableEvent.Error(), http.StatusBadRequest)
 		return
 	}
-	id, err := h.app.CreateEvent(r.Context(), event)
+	id, err := h.app.CreateEvent(overlapContext(r), event)
 	if err != nil {
-		h.log.Warn("failed to add event: ", err)
-		writeErrResponse(w, err.Error(), http.StatusInternalServerError)
+		h.writeAppErr(w, err, "failed to add event")
 		return
 	}
 	writeOkResponse(w, ID{ID: id})
@@ -134,24 +204,121 @@ func (h *EventHandler) editEventHandler(w http.ResponseWriter, r *http.Request)
 		writeErrResponse(w, ErrUnparsableEvent.Error(), http.StatusBadRequest)
 		return
 	}
-	err = h.app.UpdateEvent(r.Context(), id, event)
+	ctx, err := ifMatchContext(overlapContext(r), r)
 	if err != nil {
-		if errors.Is(err, common.ErrNoSuchEvent) {
-			h.log.Debugf("failed to edit an event %d: %s", id, err.Error())
-			writeErrResponse(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		h.log.Warnf("failed to edit an event %d: %s", id, err.Error())
-		writeErrResponse(w, err.Error(), http.StatusInternalServerError)
+		writeErrResponse(w, err.Error(), ifMatchStatus(err))
+		return
+	}
+	err = h.app.UpdateEvent(ctx, id, event)
+	if err != nil {
+		h.writeAppErr(w, err, fmt.Sprintf("failed to edit an event %d", id))
 		return
 	}
 	writeOkResponse(w, ID{ID: id})
 }
 
+func (h *EventHandler) inviteAttendeesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeErrResponse(w, ErrWrongEventID.Error(), http.StatusBadRequest)
+		h.log.Debug(err)
+		return
+	}
+	var invitation Invitation
+	if err = json.NewDecoder(r.Body).Decode(&invitation); err != nil {
+		h.log.Debug("can't parse invitation: ", err)
+		writeErrResponse(w, ErrUnparsableBody.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.app.InviteAttendees(r.Context(), id, invitation.Users); err != nil {
+		h.writeAppErr(w, err, fmt.Sprintf("failed to invite attendees to an event %d", id))
+		return
+	}
+	writeOkResponse(w, ID{ID: id})
+}
+
+func (h *EventHandler) respondToInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		writeErrResponse(w, ErrWrongEventID.Error(), http.StatusBadRequest)
+		h.log.Debug(err)
+		return
+	}
+	var answer Answer
+	if err = json.NewDecoder(r.Body).Decode(&answer); err != nil {
+		h.log.Debug("can't parse answer: ", err)
+		writeErrResponse(w, ErrUnparsableBody.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = h.app.RespondToInvitation(r.Context(), id, answer.Status); err != nil {
+		h.writeAppErr(w, err, fmt.Sprintf("failed to respond to the invitation to an event %d", id))
+		return
+	}
+	writeOkResponse(w, ID{ID: id})
+}
+
+// listInvitationsHandler accepts from and to as RFC 3339 times or YYYY-MM-DD dates and an optional status.
+func (h *EventHandler) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := periodParams(r)
+	if err != nil {
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := h.app.ListInvitations(r.Context(), from, to, common.AttendeeStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		h.writeAppErr(w, err, "failed to get list of invitations")
+		return
+	}
+	writeOkResponse(w, events)
+}
+
+// writeAppErr maps an application error onto a response status, client errors are logged at debug level.
+func (h *EventHandler) writeAppErr(w http.ResponseWriter, err error, msg string) {
+	status := errStatus(err)
+	if status == http.StatusInternalServerError {
+		h.log.Warnf("%s: %s", msg, err)
+	} else {
+		h.log.Debugf("%s: %s", msg, err)
+	}
+	writeErrDetails(w, err.Error(), status, fieldErrors(err))
+}
+
+// fieldErrors returns the invalid fields if err is a validation error.
+func fieldErrors(err error) []common.FieldError {
+	var validation *common.ValidationError
+	if errors.As(err, &validation) {
+		return validation.Fields
+	}
+	return nil
+}
+
+func errStatus(err error) int {
+	var validation *common.ValidationError
+	switch {
+	case errors.As(err, &validation):
+		return http.StatusBadRequest
+	case errors.Is(err, common.ErrNoSuchEvent):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrForbidden), errors.Is(err, common.ErrNotInvited):
+		return http.StatusForbidden
+	case errors.Is(err, common.ErrNoOwner):
+		return http.StatusUnauthorized
+	case errors.Is(err, common.ErrInvalidRRule), errors.Is(err, common.ErrInvalidCursor),
+		errors.Is(err, common.ErrInvalidPeriod), errors.Is(err, common.ErrUnknownField),
+		errors.Is(err, common.ErrInvalidStatus), errors.Is(err, common.ErrInvalidAttendee),
+		errors.Is(err, common.ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, common.ErrDateBusy), errors.Is(err, common.ErrVersionConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func writeOkResponse(w http.ResponseWriter, data interface{}) {
 	status := http.StatusOK
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	w.Header().Set("Content-type", "application/json")
 	response := JSONResponse{
 		Data: &data,
 		Code: status,
@@ -160,15 +327,108 @@ func writeOkResponse(w http.ResponseWriter, data interface{}) {
 }
 
 func writeErrResponse(w http.ResponseWriter, err string, status int) {
+	writeErrDetails(w, err, status, nil)
+}
+
+func writeErrDetails(w http.ResponseWriter, err string, status int, details []common.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	w.Header().Set("Content-type", "application/json")
 	response := JSONResponse{
-		Error: &err,
-		Code:  status,
+		Error:   &err,
+		Details: details,
+		Code:    status,
 	}
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// parseTimeParam parses an RFC 3339 time or a YYYY-MM-DD date, the date is the midnight in the location.
+func parseTimeParam(value string, location *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateFormat, value, location)
+}
+
+// locationParam returns the time zone of the tz parameter, UTC if it is omitted.
+func locationParam(r *http.Request) (*time.Location, error) {
+	location, err := common.LoadLocation(r.URL.Query().Get(tzParam))
+	if err != nil {
+		return nil, ErrUnknownTimeZone
+	}
+	return location, nil
+}
+
+// dateParam returns the midnight of the date parameter in the time zone of the tz one, so the days
+// of the listed period are counted in that time zone.
+func dateParam(r *http.Request) (time.Time, error) {
+	location, err := locationParam(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, err := time.ParseInLocation(dateFormat, r.URL.Query().Get("date"), location)
+	if err != nil {
+		return time.Time{}, errors.New("unparsable date, use YYYY-MM-DD: " + dateFormat)
+	}
+	return date, nil
+}
+
+// periodParams parses from and to as RFC 3339 times or YYYY-MM-DD dates in the time zone of the tz parameter.
+func periodParams(r *http.Request) (from, to time.Time, err error) {
+	location, err := locationParam(r)
+	if err != nil {
+		return from, to, err
+	}
+	query := r.URL.Query()
+	if from, err = parseTimeParam(query.Get("from"), location); err != nil {
+		return from, to, errors.New("unparsable from, use RFC 3339 or YYYY-MM-DD")
+	}
+	if to, err = parseTimeParam(query.Get("to"), location); err != nil {
+		return from, to, errors.New("unparsable to, use RFC 3339 or YYYY-MM-DD")
+	}
+	return from, to, nil
+}
+
+func overlapContext(r *http.Request) context.Context {
+	if allow, _ := strconv.ParseBool(r.URL.Query().Get(allowOverlapParam)); allow {
+		return common.WithAllowOverlap(r.Context())
+	}
+	return r.Context()
+}
+
+// ifMatchContext returns ctx expecting the event version from the If-Match header, "*" matches any version.
+// If-Match uses the strong comparison (RFC 7232 §3.1), so a weak entity tag fails with ErrWeakIfMatch.
+func ifMatchContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return ctx, nil
+	}
+	if strings.HasPrefix(ifMatch, "W/") {
+		return nil, ErrWeakIfMatch
+	}
+	tag, err := strconv.Unquote(ifMatch)
+	if err != nil {
+		return nil, ErrWrongIfMatch
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 {
+		return nil, ErrWrongIfMatch
+	}
+	return common.WithExpectedVersion(ctx, version), nil
+}
+
+// ifMatchStatus returns the status of the failure of ifMatchContext, the failed precondition for a weak entity tag.
+func ifMatchStatus(err error) int {
+	if errors.Is(err, ErrWeakIfMatch) {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusBadRequest
+}
+
+// etag returns the entity tag of an event version.
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
 func parseIDParam(r *http.Request) (int64, error) {
 	idStr := chi.URLParam(r, "id")
 	if idStr == "" {
@@ -0,0 +1,144 @@
+package internalhttp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+// FreeBusyList is the v2 free/busy response, the owners go in the order of the query.
+type FreeBusyList struct {
+	Owners []common.FreeBusy `json:"owners"`
+}
+
+// SlotList is the v2 response of the slot finder.
+type SlotList struct {
+	Slots []common.Interval `json:"slots"`
+}
+
+// parseOwners parses a comma-separated list of the owner IDs.
+func parseOwners(value string) ([]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var owners []int64
+	for _, s := range strings.Split(value, ",") {
+		owner, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, errors.New("unparsable owners, use a comma-separated list of IDs")
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
+
+// freeBusyParams parses the owners and the period, see periodParams.
+func freeBusyParams(r *http.Request) (owners []int64, from, to time.Time, err error) {
+	if owners, err = parseOwners(r.URL.Query().Get("owners")); err != nil {
+		return nil, from, to, err
+	}
+	if from, to, err = periodParams(r); err != nil {
+		return nil, from, to, err
+	}
+	return owners, from, to, nil
+}
+
+// slotQueryParams parses the slot query. The duration and the step are Go durations such as 1h30m,
+// the working hours are workStart and workEnd as HH:MM and the days as MO,TU,WE in the time zone of tz.
+func slotQueryParams(r *http.Request) (query common.SlotQuery, err error) {
+	if query.Owners, query.From, query.To, err = freeBusyParams(r); err != nil {
+		return query, err
+	}
+	params := r.URL.Query()
+	if query.Duration, err = time.ParseDuration(params.Get("duration")); err != nil {
+		return query, errors.New("unparsable duration, use a duration such as 1h30m")
+	}
+	if step := params.Get("step"); step != "" {
+		if query.Step, err = time.ParseDuration(step); err != nil {
+			return query, errors.New("unparsable step, use a duration such as 15m")
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return query, errors.New("unparsable limit")
+		}
+	}
+	hours := &query.WorkingHours
+	if start := params.Get("workStart"); start != "" {
+		if hours.Start, err = common.ParseClock(start); err != nil {
+			return query, err
+		}
+	}
+	if end := params.Get("workEnd"); end != "" {
+		if hours.End, err = common.ParseClock(end); err != nil {
+			return query, err
+		}
+	}
+	if days := params.Get("days"); days != "" {
+		if hours.Days, err = common.ParseWeekdays(days); err != nil {
+			return query, err
+		}
+	}
+	hours.Location, err = locationParam(r)
+	return query, err
+}
+
+func (h *EventHandler) freeBusyHandler(w http.ResponseWriter, r *http.Request) {
+	owners, from, to, err := freeBusyParams(r)
+	if err != nil {
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	busy, err := h.app.FreeBusy(r.Context(), owners, from, to)
+	if err != nil {
+		h.writeAppErr(w, err, "failed to get free/busy")
+		return
+	}
+	writeOkResponse(w, busy)
+}
+
+func (h *EventHandler) findSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := slotQueryParams(r)
+	if err != nil {
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slots, err := h.app.FindSlots(r.Context(), query)
+	if err != nil {
+		h.writeAppErr(w, err, "failed to find slots")
+		return
+	}
+	writeOkResponse(w, slots)
+}
+
+func (h *EventHandler) freeBusyV2(w http.ResponseWriter, r *http.Request) {
+	owners, from, to, err := freeBusyParams(r)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	busy, err := h.app.FreeBusy(r.Context(), owners, from, to)
+	if err != nil {
+		h.writeAppProblem(w, err, "failed to get free/busy")
+		return
+	}
+	writeJSON(w, http.StatusOK, FreeBusyList{Owners: busy})
+}
+
+func (h *EventHandler) findSlotsV2(w http.ResponseWriter, r *http.Request) {
+	query, err := slotQueryParams(r)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slots, err := h.app.FindSlots(r.Context(), query)
+	if err != nil {
+		h.writeAppProblem(w, err, "failed to find slots")
+		return
+	}
+	writeJSON(w, http.StatusOK, SlotList{Slots: slots})
+}
@@ -0,0 +1,185 @@
+package internalhttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/ical"
+)
+
+const (
+	// exportPageSize is the page size the export reads the events with.
+	exportPageSize = 500
+	// maxCalendarSize limits the imported files.
+	maxCalendarSize = 10 << 20
+)
+
+// ImportedItem is the outcome of importing a VEVENT, either the ID of the created event or the error.
+type ImportedItem struct {
+	UID     string              `json:"uid,omitempty"`
+	ID      int64               `json:"id,omitempty"`
+	Error   string              `json:"error,omitempty"`
+	Details []common.FieldError `json:"details,omitempty"`
+}
+
+// ImportResult lists the outcomes of the VEVENTs in the order of the imported file.
+type ImportResult struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Items    []ImportedItem `json:"items"`
+}
+
+// exportParams parses the period, see periodParams, and the optional owner.
+func exportParams(r *http.Request) (from, to time.Time, owner int64, err error) {
+	if from, to, err = periodParams(r); err != nil {
+		return from, to, 0, err
+	}
+	if ownerStr := r.URL.Query().Get("owner"); ownerStr != "" {
+		if owner, err = strconv.ParseInt(ownerStr, 10, 64); err != nil {
+			return from, to, 0, errors.New("unparsable owner")
+		}
+	}
+	return from, to, owner, nil
+}
+
+// exportCalendar returns the VCALENDAR of the events and occurrences of the owner overlapping [from, to).
+func (h *EventHandler) exportCalendar(ctx context.Context, from, to time.Time, owner int64) ([]byte, error) {
+	filter := common.EventFilter{Owner: owner, Limit: exportPageSize}
+	var events []common.Event
+	for {
+		page, next, err := h.app.ListEvents(ctx, from, to, filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+		if next == "" {
+			break
+		}
+		filter.Cursor = next
+	}
+	var b bytes.Buffer
+	if err := ical.Encode(&b, events, time.Now()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// importCalendar creates an event per VEVENT of the body, the failures of particular events don't stop the import.
+// The modified occurrences become the overrides of the recurring events with their UIDs imported along with them.
+func (h *EventHandler) importCalendar(w http.ResponseWriter, r *http.Request) (*ImportResult, error) {
+	items, err := ical.Decode(http.MaxBytesReader(w, r.Body, maxCalendarSize))
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Items: make([]ImportedItem, len(items))}
+	ctx := overlapContext(r)
+	series := make(map[string]int64)
+	// The series go first as the overrides refer to them.
+	for i := range items {
+		if items[i].Event.RecurrenceID == nil {
+			result.Items[i] = h.importItem(ctx, &items[i], result)
+			if items[i].Event.RRule != "" && result.Items[i].ID != 0 {
+				series[items[i].UID] = result.Items[i].ID
+			}
+		}
+	}
+	for i := range items {
+		if items[i].Event.RecurrenceID == nil {
+			continue
+		}
+		items[i].Event.ParentID = series[items[i].UID]
+		if items[i].Err == nil && items[i].Event.ParentID == 0 {
+			items[i].Err = fmt.Errorf("%w: the recurring event of the modified occurrence is not imported", ical.ErrInvalidEvent)
+		}
+		result.Items[i] = h.importItem(ctx, &items[i], result)
+	}
+	return result, nil
+}
+
+// importItem creates the event of the item and counts the outcome in the result.
+func (h *EventHandler) importItem(ctx context.Context, item *ical.Item, result *ImportResult) ImportedItem {
+	imported := ImportedItem{UID: item.UID}
+	err := item.Err
+	if err == nil {
+		imported.ID, err = h.app.CreateEvent(ctx, &item.Event)
+	}
+	if err != nil {
+		if errStatus(err) == http.StatusInternalServerError {
+			h.log.Warnf("failed to import event %q: %s", item.UID, err)
+		}
+		imported.Error = err.Error()
+		imported.Details = fieldErrors(err)
+		result.Failed++
+		return imported
+	}
+	result.Imported++
+	return imported
+}
+
+func writeCalendar(w http.ResponseWriter, calendar []byte) {
+	w.Header().Set("Content-Type", ical.ContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(calendar)
+}
+
+func (h *EventHandler) exportEventsHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, owner, err := exportParams(r)
+	if err != nil {
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	calendar, err := h.exportCalendar(r.Context(), from, to, owner)
+	if err != nil {
+		h.writeAppErr(w, err, "failed to export events")
+		return
+	}
+	writeCalendar(w, calendar)
+}
+
+func (h *EventHandler) importEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeErrResponse(w, ErrEmptyRequestBody.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := h.importCalendar(w, r)
+	if err != nil {
+		h.log.Debug("can't parse calendar: ", err)
+		writeErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeOkResponse(w, result)
+}
+
+func (h *EventHandler) exportCalendarV2(w http.ResponseWriter, r *http.Request) {
+	from, to, owner, err := exportParams(r)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	calendar, err := h.exportCalendar(r.Context(), from, to, owner)
+	if err != nil {
+		h.writeAppProblem(w, err, "failed to export events")
+		return
+	}
+	writeCalendar(w, calendar)
+}
+
+func (h *EventHandler) importCalendarV2(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		writeProblem(w, ErrEmptyRequestBody.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := h.importCalendar(w, r)
+	if err != nil {
+		h.log.Debug("can't parse calendar: ", err)
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -2,11 +2,16 @@ package internalhttp
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
 	"github.com/sirupsen/logrus"
 )
 
+// OwnerHeader carries the ID of the user the request is made on behalf of.
+const OwnerHeader = "X-User-ID"
+
 func loggingMiddleware(log *logrus.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -22,3 +27,17 @@ func loggingMiddleware(log *logrus.Logger) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// ownerMiddleware puts the owner from OwnerHeader into the request context, writeErr reports its absence.
+func ownerMiddleware(writeErr func(w http.ResponseWriter, err string, status int)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			owner, err := strconv.ParseInt(r.Header.Get(OwnerHeader), 10, 64)
+			if err != nil {
+				writeErr(w, common.ErrNoOwner.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(common.WithOwner(r.Context(), owner)))
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package internalhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+)
+
+const (
+	eventsPathV2       = "/api/v2/events"
+	problemContentType = "application/problem+json"
+)
+
+// Problem is an RFC 7807 problem details object, the body of the v2 error responses.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// InvalidParams lists the invalid fields of an event.
+	InvalidParams []common.FieldError `json:"invalidParams,omitempty"`
+}
+
+func (h *EventHandler) listEventsV2(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := periodParams(r)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := common.EventFilter{Title: query.Get("title"), Cursor: query.Get("cursor")}
+	if ownerStr := query.Get("owner"); ownerStr != "" {
+		if filter.Owner, err = strconv.ParseInt(ownerStr, 10, 64); err != nil {
+			writeProblem(w, "unparsable owner", http.StatusBadRequest)
+			return
+		}
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		if filter.Limit, err = strconv.Atoi(limitStr); err != nil {
+			writeProblem(w, "unparsable limit", http.StatusBadRequest)
+			return
+		}
+	}
+	events, next, err := h.app.ListEvents(r.Context(), from, to, filter)
+	if err != nil {
+		h.writeAppProblem(w, err, "failed to get list of events")
+		return
+	}
+	writeJSON(w, http.StatusOK, EventsPage{Events: events, NextCursor: next})
+}
+
+func (h *EventHandler) createEventV2(w http.ResponseWriter, r *http.Request) {
+	event, ok := h.decodeEventV2(w, r)
+	if !ok {
+		return
+	}
+	id, err := h.app.CreateEvent(overlapContext(r), event)
+	if err != nil {
+		h.writeAppProblem(w, err, "failed to add event")
+		return
+	}
+	event.ID = id
+	w.Header().Set("Location", eventsPathV2+"/"+strconv.FormatInt(id, 10))
+	writeEvent(w, http.StatusCreated, event)
+}
+
+func (h *EventHandler) getEventV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.idParamV2(w, r)
+	if !ok {
+		return
+	}
+	event, err := h.app.GetEvent(r.Context(), id)
+	if err != nil {
+		h.writeAppProblem(w, err, fmt.Sprintf("failed to get an event %d", id))
+		return
+	}
+	writeEvent(w, http.StatusOK, event)
+}
+
+func (h *EventHandler) replaceEventV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.idParamV2(w, r)
+	if !ok {
+		return
+	}
+	event, ok := h.decodeEventV2(w, r)
+	if !ok {
+		return
+	}
+	ctx, err := ifMatchContext(overlapContext(r), r)
+	if err != nil {
+		writeProblem(w, err.Error(), ifMatchStatus(err))
+		return
+	}
+	if err = h.app.UpdateEvent(ctx, id, event); err != nil {
+		h.writeAppProblem(w, err, fmt.Sprintf("failed to edit an event %d", id))
+		return
+	}
+	event.ID = id
+	writeEvent(w, http.StatusOK, event)
+}
+
+// patchEventV2 applies an RFC 7396 merge patch: the fields present in the body are replaced,
+// null resets a field to its zero value.
+func (h *EventHandler) patchEventV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.idParamV2(w, r)
+	if !ok {
+		return
+	}
+	patch, fields, err := parseMergePatch(r.Body)
+	if err != nil {
+		h.log.Debug("can't parse patch: ", err)
+		writeProblem(w, ErrUnparsableEvent.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, err := ifMatchContext(overlapContext(r), r)
+	if err != nil {
+		writeProblem(w, err.Error(), ifMatchStatus(err))
+		return
+	}
+	event, err := h.app.PatchEvent(ctx, id, patch, fields)
+	if err != nil {
+		h.writeAppProblem(w, err, fmt.Sprintf("failed to patch an event %d", id))
+		return
+	}
+	writeEvent(w, http.StatusOK, event)
+}
+
+// parseMergePatch returns the patch and the names of the fields present in it.
+func parseMergePatch(body io.Reader) (*common.Event, []string, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var present map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &present); err != nil {
+		return nil, nil, err
+	}
+	patch := new(common.Event)
+	if err = json.Unmarshal(raw, patch); err != nil {
+		return nil, nil, err
+	}
+	fields := make([]string, 0, len(present))
+	for field := range present {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return patch, fields, nil
+}
+
+func (h *EventHandler) deleteEventV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.idParamV2(w, r)
+	if !ok {
+		return
+	}
+	ctx, err := ifMatchContext(r.Context(), r)
+	if err != nil {
+		writeProblem(w, err.Error(), ifMatchStatus(err))
+		return
+	}
+	if err = h.app.DeleteEvent(ctx, id); err != nil {
+		h.writeAppProblem(w, err, fmt.Sprintf("failed to remove an event %d", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *EventHandler) inviteAttendeesV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.idParamV2(w, r)
+	if !ok {
+		return
+	}
+	var invitation Invitation
+	if err := json.NewDecoder(r.Body).Decode(&invitation); err != nil {
+		h.log.Debug("can't parse invitation: ", err)
+		writeProblem(w, ErrUnparsableBody.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.app.InviteAttendees(r.Context(), id, invitation.Users); err != nil {
+		h.writeAppProblem(w, err, fmt.Sprintf("failed to invite attendees to an event %d", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondToInvitationV2 sets the answer of the user of the request to the invitation.
+func (h *EventHandler) respondToInvitationV2(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.idParamV2(w, r)
+	if !ok {
+		return
+	}
+	var answer Answer
+	if err := json.NewDecoder(r.Body).Decode(&answer); err != nil {
+		h.log.Debug("can't parse answer: ", err)
+		writeProblem(w, ErrUnparsableBody.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.app.RespondToInvitation(r.Context(), id, answer.Status); err != nil {
+		h.writeAppProblem(w, err, fmt.Sprintf("failed to respond to the invitation to an event %d", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *EventHandler) listInvitationsV2(w http.ResponseWriter, r *http.Request) {
+	from, to, err := periodParams(r)
+	if err != nil {
+		writeProblem(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := h.app.ListInvitations(r.Context(), from, to, common.AttendeeStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		h.writeAppProblem(w, err, "failed to get list of invitations")
+		return
+	}
+	writeJSON(w, http.StatusOK, EventsPage{Events: events})
+}
+
+func (h *EventHandler) idParamV2(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := parseIDParam(r)
+	if err != nil {
+		h.log.Debug(err)
+		writeProblem(w, ErrWrongEventID.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *EventHandler) decodeEventV2(w http.ResponseWriter, r *http.Request) (*common.Event, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		h.log.Debug("empty request body")
+		writeProblem(w, ErrEmptyRequestBody.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	event := new(common.Event)
+	if err := event.ParseEvent(r); err != nil {
+		h.log.Debug("can't parse events: ", err)
+		writeProblem(w, ErrUnparsableEvent.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return event, true
+}
+
+// writeAppProblem is writeAppErr of the v2 API, which reports version conflicts as failed If-Match preconditions.
+func (h *EventHandler) writeAppProblem(w http.ResponseWriter, err error, msg string) {
+	status := errStatus(err)
+	if errors.Is(err, common.ErrVersionConflict) {
+		status = http.StatusPreconditionFailed
+	}
+	if status == http.StatusInternalServerError {
+		h.log.Warnf("%s: %s", msg, err)
+	} else {
+		h.log.Debugf("%s: %s", msg, err)
+	}
+	writeProblemDetails(w, err.Error(), status, fieldErrors(err))
+}
+
+func writeProblem(w http.ResponseWriter, detail string, status int) {
+	writeProblemDetails(w, detail, status, nil)
+}
+
+func writeProblemDetails(w http.ResponseWriter, detail string, status int, invalidParams []common.FieldError) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Problem{
+		Type:          "about:blank",
+		Title:         http.StatusText(status),
+		Status:        status,
+		Detail:        detail,
+		InvalidParams: invalidParams,
+	})
+}
+
+// writeEvent writes the event along with the ETag of its version.
+func writeEvent(w http.ResponseWriter, status int, event *common.Event) {
+	if event.Version != 0 {
+		w.Header().Set("ETag", etag(event.Version))
+	}
+	writeJSON(w, status, event)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
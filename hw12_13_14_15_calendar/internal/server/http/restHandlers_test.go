@@ -0,0 +1,259 @@
+package internalhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+const testEventBody = `{"title":"jopa","startTime":"2021-04-08T22:54:10+03:00","duration":300}`
+
+func TestRESTv2(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+
+	t.Run("create", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := newRequest("POST", "/api/v2/events", bytes.NewReader([]byte(testEventBody)))
+		tr.ServeHTTP(w, r)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Equal(t, "/api/v2/events/1", w.Header().Get("Location"))
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var event common.Event
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&event))
+		require.Equal(t, int64(1), event.ID)
+		require.Equal(t, "jopa", event.Title)
+	})
+	t.Run("get", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/events/2", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, `"1"`, w.Header().Get("ETag"))
+		var event common.Event
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&event))
+		require.Equal(t, int64(2), event.ID)
+	})
+	t.Run("list", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/events?from=1987-10-16&to=1987-10-17", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var page EventsPage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		require.Len(t, page.Events, 10)
+		require.NotEmpty(t, page.NextCursor)
+	})
+	t.Run("replace", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("PUT", "/api/v2/events/2", bytes.NewReader([]byte(testEventBody))))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("patch", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := bytes.NewReader([]byte(`{"title":"patched","notifyTime":null}`))
+		tr.ServeHTTP(w, newRequest("PATCH", "/api/v2/events/2", body))
+		require.Equal(t, http.StatusOK, w.Code)
+		var event common.Event
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&event))
+		require.Equal(t, "patched", event.Title)
+		require.Equal(t, "description", event.Description)
+		require.Equal(t, int32(0), event.NotifyTime)
+	})
+	t.Run("delete", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("DELETE", "/api/v2/events/2", nil))
+		require.Equal(t, http.StatusNoContent, w.Code)
+		require.Empty(t, w.Body.Bytes())
+	})
+	t.Run("invite", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("POST", "/api/v2/events/2/attendees", bytes.NewReader([]byte(`{"users":[3,4]}`))))
+		require.Equal(t, http.StatusNoContent, w.Code)
+	})
+	t.Run("respond", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("PUT", "/api/v2/events/2/rsvp", bytes.NewReader([]byte(`{"status":"tentative"}`))))
+		require.Equal(t, http.StatusNoContent, w.Code)
+	})
+	t.Run("invitations", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/invitations?from=1987-10-16&to=1987-10-17&status=accepted", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var page EventsPage
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		require.Len(t, page.Events, 3)
+	})
+	t.Run("v1 routes are not mixed in", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/deleteEvent/2", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	problems := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		ifMatch string
+		status  int
+		detail  string
+	}{
+		{"no such event", "GET", "/api/v2/events/0", "", "", http.StatusNotFound, common.ErrNoSuchEvent.Error()},
+		{"another owner", "DELETE", "/api/v2/events/3", "", "", http.StatusForbidden, common.ErrForbidden.Error()},
+		{"time is taken", "PUT", "/api/v2/events/4", testEventBody, "", http.StatusConflict, common.ErrDateBusy.Error()},
+		{"wrong id", "GET", "/api/v2/events/goga", "", "", http.StatusBadRequest, ErrWrongEventID.Error()},
+		{"empty body", "POST", "/api/v2/events", "", "", http.StatusBadRequest, ErrEmptyRequestBody.Error()},
+		{"unparsable body", "PUT", "/api/v2/events/2", "{", "", http.StatusBadRequest, ErrUnparsableEvent.Error()},
+		{"internal error", "DELETE", "/api/v2/events/1", "", "", http.StatusInternalServerError, "short buffer"},
+		{"read-only field", "PATCH", "/api/v2/events/2", `{"owner":2}`, "", http.StatusBadRequest, common.ErrUnknownField.Error() + ": owner"},
+		{"patch array", "PATCH", "/api/v2/events/2", `[]`, "", http.StatusBadRequest, ErrUnparsableEvent.Error()},
+		{"stale version", "PUT", "/api/v2/events/5", testEventBody, `"1"`, http.StatusPreconditionFailed, common.ErrVersionConflict.Error()},
+		{"stale deletion", "DELETE", "/api/v2/events/5", "", `"1"`, http.StatusPreconditionFailed, common.ErrVersionConflict.Error()},
+		{"weak If-Match", "PUT", "/api/v2/events/2", testEventBody, `W/"1"`, http.StatusPreconditionFailed, ErrWeakIfMatch.Error()},
+		{"wrong If-Match", "PATCH", "/api/v2/events/2", `{}`, "1", http.StatusBadRequest, ErrWrongIfMatch.Error()},
+		{"invalid attendee", "POST", "/api/v2/events/2/attendees", `{"users":[0]}`, "", http.StatusBadRequest, common.ErrInvalidAttendee.Error()},
+		{"unparsable invitation", "POST", "/api/v2/events/2/attendees", `{"users":"3"}`, "", http.StatusBadRequest, ErrUnparsableBody.Error()},
+		{"invalid answer", "PUT", "/api/v2/events/2/rsvp", `{"status":"maybe"}`, "", http.StatusBadRequest, common.ErrInvalidStatus.Error()},
+		{"not invited", "PUT", "/api/v2/events/3/rsvp", `{"status":"declined"}`, "", http.StatusForbidden, common.ErrNotInvited.Error()},
+		{"empty period", "GET", "/api/v2/invitations?from=1987-10-16&to=1987-10-16", "", "", http.StatusBadRequest, common.ErrInvalidPeriod.Error()},
+	}
+	for _, test := range problems {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := newRequest(test.method, test.target, bytes.NewReader([]byte(test.body)))
+			if test.body == "" {
+				r.Body = http.NoBody
+			}
+			if test.ifMatch != "" {
+				r.Header.Set("If-Match", test.ifMatch)
+			}
+			tr.ServeHTTP(w, r)
+			require.Equal(t, test.status, w.Code)
+			require.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+			var problem Problem
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+			require.Equal(t, Problem{
+				Type:   "about:blank",
+				Title:  http.StatusText(test.status),
+				Status: test.status,
+				Detail: test.detail,
+			}, problem)
+		})
+	}
+	t.Run("invalid event", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("POST", "/api/v2/events", bytes.NewReader([]byte(`{"duration":300}`))))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		var problem Problem
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+		require.Equal(t, []common.FieldError{{Field: "title", Reason: "must not be empty"}}, problem.InvalidParams)
+	})
+	t.Run("no owner", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, httptest.NewRequest("GET", "/api/v2/events/2", nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	})
+}
+
+func TestCalendarV2(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+
+	t.Run("export", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/calendar?from=1987-10-16&to=1987-10-17", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+		require.Equal(t, 10, strings.Count(w.Body.String(), "BEGIN:VEVENT"), "all the pages are exported")
+	})
+	t.Run("export without period", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/calendar?from=1987-10-16", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	})
+	t.Run("import", func(t *testing.T) {
+		calendar := "BEGIN:VCALENDAR\r\n" +
+			"BEGIN:VEVENT\r\nUID:ok\r\nDTSTART:20210301T100000Z\r\nSUMMARY:Meeting\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:untitled\r\nDTSTART:20210301T100000Z\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:no-start\r\nSUMMARY:Broken\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:weekly\r\nRECURRENCE-ID:20210308T100000Z\r\nDTSTART:20210308T120000Z\r\nSUMMARY:Moved\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:weekly\r\nDTSTART:20210301T100000Z\r\nRRULE:FREQ=WEEKLY\r\nSUMMARY:Weekly\r\nEND:VEVENT\r\n" +
+			"BEGIN:VEVENT\r\nUID:orphan\r\nRECURRENCE-ID:20210308T100000Z\r\nDTSTART:20210308T120000Z\r\nSUMMARY:Moved\r\nEND:VEVENT\r\n" +
+			"END:VCALENDAR\r\n"
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("POST", "/api/v2/calendar", strings.NewReader(calendar)))
+		require.Equal(t, http.StatusOK, w.Code)
+		var result ImportResult
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Equal(t, 3, result.Imported)
+		require.Equal(t, 3, result.Failed)
+		require.Equal(t, ImportedItem{UID: "ok", ID: 1}, result.Items[0])
+		require.Equal(t, []common.FieldError{{Field: "title", Reason: "must not be empty"}}, result.Items[1].Details)
+		require.Contains(t, result.Items[2].Error, "DTSTART is missing")
+		require.Equal(t, ImportedItem{UID: "weekly", ID: 1}, result.Items[3], "the override goes after its series")
+		require.Equal(t, ImportedItem{UID: "weekly", ID: 1}, result.Items[4])
+		require.Contains(t, result.Items[5].Error, "the recurring event of the modified occurrence is not imported")
+	})
+	t.Run("import garbage", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("POST", "/api/v2/calendar", strings.NewReader("BEGIN:VEVENT")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestFreeBusyV2(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+
+	t.Run("free/busy", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/freebusy?owners=1,2&from=2021-03-01&to=2021-03-02", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var result FreeBusyList
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Len(t, result.Owners, 2)
+		require.Equal(t, int64(2), result.Owners[1].Owner)
+	})
+	t.Run("free/busy with bad owners", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/freebusy?owners=1,x&from=2021-03-01&to=2021-03-02", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	})
+	t.Run("slots", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/slots?owners=1,2&duration=30m&from=2021-03-01T10:00:00Z&to=2021-03-02"+
+			"&workStart=09:00&workEnd=18:00&days=MO,TU&tz=Europe/Moscow&step=15m&limit=5", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var result SlotList
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Equal(t, []common.Interval{{
+			Start: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+			End:   time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC),
+		}}, result.Slots)
+	})
+	for name, query := range map[string]string{
+		"no duration":  "",
+		"bad clock":    "&duration=1h&workStart=9am",
+		"bad days":     "&duration=1h&days=XX",
+		"unknown zone": "&duration=1h&tz=Mars/Olympus",
+		"bad step":     "&duration=1h&step=often",
+	} {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", "/api/v2/slots?from=2021-03-01&to=2021-03-02"+query, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+}
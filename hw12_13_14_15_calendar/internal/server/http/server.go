@@ -46,12 +46,40 @@ func NewRouter(handler *EventHandler, log *logrus.Logger, version interface{}) *
 		r.Group(func(r chi.Router) {
 			r.Use(loggingMiddleware(log))
 			r.Route("/v1", func(r chi.Router) {
+				r.Use(ownerMiddleware(writeErrResponse))
 				r.Get("/listEventsByDay", handler.listEventsByDayHandler)
 				r.Get("/listEventsByWeek", handler.listEventsByWeekHandler)
 				r.Get("/listEventsByMonth", handler.listEventsByMonthHandler)
+				r.Get("/listEvents", handler.listEventsHandler)
+				r.Get("/events/{id}", handler.getEventHandler)
 				r.Get("/deleteEvent/{id}", handler.deleteEventHandler)
 				r.Post("/addEvent", handler.addEventHandler)
 				r.Post("/editEvent/{id}", handler.editEventHandler)
+				r.Post("/inviteAttendees/{id}", handler.inviteAttendeesHandler)
+				r.Post("/respondToInvitation/{id}", handler.respondToInvitationHandler)
+				r.Get("/listInvitations", handler.listInvitationsHandler)
+				r.Get("/exportEvents", handler.exportEventsHandler)
+				r.Post("/importEvents", handler.importEventsHandler)
+				r.Get("/freeBusy", handler.freeBusyHandler)
+				r.Get("/findSlots", handler.findSlotsHandler)
+			})
+			r.Route("/v2", func(r chi.Router) {
+				r.Use(ownerMiddleware(writeProblem))
+				r.Route("/events", func(r chi.Router) {
+					r.Get("/", handler.listEventsV2)
+					r.Post("/", handler.createEventV2)
+					r.Get("/{id}", handler.getEventV2)
+					r.Put("/{id}", handler.replaceEventV2)
+					r.Patch("/{id}", handler.patchEventV2)
+					r.Delete("/{id}", handler.deleteEventV2)
+					r.Post("/{id}/attendees", handler.inviteAttendeesV2)
+					r.Put("/{id}/rsvp", handler.respondToInvitationV2)
+				})
+				r.Get("/invitations", handler.listInvitationsV2)
+				r.Get("/calendar", handler.exportCalendarV2)
+				r.Post("/calendar", handler.importCalendarV2)
+				r.Get("/freebusy", handler.freeBusyV2)
+				r.Get("/slots", handler.findSlotsV2)
 			})
 		})
 	})
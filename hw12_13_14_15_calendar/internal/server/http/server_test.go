@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -13,6 +14,30 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func newRequest(method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set(OwnerHeader, "1")
+	return r
+}
+
+func TestOwnerMiddleware(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+	var result JSONResponse
+
+	for _, owner := range []string{"", "goga"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/listEventsByDay?date=1987-10-16", nil)
+		r.Header.Set(OwnerHeader, owner)
+		tr.ServeHTTP(w, r)
+		require.Equal(t, w.Code, http.StatusUnauthorized)
+		err := json.NewDecoder(w.Body).Decode(&result)
+		require.NoError(t, err)
+		require.Equal(t, *result.Error, common.ErrNoOwner.Error())
+	}
+}
+
 func TestListHandler(t *testing.T) {
 	log := logrus.New()
 	th := NewEventHandler(common.TestApp{}, log)
@@ -21,22 +46,23 @@ func TestListHandler(t *testing.T) {
 
 	t.Run("listEntriesByDay", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/listEventsByDay?date=1987-10-16", nil)
+		r := newRequest("GET", "/api/v1/listEventsByDay?date=1987-10-16", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusOK)
+		require.Equal(t, w.Header().Get("Content-Type"), "application/json")
 		err := json.NewDecoder(w.Body).Decode(&result)
 		require.NoError(t, err)
 		require.Equal(t, len(result.Data.([]interface{})), 5)
 	})
 	t.Run("listEntriesByDay err", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/listEventsByDay?date=1987-15-12", nil)
+		r := newRequest("GET", "/api/v1/listEventsByDay?date=1987-15-12", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusBadRequest)
 	})
 	t.Run("listEntriesByWeek", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/listEventsByWeek?date=1987-10-16", nil)
+		r := newRequest("GET", "/api/v1/listEventsByWeek?date=1987-10-16", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusOK)
 		err := json.NewDecoder(w.Body).Decode(&result)
@@ -45,13 +71,13 @@ func TestListHandler(t *testing.T) {
 	})
 	t.Run("listEntriesByWeek err", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/listEventsByWeek?date=1987-15-12", nil)
+		r := newRequest("GET", "/api/v1/listEventsByWeek?date=1987-15-12", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusBadRequest)
 	})
 	t.Run("listEntriesByMonth", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/listEventsByMonth?date=1987-10-16", nil)
+		r := newRequest("GET", "/api/v1/listEventsByMonth?date=1987-10-16", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusOK)
 		err := json.NewDecoder(w.Body).Decode(&result)
@@ -60,10 +86,41 @@ func TestListHandler(t *testing.T) {
 	})
 	t.Run("listEntriesByMonth err", func(t *testing.T) {
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/listEventsByMonth?date=1987-15-12", nil)
+		r := newRequest("GET", "/api/v1/listEventsByMonth?date=1987-15-12", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusBadRequest)
 	})
+	t.Run("listEvents", func(t *testing.T) {
+		var page struct {
+			Data EventsPage `json:"data"`
+		}
+		w := httptest.NewRecorder()
+		r := newRequest("GET", "/api/v1/listEvents?from=1987-10-16&to=1987-10-17T12:00:00Z&title=go&limit=10", nil)
+		tr.ServeHTTP(w, r)
+		require.Equal(t, w.Code, http.StatusOK)
+		err := json.NewDecoder(w.Body).Decode(&page)
+		require.NoError(t, err)
+		require.Len(t, page.Data.Events, 10)
+		require.NotEmpty(t, page.Data.NextCursor)
+
+		w = httptest.NewRecorder()
+		r = newRequest("GET", "/api/v1/listEvents?from=1987-10-16&to=1987-10-17&cursor="+page.Data.NextCursor, nil)
+		tr.ServeHTTP(w, r)
+		require.Equal(t, w.Code, http.StatusOK)
+		page.Data = EventsPage{}
+		err = json.NewDecoder(w.Body).Decode(&page)
+		require.NoError(t, err)
+		require.Empty(t, page.Data.Events)
+		require.Empty(t, page.Data.NextCursor)
+	})
+	t.Run("listEvents err", func(t *testing.T) {
+		for _, query := range []string{"from=1987-15-12&to=1987-10-17", "from=1987-10-16&to=1987-10-17&cursor=@", "from=1987-10-16"} {
+			w := httptest.NewRecorder()
+			r := newRequest("GET", "/api/v1/listEvents?"+query, nil)
+			tr.ServeHTTP(w, r)
+			require.Equal(t, w.Code, http.StatusBadRequest, query)
+		}
+	})
 }
 
 func TestDeleteHandler(t *testing.T) {
@@ -80,12 +137,50 @@ func TestDeleteHandler(t *testing.T) {
 	}{
 		{"no such entry", 0, http.StatusNotFound, "no such event"},
 		{"internal error", 1, http.StatusInternalServerError, "short buffer"},
+		{"another owner", 3, http.StatusForbidden, "event belongs to another owner"},
 	}
 	for _, test := range testsDelete {
 		test := test
 		t.Run("deleteEntry", func(t *testing.T) {
 			w := httptest.NewRecorder()
-			r := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/deleteEvent/%d", test.id), nil)
+			r := newRequest("GET", fmt.Sprintf("/api/v1/deleteEvent/%d", test.id), nil)
+			tr.ServeHTTP(w, r)
+			require.Equal(t, w.Code, test.errCode)
+			err := json.NewDecoder(w.Body).Decode(&result)
+			require.NoError(t, err)
+			require.Equal(t, *result.Error, test.err)
+		})
+	}
+	t.Run("ok", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := newRequest("GET", "/api/v1/deleteEvent/2", nil)
+		tr.ServeHTTP(w, r)
+		require.Equal(t, w.Code, http.StatusOK)
+	})
+}
+
+func TestGetHandler(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+	var result JSONResponse
+
+	testsGet := []struct {
+		name    string
+		id      string
+		errCode int
+		err     string
+	}{
+		{"no such entry", "0", http.StatusNotFound, "no such event"},
+		{"internal error", "1", http.StatusInternalServerError, "short buffer"},
+		{"another owner", "3", http.StatusForbidden, "event belongs to another owner"},
+		{"wrong id", "goga", http.StatusBadRequest, ErrWrongEventID.Error()},
+	}
+	for _, test := range testsGet {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := newRequest("GET", "/api/v1/events/"+test.id, nil)
 			tr.ServeHTTP(w, r)
 			require.Equal(t, w.Code, test.errCode)
 			err := json.NewDecoder(w.Body).Decode(&result)
@@ -94,10 +189,17 @@ func TestDeleteHandler(t *testing.T) {
 		})
 	}
 	t.Run("ok", func(t *testing.T) {
+		var event struct {
+			Data common.Event `json:"data"`
+		}
 		w := httptest.NewRecorder()
-		r := httptest.NewRequest("GET", "/api/v1/deleteEvent/2", nil)
+		r := newRequest("GET", "/api/v1/events/2", nil)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusOK)
+		err := json.NewDecoder(w.Body).Decode(&event)
+		require.NoError(t, err)
+		require.Equal(t, event.Data.ID, int64(2))
+		require.Equal(t, event.Data.Title, "goga")
 	})
 }
 
@@ -115,7 +217,7 @@ func TestCreateEvent(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		body := bytes.NewReader([]byte(`{"id":0, "title":"jopa","startTime":"2021-04-08T22:54:10+03:00","duration":300}`))
-		r := httptest.NewRequest("POST", "/api/v1/addEvent", body)
+		r := newRequest("POST", "/api/v1/addEvent", body)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusOK)
 		err := json.NewDecoder(w.Body).Decode(&result)
@@ -125,10 +227,21 @@ func TestCreateEvent(t *testing.T) {
 	t.Run("error", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		body := bytes.NewReader([]byte(`{"id":1, "title":"opiat jopa"}`))
-		r := httptest.NewRequest("POST", "/api/v1/addEvent", body)
+		r := newRequest("POST", "/api/v1/addEvent", body)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusInternalServerError)
 	})
+	t.Run("invalid", func(t *testing.T) {
+		var response JSONResponse
+		w := httptest.NewRecorder()
+		body := bytes.NewReader([]byte(`{"id":0, "title":""}`))
+		r := newRequest("POST", "/api/v1/addEvent", body)
+		tr.ServeHTTP(w, r)
+		require.Equal(t, w.Code, http.StatusBadRequest)
+		err := json.NewDecoder(w.Body).Decode(&response)
+		require.NoError(t, err)
+		require.Equal(t, response.Details, []common.FieldError{{Field: "title", Reason: "must not be empty"}})
+	})
 }
 
 func TestUpdateEvent(t *testing.T) {
@@ -150,13 +263,16 @@ func TestUpdateEvent(t *testing.T) {
 	}{
 		{"no such entry", 0, http.StatusNotFound, "no such event"},
 		{"internal error", 1, http.StatusInternalServerError, "short buffer"},
+		{"another owner", 3, http.StatusForbidden, "event belongs to another owner"},
+		{"time is taken", 4, http.StatusConflict, "time is already taken by another event"},
+		{"stale version", 5, http.StatusConflict, "event version has changed"},
 	}
 	for _, test := range testsRead {
 		test := test
 		t.Run("readEntry", func(t *testing.T) {
 			w := httptest.NewRecorder()
 			body := bytes.NewReader([]byte(`{"id":0, "title":"jopa","startTime":"2021-04-08T22:54:10+03:00","duration":300}`))
-			r := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/editEvent/%d", test.id), body)
+			r := newRequest("POST", fmt.Sprintf("/api/v1/editEvent/%d", test.id), body)
 			tr.ServeHTTP(w, r)
 			require.Equal(t, w.Code, test.errCode)
 			err := json.NewDecoder(w.Body).Decode(&result)
@@ -168,7 +284,7 @@ func TestUpdateEvent(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		body := bytes.NewReader([]byte(`{"id":0, "title":"jopa","startTime":"2021-04-08T22:54:10+03:00","duration":300}`))
-		r := httptest.NewRequest("POST", "/api/v1/editEvent/2", body)
+		r := newRequest("POST", "/api/v1/editEvent/2", body)
 		tr.ServeHTTP(w, r)
 		require.Equal(t, w.Code, http.StatusOK)
 		err := json.NewDecoder(w.Body).Decode(&result)
@@ -176,3 +292,104 @@ func TestUpdateEvent(t *testing.T) {
 		require.Equal(t, result.Data.ID, 2)
 	})
 }
+
+func TestInvitations(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"invite", "POST", "/api/v1/inviteAttendees/2", `{"users":[3,4]}`, http.StatusOK},
+		{"invite another owner's event", "POST", "/api/v1/inviteAttendees/3", `{"users":[3]}`, http.StatusForbidden},
+		{"invite nobody", "POST", "/api/v1/inviteAttendees/2", `{"users":[-3]}`, http.StatusBadRequest},
+		{"respond", "POST", "/api/v1/respondToInvitation/2", `{"status":"accepted"}`, http.StatusOK},
+		{"respond pending", "POST", "/api/v1/respondToInvitation/2", `{"status":"pending"}`, http.StatusBadRequest},
+		{"respond uninvited", "POST", "/api/v1/respondToInvitation/3", `{"status":"declined"}`, http.StatusForbidden},
+		{"respond to nothing", "POST", "/api/v1/respondToInvitation/0", `{"status":"declined"}`, http.StatusNotFound},
+		{"list", "GET", "/api/v1/listInvitations?from=1987-10-16&to=1987-10-17", "", http.StatusOK},
+		{"list without period", "GET", "/api/v1/listInvitations?from=1987-10-16", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tr.ServeHTTP(w, newRequest(test.method, test.target, bytes.NewReader([]byte(test.body))))
+			require.Equal(t, test.status, w.Code)
+			var result JSONResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+			require.Equal(t, test.status, result.Code)
+		})
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/exportEvents?from=1987-10-16&to=1987-10-17", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/calendar; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w = httptest.NewRecorder()
+	calendar := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20210301T100000Z\r\nSUMMARY:Meeting\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	tr.ServeHTTP(w, newRequest("POST", "/api/v1/importEvents", bytes.NewReader([]byte(calendar))))
+	require.Equal(t, http.StatusOK, w.Code)
+	var result JSONResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	require.Equal(t, float64(1), result.Data.(map[string]interface{})["imported"])
+}
+
+func TestFreeBusy(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/freeBusy?owners=1&from=2021-03-01&to=2021-03-02", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var result JSONResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	require.Len(t, result.Data, 1)
+
+	w = httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/freeBusy?owners=1&from=2021-03-02&to=2021-03-01", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/findSlots?duration=1h&from=2021-03-01&to=2021-03-02", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/findSlots?duration=-1h&from=2021-03-01&to=2021-03-02", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListEventsTimeZone(t *testing.T) {
+	log := logrus.New()
+	th := NewEventHandler(common.TestApp{}, log)
+	tr := NewRouter(th, log, "test")
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/listEventsByDay?date=2021-03-01&tz=Europe/Moscow", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"startTime":"2021-03-01T00:00:00+03:00"`, "the day starts at the midnight in Moscow")
+
+	w = httptest.NewRecorder()
+	tr.ServeHTTP(w, newRequest("GET", "/api/v1/listEvents?from=2021-03-01&to=2021-03-02&tz=Asia/Tokyo", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"startTime":"2021-03-01T00:00:00+09:00"`)
+
+	for _, url := range []string{"/api/v1/listEventsByWeek?date=2021-03-01&tz=Mars/Olympus", "/api/v2/events?from=2021-03-01&to=2021-03-02&tz=Mars"} {
+		w = httptest.NewRecorder()
+		tr.ServeHTTP(w, newRequest("GET", url, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, url)
+		require.Contains(t, w.Body.String(), ErrUnknownTimeZone.Error(), url)
+	}
+}
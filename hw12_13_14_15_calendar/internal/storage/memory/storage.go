@@ -10,93 +10,403 @@ import (
 )
 
 type Storage struct {
-	mu      sync.RWMutex
-	events  map[int64]common.Event
-	counter int64
-	log     *logrus.Logger
+	mu     sync.RWMutex
+	txMu   sync.Mutex
+	events map[int64]common.Event
+	// attendees are the invitations to the events, they are replaced rather than modified like the outbox.
+	attendees []common.Attendee
+	outbox    []common.Notification
+	counter   int64
+	log       *logrus.Logger
 }
 
+type txKey struct{}
+
 func New(log *logrus.Logger) *Storage {
 	events := make(map[int64]common.Event)
 	return &Storage{events: events, log: log}
 }
 
+// WithTx runs fn with transactions serialized and restores the events, the attendees and the outbox if fn fails.
+// Writes made outside of transactions while a failing one is running are lost as well.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return fn(ctx)
+	}
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	s.mu.RLock()
+	snapshot := make(map[int64]common.Event, len(s.events))
+	for id, event := range s.events {
+		snapshot[id] = event
+	}
+	attendees, outbox := s.attendees, s.outbox
+	s.mu.RUnlock()
+	if err := fn(context.WithValue(ctx, txKey{}, struct{}{})); err != nil {
+		s.mu.Lock()
+		s.events = snapshot
+		s.attendees = attendees
+		s.outbox = outbox
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
 func (s *Storage) CreateEvent(_ context.Context, event *common.Event) (int64, error) {
 	event.Created = time.Now()
 	event.Updated = time.Now()
+	event.Version = 1
 	var id int64
 	s.mu.Lock()
 	{
+		s.counter++
 		id = s.counter
 		event.ID = id
-		s.events[s.counter] = *event
-		s.counter++
+		s.events[id] = withoutAttendees(event)
 	}
 	s.mu.Unlock()
 	s.log.Trace("added event ", id)
 	return id, nil
 }
 
-func (s *Storage) UpdateEvent(_ context.Context, id int64, event *common.Event) error {
+// UpdateEvent replaces the event unless event.Version is set and differs from the stored one.
+func (s *Storage) UpdateEvent(_ context.Context, owner, id int64, event *common.Event) error {
 	event.ID = id
 	s.mu.Lock()
-	{
-		event.Created = s.events[id].Created
-		event.Updated = time.Now()
-		if _, ok := s.events[id]; !ok {
-			return common.ErrNoSuchEvent
-		}
-		s.events[id] = *event
+	defer s.mu.Unlock()
+	stored, ok := s.events[id]
+	if !ok {
+		return common.ErrNoSuchEvent
 	}
-	s.mu.Unlock()
+	if stored.Owner != owner {
+		return common.ErrForbidden
+	}
+	if event.Version != 0 && event.Version != stored.Version {
+		return common.ErrVersionConflict
+	}
+	event.Created = stored.Created
+	event.NotifiedAt = stored.NotifiedAt
+	event.Updated = time.Now()
+	event.Version = stored.Version + 1
+	s.events[id] = withoutAttendees(event)
 	s.log.Trace("modified event ", id)
 	return nil
 }
 
-func (s *Storage) DeleteEvent(_ context.Context, id int64) error {
-	if _, ok := s.events[id]; !ok {
+// DeleteEvent removes the event along with its overrides unless version is set and differs from the stored one.
+func (s *Storage) DeleteEvent(_ context.Context, owner, id, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.events[id]
+	if !ok {
 		return common.ErrNoSuchEvent
 	}
-	s.mu.Lock()
-	{
-		delete(s.events, id)
+	if stored.Owner != owner {
+		return common.ErrForbidden
 	}
-	s.mu.Unlock()
+	if version != 0 && version != stored.Version {
+		return common.ErrVersionConflict
+	}
+	removed := map[int64]bool{id: true}
+	for overrideID, event := range s.events {
+		if event.ParentID == id {
+			removed[overrideID] = true
+		}
+	}
+	for removedID := range removed {
+		delete(s.events, removedID)
+	}
+	s.dropAttendees(removed)
 	s.log.Trace("removed event ", id)
 	return nil
 }
 
-func (s *Storage) ListEventsByDay(_ context.Context, date time.Time) ([]common.Event, error) {
-	return s.listEvents(date, date.AddDate(0, 0, 1))
+// withoutAttendees returns the copy of the event kept in the storage, the attendees are kept apart.
+func withoutAttendees(event *common.Event) common.Event {
+	result := *event
+	result.Attendees = nil
+	return result
 }
 
-func (s *Storage) ListEventsByWeek(_ context.Context, date time.Time) ([]common.Event, error) {
-	return s.listEvents(date, date.AddDate(0, 0, 7))
+func (s *Storage) GetEvent(_ context.Context, owner, id int64) (*common.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.events[id]
+	if !ok {
+		return nil, common.ErrNoSuchEvent
+	}
+	if stored.Owner != owner {
+		return nil, common.ErrForbidden
+	}
+	events := []common.Event{stored}
+	s.fillAttendees(events)
+	return &events[0], nil
 }
 
-func (s *Storage) ListEventsByMonth(_ context.Context, date time.Time) ([]common.Event, error) {
-	return s.listEvents(date, date.AddDate(0, 1, 0))
+func (s *Storage) ListEventsByDay(_ context.Context, owner int64, date time.Time) ([]common.Event, error) {
+	return s.listEvents(owner, date, date.AddDate(0, 0, 1))
 }
 
-func (s *Storage) listEvents(fromDate, toDate time.Time) ([]common.Event, error) {
-	events := make([]common.Event, 0)
+func (s *Storage) ListEventsByWeek(_ context.Context, owner int64, date time.Time) ([]common.Event, error) {
+	return s.listEvents(owner, date, date.AddDate(0, 0, 7))
+}
+
+func (s *Storage) ListEventsByMonth(_ context.Context, owner int64, date time.Time) ([]common.Event, error) {
+	return s.listEvents(owner, date, date.AddDate(0, 1, 0))
+}
+
+func (s *Storage) listEvents(owner int64, fromDate, toDate time.Time) ([]common.Event, error) {
+	return common.ExpandEvents(s.candidates(owner, fromDate, toDate), fromDate, toDate)
+}
+
+// candidates returns single events of the owner overlapping [fromDate, toDate) along with
+// the recurring series and the overrides which may produce or replace an occurrence within it.
+func (s *Storage) candidates(owner int64, fromDate, toDate time.Time) []common.Event {
+	candidates := make([]common.Event, 0)
 	s.mu.RLock()
+	defer s.mu.RUnlock()
 	for _, event := range s.events {
-		if (event.StartTime.After(fromDate) || event.StartTime.Equal(fromDate)) && event.StartTime.Before(toDate) {
+		switch {
+		case event.Owner != owner:
+		case event.RRule != "":
+			if event.StartTime.Before(toDate) {
+				candidates = append(candidates, event)
+			}
+		case event.ParentID != 0:
+			candidates = append(candidates, event)
+		case event.Overlaps(fromDate, toDate):
+			candidates = append(candidates, event)
+		}
+	}
+	s.fillAttendees(candidates)
+	return candidates
+}
+
+func (s *Storage) ListEvents(_ context.Context, from, to time.Time, filter common.EventFilter) ([]common.Event, string, error) {
+	occurrences, err := common.ExpandEvents(s.candidates(filter.Owner, from, to), from, to)
+	if err != nil {
+		return nil, "", err
+	}
+	events := make([]common.Event, 0, len(occurrences))
+	for _, event := range occurrences {
+		if filter.Matches(&event) {
 			events = append(events, event)
 		}
 	}
-	s.mu.RUnlock()
-	return events, nil
+	return common.PageEvents(events, filter.Cursor, filter.Limit)
+}
+
+func (s *Storage) FindOverlapping(_ context.Context, owner int64, from, to time.Time) ([]common.Event, error) {
+	return s.listEvents(owner, from, to)
 }
 
-func (s *Storage) ListEventsToNotify(_ context.Context) (events []common.Event, err error) {
+func (s *Storage) ListEventsToNotify(_ context.Context, now time.Time) ([]common.Notification, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	candidates := make([]common.Event, 0, len(s.events))
 	for _, event := range s.events {
-		if time.Until(event.StartTime).Seconds() < float64(event.NotifyTime) && event.NotifyTime != 0 {
-			events = append(events, event)
+		candidates = append(candidates, event)
+	}
+	s.fillAttendees(candidates)
+	return common.EventsToNotify(candidates, now)
+}
+
+// AddAttendees invites the users to the event of the owner, the ones invited before keep their status.
+func (s *Storage) AddAttendees(_ context.Context, owner, id int64, users []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.events[id]
+	if !ok {
+		return common.ErrNoSuchEvent
+	}
+	if event.Owner != owner {
+		return common.ErrForbidden
+	}
+	invited := make(map[int64]bool)
+	for _, attendee := range s.attendees {
+		if attendee.EventID == id {
+			invited[attendee.User] = true
+		}
+	}
+	attendees := append([]common.Attendee(nil), s.attendees...)
+	for _, user := range users {
+		if !invited[user] {
+			invited[user] = true
+			attendees = append(attendees, common.Attendee{EventID: id, User: user, Status: common.StatusPending, Updated: time.Now()})
+		}
+	}
+	s.attendees = attendees
+	return nil
+}
+
+// SetAttendeeStatus sets the status of the user's invitation to the event.
+func (s *Storage) SetAttendeeStatus(_ context.Context, id, user int64, status common.AttendeeStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.events[id]; !ok {
+		return common.ErrNoSuchEvent
+	}
+	for i, attendee := range s.attendees {
+		if attendee.EventID == id && attendee.User == user {
+			attendees := append([]common.Attendee(nil), s.attendees...)
+			attendees[i].Status = status
+			attendees[i].Updated = time.Now()
+			s.attendees = attendees
+			return nil
+		}
+	}
+	return common.ErrNotInvited
+}
+
+func (s *Storage) ListInvitedEvents(_ context.Context, user int64, from, to time.Time) ([]common.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	invited := make(map[int64]bool)
+	for _, attendee := range s.attendees {
+		if attendee.User == user {
+			invited[attendee.EventID] = true
+		}
+	}
+	candidates := make([]common.Event, 0, len(invited))
+	for id, event := range s.events {
+		switch {
+		case invited[event.ParentID]:
+			candidates = append(candidates, event)
+		case !invited[id]:
+		case event.RRule != "":
+			if event.StartTime.Before(to) {
+				candidates = append(candidates, event)
+			}
+		case event.Overlaps(from, to):
+			candidates = append(candidates, event)
+		}
+	}
+	s.fillAttendees(candidates)
+	return common.ExpandEvents(candidates, from, to)
+}
+
+// fillAttendees sets the attendees of the events, overrides get the ones of their series.
+// It must be called with the lock held.
+func (s *Storage) fillAttendees(events []common.Event) {
+	byEvent := make(map[int64][]common.Attendee)
+	for _, attendee := range s.attendees {
+		byEvent[attendee.EventID] = append(byEvent[attendee.EventID], attendee)
+	}
+	for i := range events {
+		events[i].Attendees = byEvent[events[i].InvitationID()]
+	}
+}
+
+// dropAttendees removes the invitations to the removed events, it must be called with the lock held.
+func (s *Storage) dropAttendees(removed map[int64]bool) {
+	attendees := make([]common.Attendee, 0, len(s.attendees))
+	for _, attendee := range s.attendees {
+		if !removed[attendee.EventID] {
+			attendees = append(attendees, attendee)
+		}
+	}
+	s.attendees = attendees
+}
+
+func (s *Storage) MarkNotified(_ context.Context, ids []int64, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		event, ok := s.events[id]
+		if !ok {
+			continue
+		}
+		notifiedAt := at
+		event.NotifiedAt = &notifiedAt
+		s.events[id] = event
+	}
+	return nil
+}
+
+func (s *Storage) EnqueueNotifications(_ context.Context, notifications []common.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make(map[string]bool, len(s.outbox))
+	for _, notification := range s.outbox {
+		keys[notification.Key] = true
+	}
+	outbox := append([]common.Notification(nil), s.outbox...)
+	for _, notification := range notifications {
+		if !keys[notification.Key] {
+			keys[notification.Key] = true
+			outbox = append(outbox, notification)
+		}
+	}
+	s.outbox = outbox
+	return nil
+}
+
+func (s *Storage) ListOutbox(_ context.Context, limit int) ([]common.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if limit > len(s.outbox) {
+		limit = len(s.outbox)
+	}
+	return append([]common.Notification(nil), s.outbox[:limit]...), nil
+}
+
+func (s *Storage) DeleteFromOutbox(_ context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		deleted[key] = true
+	}
+	outbox := make([]common.Notification, 0, len(s.outbox))
+	for _, notification := range s.outbox {
+		if !deleted[notification.Key] {
+			outbox = append(outbox, notification)
+		}
+	}
+	s.outbox = outbox
+	return nil
+}
+
+func (s *Storage) DeleteEventsBefore(_ context.Context, t time.Time, limit int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ended := s.endedBefore(t, limit)
+	var n int64
+	for id, event := range s.events {
+		if ended[id] || ended[event.ParentID] {
+			delete(s.events, id)
+			n++
+			s.log.Trace("removed event ", id)
+		}
+	}
+	s.dropAttendees(ended)
+	return n, nil
+}
+
+func (s *Storage) CountEventsBefore(_ context.Context, t time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ended := s.endedBefore(t, 0)
+	var n int64
+	for id, event := range s.events {
+		if ended[id] || ended[event.ParentID] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// endedBefore returns up to limit IDs of the single events and series which ended before t.
+func (s *Storage) endedBefore(t time.Time, limit int) map[int64]bool {
+	ended := make(map[int64]bool)
+	for id, event := range s.events {
+		if limit > 0 && len(ended) == limit {
+			break
+		}
+		if event.ParentID == 0 && event.EndedBefore(t) {
+			ended[id] = true
 		}
 	}
-	return events, nil
+	return ended
 }
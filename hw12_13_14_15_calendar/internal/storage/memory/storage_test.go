@@ -2,6 +2,8 @@ package memorystorage
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -30,7 +32,7 @@ func TestStorage(t *testing.T) {
 			NotifyTime:  100,
 		})
 		require.NoError(t, err)
-		require.Equal(t, id, int64(0))
+		require.Equal(t, id, int64(1))
 
 		id, err = events.CreateEvent(ctx, &common.Event{
 			Title:       "Second",
@@ -41,12 +43,29 @@ func TestStorage(t *testing.T) {
 			NotifyTime:  100,
 		})
 		require.NoError(t, err)
-		require.Equal(t, id, int64(1))
+		require.Equal(t, id, int64(2))
 
-		test, _ := events.ListEventsByDay(ctx, tt)
+		test, _ := events.ListEventsByDay(ctx, 11, tt)
 		require.Len(t, test, 2)
+		test, _ = events.ListEventsByDay(ctx, 12, tt)
+		require.Len(t, test, 0)
+
+		event, err := events.GetEvent(ctx, 11, 2)
+		require.NoError(t, err)
+		require.Equal(t, event.Title, "Second")
+		_, err = events.GetEvent(ctx, 12, 2)
+		require.ErrorIs(t, err, common.ErrForbidden)
+		_, err = events.GetEvent(ctx, 11, 100)
+		require.ErrorIs(t, err, common.ErrNoSuchEvent)
 
-		err = events.UpdateEvent(ctx, 0, &common.Event{
+		err = events.UpdateEvent(ctx, 12, 1, &common.Event{Title: "Stolen"})
+		require.ErrorIs(t, err, common.ErrForbidden)
+		err = events.DeleteEvent(ctx, 12, 2, 0)
+		require.ErrorIs(t, err, common.ErrForbidden)
+		err = events.DeleteEvent(ctx, 11, 100, 0)
+		require.ErrorIs(t, err, common.ErrNoSuchEvent)
+
+		err = events.UpdateEvent(ctx, 11, 1, &common.Event{
 			Title:       "First edited",
 			StartTime:   tt,
 			Duration:    5,
@@ -56,11 +75,11 @@ func TestStorage(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		err = events.DeleteEvent(ctx, 1)
+		err = events.DeleteEvent(ctx, 11, 2, 0)
 		require.NoError(t, err)
 
 		require.Len(t, events.events, 1)
-		elems, err := events.ListEventsByDay(ctx, tt)
+		elems, err := events.ListEventsByDay(ctx, 15, tt)
 		require.NoError(t, err)
 		require.Equal(t, elems[0].Title, "First edited")
 		require.Equal(t, elems[0].StartTime, tt)
@@ -72,25 +91,357 @@ func TestStorage(t *testing.T) {
 
 		id, err = events.CreateEvent(ctx, &common.Event{})
 		require.NoError(t, err)
-		require.Equal(t, id, int64(2))
+		require.Equal(t, id, int64(3))
 
-		_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 6)})
+		_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 6), Owner: 15})
 		require.NoError(t, err)
-		_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 20)})
+		_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 20), Owner: 15})
 		require.NoError(t, err)
-		_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 60)})
+		_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 60), Owner: 15})
 		require.NoError(t, err)
 
-		test, err = events.ListEventsByDay(ctx, tt)
+		test, err = events.ListEventsByDay(ctx, 15, tt)
 		require.NoError(t, err)
 		require.Len(t, test, 1)
-		test, err = events.ListEventsByWeek(ctx, tt)
+		test, err = events.ListEventsByWeek(ctx, 15, tt)
 		require.NoError(t, err)
 		require.Len(t, test, 2)
-		test, err = events.ListEventsByMonth(ctx, tt)
+		test, err = events.ListEventsByMonth(ctx, 15, tt)
 		require.NoError(t, err)
 		require.Len(t, test, 3)
 	})
+	t.Run("recurring", func(t *testing.T) {
+		log := logrus.New()
+		events := New(log)
+		tt, err := time.Parse(common.PgTimestampFmt, "2021-03-01 10:00:00")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		id, err := events.CreateEvent(ctx, &common.Event{
+			Title:     "Standup",
+			StartTime: tt,
+			Duration:  15 * 60,
+			Owner:     11,
+			RRule:     "FREQ=WEEKLY;BYDAY=MO,WE,FR",
+			ExDates:   common.ExDates{tt.AddDate(0, 0, 2)},
+		})
+		require.NoError(t, err)
+		moved := tt.AddDate(0, 0, 4)
+		_, err = events.CreateEvent(ctx, &common.Event{
+			Title:        "Standup moved",
+			StartTime:    moved.Add(2 * time.Hour),
+			Owner:        11,
+			ParentID:     id,
+			RecurrenceID: &moved,
+		})
+		require.NoError(t, err)
+
+		test, err := events.ListEventsByDay(ctx, 11, tt.AddDate(0, 0, 7))
+		require.NoError(t, err)
+		require.Len(t, test, 1)
+		require.Equal(t, tt.AddDate(0, 0, 7), test[0].StartTime)
+		require.Equal(t, id, test[0].ID)
+
+		test, err = events.ListEventsByWeek(ctx, 11, tt)
+		require.NoError(t, err)
+		require.Len(t, test, 2)
+		titles := []string{test[0].Title, test[1].Title}
+		require.ElementsMatch(t, []string{"Standup", "Standup moved"}, titles)
+
+		test, err = events.ListEventsByMonth(ctx, 11, tt)
+		require.NoError(t, err)
+		require.Len(t, test, 13)
+	})
+	t.Run("transaction", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		_, err := events.CreateEvent(ctx, &common.Event{Title: "kept", Owner: 1})
+		require.NoError(t, err)
+
+		err = events.WithTx(ctx, func(ctx context.Context) error {
+			if _, err := events.CreateEvent(ctx, &common.Event{Title: "rolled back", Owner: 1}); err != nil {
+				return err
+			}
+			if err := events.DeleteEvent(ctx, 1, 1, 0); err != nil {
+				return err
+			}
+			return events.DeleteEvent(ctx, 1, 100, 0)
+		})
+		require.ErrorIs(t, err, common.ErrNoSuchEvent)
+		require.Len(t, events.events, 1)
+		require.Equal(t, "kept", events.events[1].Title)
+
+		err = events.WithTx(ctx, func(ctx context.Context) error {
+			_, err := events.CreateEvent(ctx, &common.Event{Title: "committed", Owner: 1})
+			return err
+		})
+		require.NoError(t, err)
+		require.Len(t, events.events, 2)
+	})
+	t.Run("versions", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		event := &common.Event{Title: "draft", Owner: 1}
+		id, err := events.CreateEvent(ctx, event)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), event.Version)
+
+		first := &common.Event{Title: "first", Owner: 1, Version: 1}
+		require.NoError(t, events.UpdateEvent(ctx, 1, id, first))
+		require.Equal(t, int64(2), first.Version)
+		second := &common.Event{Title: "second", Owner: 1, Version: 1}
+		require.ErrorIs(t, events.UpdateEvent(ctx, 1, id, second), common.ErrVersionConflict)
+		require.ErrorIs(t, events.DeleteEvent(ctx, 1, id, 1), common.ErrVersionConflict)
+
+		unchecked := &common.Event{Title: "unchecked", Owner: 1}
+		require.NoError(t, events.UpdateEvent(ctx, 1, id, unchecked))
+		require.Equal(t, int64(3), unchecked.Version)
+		require.NoError(t, events.DeleteEvent(ctx, 1, id, 3))
+	})
+	t.Run("pages", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		_, err := events.CreateEvent(ctx, &common.Event{Title: "Standup", StartTime: start, Owner: 1, RRule: "FREQ=DAILY"})
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, err = events.CreateEvent(ctx, &common.Event{Title: "review", StartTime: start.AddDate(0, 0, i), Owner: 1})
+			require.NoError(t, err)
+		}
+		_, err = events.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: start, Owner: 2})
+		require.NoError(t, err)
+
+		filter := common.EventFilter{Owner: 1, Limit: 4}
+		var titles []string
+		var starts []time.Time
+		for pages := 0; pages < 3; pages++ {
+			page, next, err := events.ListEvents(ctx, start, start.AddDate(0, 0, 3), filter)
+			require.NoError(t, err)
+			for _, event := range page {
+				titles = append(titles, event.Title)
+				starts = append(starts, event.StartTime)
+			}
+			if next == "" {
+				break
+			}
+			filter.Cursor = next
+		}
+		require.Equal(t, []string{"Standup", "review", "Standup", "review", "Standup", "review"}, titles)
+		require.True(t, sort.SliceIsSorted(starts, func(i, j int) bool { return starts[i].Before(starts[j]) }))
+
+		page, next, err := events.ListEvents(ctx, start, start.AddDate(0, 0, 3), common.EventFilter{Owner: 1, Title: "stand", Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, page, 3)
+		require.Empty(t, next)
+
+		_, _, err = events.ListEvents(ctx, start, start.AddDate(0, 0, 3), common.EventFilter{Owner: 1, Cursor: "@"})
+		require.ErrorIs(t, err, common.ErrInvalidCursor)
+	})
+	t.Run("notifications", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		id, err := events.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: now.Add(time.Minute), NotifyTime: 300, Owner: 1, RRule: "FREQ=DAILY"})
+		require.NoError(t, err)
+		_, err = events.CreateEvent(ctx, &common.Event{Title: "review", StartTime: now.Add(time.Hour), NotifyTime: 300, Owner: 1})
+		require.NoError(t, err)
+
+		due, err := events.ListEventsToNotify(ctx, now)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.NoError(t, events.MarkNotified(ctx, []int64{id, 100}, now))
+		due, err = events.ListEventsToNotify(ctx, now.Add(time.Second))
+		require.NoError(t, err)
+		require.Empty(t, due, "the occurrence is notified once")
+
+		require.NoError(t, events.UpdateEvent(ctx, 1, id, &common.Event{Title: "daily", StartTime: now.Add(time.Minute), NotifyTime: 300, Owner: 1, RRule: "FREQ=DAILY"}))
+		due, err = events.ListEventsToNotify(ctx, now.Add(time.Second))
+		require.NoError(t, err)
+		require.Empty(t, due, "updates keep the marker")
+
+		due, err = events.ListEventsToNotify(ctx, now.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Len(t, due, 1, "the next occurrence is notified")
+		require.Equal(t, now.AddDate(0, 0, 1).Add(time.Minute), due[0].EventTime)
+	})
+	t.Run("attendees", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		id, err := events.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: now.Add(time.Minute), NotifyTime: 300, Owner: 1, RRule: "FREQ=DAILY"})
+		require.NoError(t, err)
+		recurrenceID := now.AddDate(0, 0, 1).Add(time.Minute)
+		override, err := events.CreateEvent(ctx, &common.Event{
+			Title: "standup", StartTime: recurrenceID.Add(time.Hour), NotifyTime: 300, Owner: 1,
+			ParentID: id, RecurrenceID: &recurrenceID,
+		})
+		require.NoError(t, err)
+
+		require.ErrorIs(t, events.AddAttendees(ctx, 2, id, []int64{3}), common.ErrForbidden)
+		require.ErrorIs(t, events.AddAttendees(ctx, 1, 100, []int64{3}), common.ErrNoSuchEvent)
+		require.NoError(t, events.AddAttendees(ctx, 1, id, []int64{2, 3}))
+		require.NoError(t, events.SetAttendeeStatus(ctx, id, 2, common.StatusAccepted))
+		require.NoError(t, events.AddAttendees(ctx, 1, id, []int64{2}))
+		require.ErrorIs(t, events.SetAttendeeStatus(ctx, id, 4, common.StatusAccepted), common.ErrNotInvited)
+		require.ErrorIs(t, events.SetAttendeeStatus(ctx, 100, 2, common.StatusAccepted), common.ErrNoSuchEvent)
+
+		event, err := events.GetEvent(ctx, 1, override)
+		require.NoError(t, err)
+		require.Len(t, event.Attendees, 2, "an override has the attendees of the series")
+		status, ok := event.AttendeeStatus(2)
+		require.True(t, ok)
+		require.Equal(t, common.StatusAccepted, status, "the answer survives a repeated invitation")
+
+		invited, err := events.ListInvitedEvents(ctx, 3, now, now.AddDate(0, 0, 3))
+		require.NoError(t, err)
+		require.Len(t, invited, 3)
+		starts := make([]time.Time, 0, len(invited))
+		for _, event := range invited {
+			starts = append(starts, event.StartTime)
+		}
+		require.Contains(t, starts, recurrenceID.Add(time.Hour), "the override replaces the occurrence")
+		require.NotContains(t, starts, recurrenceID)
+		invited, err = events.ListInvitedEvents(ctx, 4, now, now.AddDate(0, 0, 3))
+		require.NoError(t, err)
+		require.Empty(t, invited)
+
+		due, err := events.ListEventsToNotify(ctx, now)
+		require.NoError(t, err)
+		require.Len(t, due, 2)
+		require.Equal(t, int64(1), due[0].Recipient)
+		require.Equal(t, int64(2), due[1].Recipient)
+
+		err = events.WithTx(ctx, func(ctx context.Context) error {
+			if err := events.SetAttendeeStatus(ctx, id, 3, common.StatusDeclined); err != nil {
+				return err
+			}
+			return errors.New("rolled back")
+		})
+		require.Error(t, err)
+		event, err = events.GetEvent(ctx, 1, id)
+		require.NoError(t, err)
+		require.Equal(t, common.StatusPending, event.Attendees[1].Status)
+
+		require.NoError(t, events.DeleteEvent(ctx, 1, id, 0))
+		require.Empty(t, events.attendees)
+	})
+	t.Run("outbox", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		first := common.Notification{ID: 1, Title: "first", Key: "1"}
+		second := common.Notification{ID: 2, Title: "second", Key: "2"}
+		require.NoError(t, events.EnqueueNotifications(ctx, []common.Notification{first, second, first}))
+		err := events.WithTx(ctx, func(ctx context.Context) error {
+			if err := events.EnqueueNotifications(ctx, []common.Notification{{ID: 3, Key: "3"}}); err != nil {
+				return err
+			}
+			if err := events.DeleteFromOutbox(ctx, []string{"1"}); err != nil {
+				return err
+			}
+			return errors.New("rolled back")
+		})
+		require.Error(t, err)
+		outbox, err := events.ListOutbox(ctx, 10)
+		require.NoError(t, err)
+		require.Equal(t, []common.Notification{first, second}, outbox)
+
+		require.NoError(t, events.DeleteFromOutbox(ctx, []string{"1", "3"}))
+		outbox, err = events.ListOutbox(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, []common.Notification{second}, outbox)
+	})
+	t.Run("delete before", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		log := logrus.New()
+		events := New(log)
+		start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			_, err := events.CreateEvent(ctx, &common.Event{Title: "old", StartTime: start.AddDate(0, 0, i), Duration: 3600, Owner: 1})
+			require.NoError(t, err)
+		}
+		seriesID, err := events.CreateEvent(ctx, &common.Event{Title: "sprint", StartTime: start, Owner: 1, RRule: "FREQ=WEEKLY;COUNT=2"})
+		require.NoError(t, err)
+		recurrenceID := start.AddDate(0, 0, 7)
+		_, err = events.CreateEvent(ctx, &common.Event{Title: "sprint", StartTime: start.AddDate(0, 0, 8), Owner: 1, ParentID: seriesID, RecurrenceID: &recurrenceID})
+		require.NoError(t, err)
+		_, err = events.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: start, Owner: 1, RRule: "FREQ=DAILY"})
+		require.NoError(t, err)
+		_, err = events.CreateEvent(ctx, &common.Event{Title: "new", StartTime: start.AddDate(0, 1, 0), Owner: 1})
+		require.NoError(t, err)
+
+		before := start.AddDate(0, 0, 10)
+		n, err := events.CountEventsBefore(ctx, before)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), n)
+		require.Len(t, events.events, 7)
+
+		var removed int64
+		for {
+			n, err = events.DeleteEventsBefore(ctx, before, 2)
+			require.NoError(t, err)
+			if n == 0 {
+				break
+			}
+			removed += n
+		}
+		require.Equal(t, int64(5), removed)
+		require.Len(t, events.events, 2)
+		for _, event := range events.events {
+			require.Contains(t, []string{"standup", "new"}, event.Title)
+		}
+	})
+	t.Run("delete series", func(t *testing.T) {
+		ctx := context.Background()
+		events := New(logrus.New())
+		start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		id, err := events.CreateEvent(ctx, &common.Event{Title: "standup", StartTime: start, Owner: 1, RRule: "FREQ=DAILY"})
+		require.NoError(t, err)
+		recurrenceID := start.AddDate(0, 0, 1)
+		override, err := events.CreateEvent(ctx, &common.Event{
+			Title: "moved", StartTime: recurrenceID.Add(time.Hour), Owner: 1, ParentID: id, RecurrenceID: &recurrenceID,
+		})
+		require.NoError(t, err)
+		require.NoError(t, events.AddAttendees(ctx, 1, id, []int64{2}))
+
+		require.NoError(t, events.DeleteEvent(ctx, 1, id, 0))
+		listed, err := events.ListEventsByWeek(ctx, 1, start)
+		require.NoError(t, err)
+		require.Empty(t, listed, "the overrides go along with the series")
+		_, err = events.GetEvent(ctx, 1, override)
+		require.ErrorIs(t, err, common.ErrNoSuchEvent)
+		require.Empty(t, events.attendees)
+	})
+	t.Run("recurrence limit", func(t *testing.T) {
+		ctx := context.Background()
+		events := New(logrus.New())
+		start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		_, err := events.CreateEvent(ctx, &common.Event{
+			Title: "counted", StartTime: start.AddDate(-300, 0, 0), Owner: 1, RRule: "FREQ=DAILY;COUNT=200000",
+		})
+		require.NoError(t, err)
+
+		_, err = events.ListEventsByDay(ctx, 1, start)
+		require.ErrorIs(t, err, common.ErrRecurrenceLimit)
+		_, err = events.FindOverlapping(ctx, 1, start, start.Add(time.Hour))
+		require.ErrorIs(t, err, common.ErrRecurrenceLimit, "the overlap check doesn't ignore the series")
+		_, _, err = events.ListEvents(ctx, start, start.AddDate(0, 0, 1), common.EventFilter{Owner: 1, Limit: 10})
+		require.ErrorIs(t, err, common.ErrRecurrenceLimit)
+	})
 	t.Run("concurrent", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE events
+    ADD COLUMN rrule         text    not null default '',
+    ADD COLUMN exdates       text    not null default '',
+    ADD COLUMN parent_id     integer not null default 0,
+    ADD COLUMN recurrence_id timestamp;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE events
+    DROP COLUMN rrule,
+    DROP COLUMN exdates,
+    DROP COLUMN parent_id,
+    DROP COLUMN recurrence_id;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE events
+    ADD COLUMN version integer not null default 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE events
+    DROP COLUMN version;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE events
+    ADD COLUMN notified_at timestamp;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE events
+    DROP COLUMN notified_at;
+-- +goose StatementEnd
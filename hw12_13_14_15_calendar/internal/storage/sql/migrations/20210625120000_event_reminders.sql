@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS event_reminders
+(
+    event_id integer not null references events (id) on delete cascade,
+    seconds  integer not null,
+    primary key (event_id, seconds)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE event_reminders;
+-- +goose StatementEnd
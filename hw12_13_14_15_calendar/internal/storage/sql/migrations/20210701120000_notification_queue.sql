@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS notification_queue
+(
+    id           bigserial primary key,
+    body         bytea     not null,
+    retries      integer   not null default 0,
+    available_at timestamp not null default now(),
+    dead_at      timestamp,
+    last_error   text      not null default ''
+);
+CREATE INDEX IF NOT EXISTS notification_queue_available_at_idx ON notification_queue (available_at) WHERE dead_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE notification_queue;
+-- +goose StatementEnd
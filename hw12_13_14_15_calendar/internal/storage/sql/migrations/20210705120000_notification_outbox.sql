@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS notification_outbox
+(
+    id      bigserial primary key,
+    key     text      not null unique,
+    payload jsonb     not null,
+    created timestamp not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE notification_outbox;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS delivered_notifications
+(
+    key          text primary key,
+    delivered_at timestamp not null default now()
+);
+CREATE INDEX IF NOT EXISTS delivered_notifications_delivered_at_idx ON delivered_notifications (delivered_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE delivered_notifications;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS event_attendees
+(
+    event_id integer   not null references events (id) on delete cascade,
+    user_id  integer   not null,
+    status   text      not null default 'pending'
+        check (status IN ('pending', 'accepted', 'declined', 'tentative')),
+    updated  timestamp not null default now(),
+    primary key (event_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS event_attendees_user_id_idx ON event_attendees (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE event_attendees;
+-- +goose StatementEnd
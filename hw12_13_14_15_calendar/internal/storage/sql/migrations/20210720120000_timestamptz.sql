@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The times have been stored as UTC wall clock.
+ALTER TABLE events
+    ALTER COLUMN start_time TYPE timestamptz USING start_time AT TIME ZONE 'UTC',
+    ALTER COLUMN created TYPE timestamptz USING created AT TIME ZONE 'UTC',
+    ALTER COLUMN updated TYPE timestamptz USING updated AT TIME ZONE 'UTC',
+    ALTER COLUMN notified_at TYPE timestamptz USING notified_at AT TIME ZONE 'UTC',
+    ALTER COLUMN recurrence_id TYPE timestamptz USING recurrence_id AT TIME ZONE 'UTC',
+    ADD COLUMN time_zone text not null default '';
+ALTER TABLE event_attendees
+    ALTER COLUMN updated TYPE timestamptz USING updated AT TIME ZONE 'UTC';
+ALTER TABLE notification_queue
+    ALTER COLUMN available_at TYPE timestamptz USING available_at AT TIME ZONE 'UTC',
+    ALTER COLUMN dead_at TYPE timestamptz USING dead_at AT TIME ZONE 'UTC';
+ALTER TABLE notification_outbox
+    ALTER COLUMN created TYPE timestamptz USING created AT TIME ZONE 'UTC';
+ALTER TABLE delivered_notifications
+    ALTER COLUMN delivered_at TYPE timestamptz USING delivered_at AT TIME ZONE 'UTC';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE events
+    ALTER COLUMN start_time TYPE timestamp USING start_time AT TIME ZONE 'UTC',
+    ALTER COLUMN created TYPE timestamp USING created AT TIME ZONE 'UTC',
+    ALTER COLUMN updated TYPE timestamp USING updated AT TIME ZONE 'UTC',
+    ALTER COLUMN notified_at TYPE timestamp USING notified_at AT TIME ZONE 'UTC',
+    ALTER COLUMN recurrence_id TYPE timestamp USING recurrence_id AT TIME ZONE 'UTC',
+    DROP COLUMN time_zone;
+ALTER TABLE event_attendees
+    ALTER COLUMN updated TYPE timestamp USING updated AT TIME ZONE 'UTC';
+ALTER TABLE notification_queue
+    ALTER COLUMN available_at TYPE timestamp USING available_at AT TIME ZONE 'UTC',
+    ALTER COLUMN dead_at TYPE timestamp USING dead_at AT TIME ZONE 'UTC';
+ALTER TABLE notification_outbox
+    ALTER COLUMN created TYPE timestamp USING created AT TIME ZONE 'UTC';
+ALTER TABLE delivered_notifications
+    ALTER COLUMN delivered_at TYPE timestamp USING delivered_at AT TIME ZONE 'UTC';
+-- +goose StatementEnd
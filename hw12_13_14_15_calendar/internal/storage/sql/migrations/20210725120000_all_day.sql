@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE events
+    ADD COLUMN all_day boolean not null default false,
+    ADD COLUMN end_time timestamptz;
+UPDATE events SET end_time = start_time + duration * INTERVAL '1 second';
+ALTER TABLE events
+    ALTER COLUMN end_time SET NOT NULL;
+CREATE INDEX IF NOT EXISTS events_owner_end_time_idx ON events (owner, end_time);
+CREATE INDEX IF NOT EXISTS events_parent_id_recurrence_id_idx ON events (parent_id, recurrence_id) WHERE parent_id != 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS events_parent_id_recurrence_id_idx;
+DROP INDEX IF EXISTS events_owner_end_time_idx;
+ALTER TABLE events
+    DROP COLUMN end_time,
+    DROP COLUMN all_day;
+-- +goose StatementEnd
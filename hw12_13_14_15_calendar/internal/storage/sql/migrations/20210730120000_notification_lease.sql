@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A key is leased for the delivery of its notification before it is marked delivered.
+ALTER TABLE delivered_notifications
+    ADD COLUMN delivered boolean not null default true;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM delivered_notifications WHERE NOT delivered;
+ALTER TABLE delivered_notifications
+    DROP COLUMN delivered;
+-- +goose StatementEnd
@@ -0,0 +1,176 @@
+package sqlstorage
+
+import "strings"
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+const eventColumns = `id, title, start_time, time_zone, all_day, duration, description, owner, notify_time, created,
+       updated, version, notified_at, rrule, exdates, parent_id, recurrence_id`
+
+const (
+	// overlapsWindow matches single events overlapping [$2, $3), an instant one if it is within.
+	overlapsWindow = `start_time < $3 AND (end_time > $2 OR start_time >= $2)`
+
+	// overridesWindow matches the overrides overlapping [$2, $3) and the ones replacing an occurrence
+	// which may overlap it, an occurrence lasts an hour longer than the series at most.
+	overridesWindow = `parent_id != 0
+       AND ((` + overlapsWindow + `)
+         OR (recurrence_id < $3 AND recurrence_id + INTERVAL '1 hour'
+               + (SELECT series.end_time - series.start_time FROM events series WHERE series.id = events.parent_id) > $2))`
+)
+
+const (
+	insertEventQuery = `
+INSERT INTO events (title, start_time, end_time, time_zone, all_day, duration, description, owner, notify_time,
+                    created, updated, version, rrule, exdates, parent_id, recurrence_id)
+VALUES (:title, :start_time, :end_time, :time_zone, :all_day, :duration, :description, :owner, :notify_time,
+        :created, :updated, :version, :rrule, :exdates, :parent_id, :recurrence_id)
+RETURNING id`
+
+	// updateEventQuery skips the check of the version if :version is zero.
+	updateEventQuery = `
+UPDATE events
+SET (title, start_time, end_time, time_zone, all_day, duration, description, owner, notify_time, updated, rrule,
+     exdates, parent_id, recurrence_id) =
+    (:title, :start_time, :end_time, :time_zone, :all_day, :duration, :description, :owner, :notify_time, :updated,
+     :rrule, :exdates, :parent_id, :recurrence_id),
+    version = version + 1
+WHERE id = :id
+  AND owner = :current_owner
+  AND (:version = 0 OR version = :version)
+RETURNING created, version`
+
+	// deleteEventQuery skips the check of the version if $3 is zero.
+	deleteEventQuery = `DELETE FROM events WHERE id = $1 AND owner = $2 AND ($3 = 0 OR version = $3)`
+
+	deleteOverridesQuery = `DELETE FROM events WHERE parent_id = $1`
+
+	selectOwnerQuery = `SELECT owner FROM events WHERE id = $1`
+
+	getEventQuery = `SELECT ` + eventColumns + ` FROM events WHERE id = $1`
+
+	getEventForUpdateQuery = getEventQuery + ` FOR UPDATE`
+
+	// listEventsQuery selects single events overlapping [$2, $3) along with the recurring
+	// series and the overrides which may produce or replace an occurrence overlapping it.
+	listEventsQuery = `
+SELECT ` + eventColumns + `
+FROM events
+WHERE owner = $1
+  AND ((rrule = '' AND ` + overlapsWindow + `)
+    OR (rrule != '' AND start_time < $3)
+    OR (` + overridesWindow + `))`
+
+	// listEventsPageQuery selects up to $7 single events and overrides overlapping [$2, $3)
+	// with the title matching $4, ordered by start time and id and following ($5, $6).
+	listEventsPageQuery = `
+SELECT ` + eventColumns + `
+FROM events
+WHERE owner = $1
+  AND rrule = ''
+  AND ` + overlapsWindow + `
+  AND title ILIKE $4
+  AND (start_time, id) > ($5, $6)
+ORDER BY start_time, id
+LIMIT $7`
+
+	// listSeriesQuery selects the recurring series with the title matching $4 which may produce
+	// an occurrence overlapping [$2, $3) along with the overrides replacing occurrences overlapping it.
+	listSeriesQuery = `
+SELECT ` + eventColumns + `
+FROM events
+WHERE owner = $1
+  AND ((rrule != '' AND start_time < $3 AND title ILIKE $4)
+    OR (` + overridesWindow + `))`
+
+	// lockOwnerQuery serializes the overlap checks of concurrent transactions of the same owner.
+	lockOwnerQuery = `SELECT pg_advisory_xact_lock($1)`
+
+	// endedEventsCondition matches single events which ended before $1.
+	endedEventsCondition = `rrule = '' AND parent_id = 0 AND end_time < $1`
+
+	// deleteEndedEventsQuery removes up to $2 single events which ended before $1, all of them if $2 is NULL.
+	deleteEndedEventsQuery = `
+DELETE FROM events
+WHERE id IN (SELECT id FROM events WHERE ` + endedEventsCondition + ` ORDER BY id LIMIT $2)`
+
+	countEndedEventsQuery = `SELECT COUNT(*) FROM events WHERE ` + endedEventsCondition
+
+	// listFiniteSeriesQuery selects the recurring series starting before $1 which may have ended.
+	listFiniteSeriesQuery = `
+SELECT ` + eventColumns + `
+FROM events
+WHERE rrule != ''
+  AND start_time < $1
+  AND (rrule ILIKE '%COUNT=%' OR rrule ILIKE '%UNTIL=%')
+ORDER BY id`
+
+	deleteSeriesQuery = `DELETE FROM events WHERE id = ANY($1) OR parent_id = ANY($1)`
+
+	countOverridesQuery = `SELECT COUNT(*) FROM events WHERE parent_id = ANY($1)`
+
+	// firstReminder is the largest reminder offset of an event.
+	firstReminder = `GREATEST(notify_time, (SELECT COALESCE(MAX(seconds), 0) FROM event_reminders WHERE event_id = events.id))`
+
+	// notifyWindowOpens is the time the first notify window of an event opens at.
+	notifyWindowOpens = `start_time - ` + firstReminder + ` * INTERVAL '1 second'`
+
+	// listEventsToNotifyQuery selects the single events and overrides with a notify window opened by $1
+	// and not all of the notifications enqueued, the series which may have such an occurrence and
+	// the overrides which may replace one.
+	listEventsToNotifyQuery = `
+SELECT ` + eventColumns + `
+FROM events
+WHERE (rrule = '' AND start_time >= $1 AND ` + notifyWindowOpens + ` < $1
+       AND (notified_at IS NULL OR notified_at < start_time))
+   OR (rrule != '' AND ` + firstReminder + ` > 0 AND ` + notifyWindowOpens + ` < $1)
+   OR (parent_id != 0 AND recurrence_id >= $1)`
+
+	lockedEventsToNotifyQuery = listEventsToNotifyQuery + `
+FOR UPDATE SKIP LOCKED`
+
+	markNotifiedQuery = `UPDATE events SET notified_at = $2 WHERE id = ANY($1)`
+
+	listRemindersQuery = `SELECT event_id, seconds FROM event_reminders WHERE event_id = ANY($1) ORDER BY seconds DESC`
+
+	deleteRemindersQuery = `DELETE FROM event_reminders WHERE event_id = $1`
+
+	insertRemindersQuery = `
+INSERT INTO event_reminders (event_id, seconds)
+SELECT $1, seconds FROM UNNEST($2::integer[]) AS seconds
+ON CONFLICT DO NOTHING`
+
+	listAttendeesQuery = `SELECT event_id, user_id, status, updated FROM event_attendees WHERE event_id = ANY($1) ORDER BY user_id`
+
+	addAttendeesQuery = `
+INSERT INTO event_attendees (event_id, user_id, status, updated)
+SELECT $1, user_id, 'pending', $3 FROM UNNEST($2::integer[]) AS user_id
+ON CONFLICT DO NOTHING`
+
+	setAttendeeStatusQuery = `UPDATE event_attendees SET status = $3, updated = $4 WHERE event_id = $1 AND user_id = $2`
+
+	// invitedTo selects the IDs of the events $1 is invited to.
+	invitedTo = `(SELECT event_id FROM event_attendees WHERE user_id = $1)`
+
+	// listInvitedEventsQuery selects single events $1 is invited to overlapping [$2, $3) along with
+	// the recurring series $1 is invited to and their overrides which may produce or replace an occurrence overlapping it.
+	listInvitedEventsQuery = `
+SELECT ` + eventColumns + `
+FROM events
+WHERE (id IN ` + invitedTo + `
+       AND ((rrule = '' AND ` + overlapsWindow + `) OR (rrule != '' AND start_time < $3)))
+   OR (parent_id IN ` + invitedTo + ` AND ` + overridesWindow + `)`
+
+	enqueueNotificationsQuery = `
+INSERT INTO notification_outbox (key, payload)
+SELECT key, payload::jsonb FROM UNNEST($1::text[], $2::text[]) AS outbox (key, payload)
+ON CONFLICT (key) DO NOTHING`
+
+	listOutboxQuery = `SELECT payload FROM notification_outbox ORDER BY id LIMIT $1`
+
+	lockedOutboxQuery = listOutboxQuery + `
+FOR UPDATE SKIP LOCKED`
+
+	deleteFromOutboxQuery = `DELETE FROM notification_outbox WHERE key = ANY($1)`
+)
@@ -2,6 +2,9 @@ package sqlstorage
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -27,130 +30,543 @@ func New(ctx context.Context, log *logrus.Logger, dsn string) (*Storage, error)
 	return &Storage{db: db, log: log}, nil
 }
 
-func (s *Storage) CreateEvent(ctx context.Context, event *common.Event) (int64, error) {
+type txKey struct{}
+
+// conn returns the transaction started by WithTx for ctx or the database handle otherwise.
+func (s *Storage) conn(ctx context.Context) sqlx.ExtContext {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return s.db
+}
+
+// WithTx runs fn in a transaction which is committed if fn succeeds and rolled back otherwise.
+// Storage methods called with the context passed to fn run within the transaction,
+// nested calls of WithTx join the outer transaction.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			s.log.Warn("err rolling back transaction: ", rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// eventRow binds the end of an event stored along with it for the window queries.
+type eventRow struct {
+	*common.Event
+	EndTime time.Time `db:"end_time"`
+}
+
+func newEventRow(event *common.Event) eventRow {
+	return eventRow{Event: event, EndTime: event.End()}
+}
+
+// ownedEvent binds the owner an event is expected to belong to before an update.
+type ownedEvent struct {
+	eventRow
+	CurrentOwner int64 `db:"current_owner"`
+}
+
+// CreateEvent inserts the event along with its reminders.
+func (s *Storage) CreateEvent(ctx context.Context, event *common.Event) (id int64, err error) {
 	event.Created = time.Now()
 	event.Updated = time.Now()
-	query := fmt.Sprintf(`
-INSERT INTO events (title, start_time, duration, description, owner, notify_time) VALUES ('%s', '%s', %d, '%s', '%d', '%d')
-RETURNING id;
-`, event.Title, event.StartTime.Format(common.PgTimestampFmt), event.Duration, event.Description, event.Owner, event.NotifyTime)
-	row := s.db.QueryRowxContext(ctx, query)
-	var id int64
-	if err := row.Scan(&id); err != nil {
+	event.Version = 1
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.namedQueryRow(ctx, insertEventQuery, newEventRow(event), &id); err != nil {
+			return err
+		}
+		return s.insertReminders(ctx, id, event.Reminders)
+	})
+	if err != nil {
 		return 0, err
 	}
+	event.ID = id
 	s.log.Trace("added event ", id)
 	return id, nil
 }
 
-func (s *Storage) UpdateEvent(ctx context.Context, id int64, event *common.Event) error {
+// UpdateEvent replaces the event and its reminders unless event.Version is set and differs from the stored one.
+func (s *Storage) UpdateEvent(ctx context.Context, owner, id int64, event *common.Event) error {
 	event.ID = id
 	event.Updated = time.Now()
-	query := fmt.Sprintf(`
-UPDATE events SET (title, start_time, duration, description, owner, notify_time, updated) = ('%s', '%s', %d, '%s', '%d', '%d', '%s')
-WHERE id = %d
-`, event.Title, event.StartTime.Format(common.PgTimestampFmt), event.Duration, event.Description, event.Owner, event.NotifyTime, time.Now().Format(common.PgTimestampFmt), id)
-	res, err := s.db.ExecContext(ctx, query)
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		err := s.namedQueryRow(ctx, updateEventQuery, ownedEvent{eventRow: newEventRow(event), CurrentOwner: owner}, &event.Created, &event.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.updateError(ctx, owner, id)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = s.conn(ctx).ExecContext(ctx, deleteRemindersQuery, id); err != nil {
+			return err
+		}
+		return s.insertReminders(ctx, id, event.Reminders)
+	})
 	if err != nil {
 		return err
 	}
-	n, err := res.RowsAffected()
+	s.log.Trace("modified event ", id)
+	return nil
+}
+
+// namedQueryRow runs a named query and scans the single row it returns into dest, sql.ErrNoRows if there is none.
+func (s *Storage) namedQueryRow(ctx context.Context, query string, arg interface{}, dest ...interface{}) error {
+	rows, err := sqlx.NamedQueryContext(ctx, s.conn(ctx), query, arg)
 	if err != nil {
 		return err
 	}
-	if n == 0 {
-		return common.ErrNoSuchEvent
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Warn("err closing rows: ", err)
+		}
+	}()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return rows.Scan(dest...)
+}
+
+func (s *Storage) insertReminders(ctx context.Context, id int64, reminders []int32) error {
+	if len(reminders) == 0 {
+		return nil
+	}
+	_, err := s.conn(ctx).ExecContext(ctx, insertRemindersQuery, id, reminders)
+	return err
+}
+
+// loadReminders fills the reminders of the events.
+func (s *Storage) loadReminders(ctx context.Context, events []common.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	var reminders []struct {
+		EventID int64 `db:"event_id"`
+		Seconds int32 `db:"seconds"`
+	}
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &reminders, listRemindersQuery, ids); err != nil {
+		return err
+	}
+	byEvent := make(map[int64][]int32, len(events))
+	for _, reminder := range reminders {
+		byEvent[reminder.EventID] = append(byEvent[reminder.EventID], reminder.Seconds)
+	}
+	for i := range events {
+		events[i].Reminders = byEvent[events[i].ID]
 	}
-	s.log.Trace("modified event ", id)
 	return nil
 }
 
-func (s *Storage) DeleteEvent(ctx context.Context, id int64) error {
-	query := fmt.Sprintf(`DELETE FROM events WHERE id = %d`, id)
-	res, err := s.db.ExecContext(ctx, query)
+// DeleteEvent removes the event along with its overrides unless version is set and differs from the stored one.
+func (s *Storage) DeleteEvent(ctx context.Context, owner, id, version int64) error {
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		res, err := s.conn(ctx).ExecContext(ctx, deleteEventQuery, id, owner, version)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return s.updateError(ctx, owner, id)
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, deleteOverridesQuery, id)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	n, err := res.RowsAffected()
+	s.log.Trace("removed event ", id)
+	return nil
+}
+
+// GetEvent locks the event until the end of the transaction if called within one.
+func (s *Storage) GetEvent(ctx context.Context, owner, id int64) (*common.Event, error) {
+	query := getEventQuery
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		query = getEventForUpdateQuery
+	}
+	event := new(common.Event)
+	err := sqlx.GetContext(ctx, s.conn(ctx), event, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, common.ErrNoSuchEvent
+	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if event.Owner != owner {
+		return nil, common.ErrForbidden
 	}
-	if n == 0 {
+	event.Localize()
+	events := []common.Event{*event}
+	if err = s.loadReminders(ctx, events); err != nil {
+		return nil, err
+	}
+	if err = s.loadAttendees(ctx, events); err != nil {
+		return nil, err
+	}
+	return &events[0], nil
+}
+
+// updateError tells apart a missing event, an event of another owner and an event of another version.
+func (s *Storage) updateError(ctx context.Context, owner, id int64) error {
+	var stored int64
+	err := sqlx.GetContext(ctx, s.conn(ctx), &stored, selectOwnerQuery, id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return common.ErrNoSuchEvent
 	}
-	s.log.Trace("removed event ", id)
-	return nil
+	if err != nil {
+		return err
+	}
+	if stored != owner {
+		return common.ErrForbidden
+	}
+	return common.ErrVersionConflict
 }
 
-func (s *Storage) ListEventsByDay(ctx context.Context, date time.Time) ([]common.Event, error) {
-	return s.listEvents(ctx, date, date.AddDate(0, 0, 1))
+func (s *Storage) ListEventsByDay(ctx context.Context, owner int64, date time.Time) ([]common.Event, error) {
+	return s.listEvents(ctx, owner, date, date.AddDate(0, 0, 1))
 }
 
-func (s *Storage) ListEventsByWeek(ctx context.Context, date time.Time) ([]common.Event, error) {
-	return s.listEvents(ctx, date, date.AddDate(0, 0, 7))
+func (s *Storage) ListEventsByWeek(ctx context.Context, owner int64, date time.Time) ([]common.Event, error) {
+	return s.listEvents(ctx, owner, date, date.AddDate(0, 0, 7))
 }
 
-func (s *Storage) ListEventsByMonth(ctx context.Context, date time.Time) ([]common.Event, error) {
-	return s.listEvents(ctx, date, date.AddDate(0, 1, 0))
+func (s *Storage) ListEventsByMonth(ctx context.Context, owner int64, date time.Time) ([]common.Event, error) {
+	return s.listEvents(ctx, owner, date, date.AddDate(0, 1, 0))
 }
 
-func (s *Storage) listEvents(ctx context.Context, fromDate, toDate time.Time) ([]common.Event, error) {
-	query := fmt.Sprintf(`
-SELECT *
-FROM events
-WHERE start_time >= timestamp '%s'
-  AND start_time < timestamp '%s'
-`, fromDate.Format(common.PgTimestampFmt), toDate.Format(common.PgTimestampFmt))
-	result := make([]common.Event, 0)
-	rows, err := s.db.QueryxContext(ctx, query)
-	defer func() {
-		if err := rows.Close(); err != nil {
-			s.log.Warn("err closing rows: ", err)
+func (s *Storage) listEvents(ctx context.Context, owner int64, fromDate, toDate time.Time) ([]common.Event, error) {
+	candidates := make([]common.Event, 0)
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &candidates, listEventsQuery, owner, fromDate, toDate); err != nil {
+		return nil, err
+	}
+	if err := s.loadReminders(ctx, candidates); err != nil {
+		return nil, err
+	}
+	if err := s.loadAttendees(ctx, candidates); err != nil {
+		return nil, err
+	}
+	return common.ExpandEvents(candidates, fromDate, toDate)
+}
+
+// ListEvents pages single events in the database and merges them with the occurrences
+// of recurring series expanded after the cursor.
+func (s *Storage) ListEvents(ctx context.Context, from, to time.Time, filter common.EventFilter) ([]common.Event, string, error) {
+	// The events started before the window and overlapping it come first.
+	var afterTime time.Time
+	var afterID int64
+	if filter.Cursor != "" {
+		var err error
+		if afterTime, afterID, err = common.DecodeCursor(filter.Cursor); err != nil {
+			return nil, "", err
 		}
-	}()
+	}
+	title := "%" + likeEscaper.Replace(filter.Title) + "%"
+	events := make([]common.Event, 0)
+	err := sqlx.SelectContext(ctx, s.conn(ctx), &events, listEventsPageQuery,
+		filter.Owner, from, to, title, afterTime, afterID, filter.Limit+1)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	var series, overrides []common.Event
+	if err = sqlx.SelectContext(ctx, s.conn(ctx), &series, listSeriesQuery, filter.Owner, from, to, title); err != nil {
+		return nil, "", err
+	}
+	if err = s.loadReminders(ctx, events); err != nil {
+		return nil, "", err
+	}
+	if err = s.loadReminders(ctx, series); err != nil {
+		return nil, "", err
+	}
+	if err = s.loadAttendees(ctx, events); err != nil {
+		return nil, "", err
+	}
+	if err = s.loadAttendees(ctx, series); err != nil {
+		return nil, "", err
 	}
-	var event common.Event
-	for rows.Next() {
-		err = rows.StructScan(&event)
+	for i := range events {
+		events[i].Localize()
+	}
+	for _, event := range series {
+		if event.ParentID != 0 {
+			overrides = append(overrides, event)
+		}
+	}
+	if afterTime.Before(from) {
+		afterTime = from
+	}
+	for _, event := range series {
+		if event.RRule == "" {
+			continue
+		}
+		occurrences, err := event.Occurrences(afterTime, to, overrides)
 		if err != nil {
+			return nil, "", fmt.Errorf("expanding event %d: %w", event.ID, err)
+		}
+		events = append(events, occurrences...)
+	}
+	return common.PageEvents(events, filter.Cursor, filter.Limit)
+}
+
+// FindOverlapping returns the events and occurrences of the owner overlapping [from, to).
+// Within a transaction it also locks the owner until the transaction ends,
+// so that the concurrent checks don't let overlapping events in.
+func (s *Storage) FindOverlapping(ctx context.Context, owner int64, from, to time.Time) ([]common.Event, error) {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		if _, err := s.conn(ctx).ExecContext(ctx, lockOwnerQuery, owner); err != nil {
 			return nil, err
 		}
-		result = append(result, event)
 	}
-	if err = rows.Err(); err != nil {
+	candidates := make([]common.Event, 0)
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &candidates, listEventsQuery, owner, from, to); err != nil {
 		return nil, err
 	}
-	return result, nil
+	return common.ExpandEvents(candidates, from, to)
 }
 
-func (s *Storage) ListEventsToNotify(ctx context.Context) (events []common.Event, err error) {
-	query := `
-SELECT *
-FROM events
-WHERE EXTRACT(EPOCH FROM start_time) - EXTRACT(EPOCH FROM NOW()) < notify_time
-AND notify_time != 0;
-`
-	rows, err := s.db.QueryxContext(ctx, query)
-	defer func() {
-		if err := rows.Close(); err != nil {
-			s.log.Warn("err closing rows: ", err)
+// ListEventsToNotify locks the selected events until the end of the transaction if called within one,
+// the events locked by concurrent transactions are skipped.
+func (s *Storage) ListEventsToNotify(ctx context.Context, now time.Time) ([]common.Notification, error) {
+	query := listEventsToNotifyQuery
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		query = lockedEventsToNotifyQuery
+	}
+	var candidates []common.Event
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &candidates, query, now); err != nil {
+		return nil, err
+	}
+	if err := s.loadReminders(ctx, candidates); err != nil {
+		return nil, err
+	}
+	if err := s.loadAttendees(ctx, candidates); err != nil {
+		return nil, err
+	}
+	return common.EventsToNotify(candidates, now)
+}
+
+// AddAttendees invites the users to the event of the owner, the ones invited before keep their status.
+func (s *Storage) AddAttendees(ctx context.Context, owner, id int64, users []int64) error {
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		var stored int64
+		err := sqlx.GetContext(ctx, s.conn(ctx), &stored, selectOwnerQuery+` FOR SHARE`, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return common.ErrNoSuchEvent
 		}
-	}()
+		if err != nil {
+			return err
+		}
+		if stored != owner {
+			return common.ErrForbidden
+		}
+		_, err = s.conn(ctx).ExecContext(ctx, addAttendeesQuery, id, users, time.Now())
+		return err
+	})
+}
+
+// SetAttendeeStatus sets the status of the user's invitation to the event.
+func (s *Storage) SetAttendeeStatus(ctx context.Context, id, user int64, status common.AttendeeStatus) error {
+	res, err := s.conn(ctx).ExecContext(ctx, setAttendeeStatusQuery, id, user, string(status), time.Now())
 	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	var owner int64
+	err = sqlx.GetContext(ctx, s.conn(ctx), &owner, selectOwnerQuery, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.ErrNoSuchEvent
+	}
+	if err != nil {
+		return err
+	}
+	return common.ErrNotInvited
+}
+
+func (s *Storage) ListInvitedEvents(ctx context.Context, user int64, from, to time.Time) ([]common.Event, error) {
+	candidates := make([]common.Event, 0)
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &candidates, listInvitedEventsQuery, user, from, to); err != nil {
+		return nil, err
+	}
+	if err := s.loadReminders(ctx, candidates); err != nil {
+		return nil, err
+	}
+	if err := s.loadAttendees(ctx, candidates); err != nil {
 		return nil, err
 	}
-	var event common.Event
-	for rows.Next() {
-		err = rows.StructScan(&event)
+	return common.ExpandEvents(candidates, from, to)
+}
+
+// loadAttendees fills the attendees of the events, overrides get the ones of their series.
+func (s *Storage) loadAttendees(ctx context.Context, events []common.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.InvitationID())
+	}
+	var attendees []common.Attendee
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &attendees, listAttendeesQuery, ids); err != nil {
+		return err
+	}
+	byEvent := make(map[int64][]common.Attendee, len(events))
+	for _, attendee := range attendees {
+		byEvent[attendee.EventID] = append(byEvent[attendee.EventID], attendee)
+	}
+	for i := range events {
+		events[i].Attendees = byEvent[events[i].InvitationID()]
+	}
+	return nil
+}
+
+func (s *Storage) MarkNotified(ctx context.Context, ids []int64, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.conn(ctx).ExecContext(ctx, markNotifiedQuery, ids, at)
+	return err
+}
+
+// EnqueueNotifications stores the notifications as JSON keeping the first one of each key.
+func (s *Storage) EnqueueNotifications(ctx context.Context, notifications []common.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(notifications))
+	payloads := make([]string, 0, len(notifications))
+	for i := range notifications {
+		payload, err := notifications[i].Encode()
 		if err != nil {
-			return nil, err
+			return err
 		}
-		events = append(events, event)
+		keys = append(keys, notifications[i].Key)
+		payloads = append(payloads, string(payload))
+	}
+	_, err := s.conn(ctx).ExecContext(ctx, enqueueNotificationsQuery, keys, payloads)
+	return err
+}
+
+// ListOutbox locks the selected notifications until the end of the transaction if called within one.
+func (s *Storage) ListOutbox(ctx context.Context, limit int) ([]common.Notification, error) {
+	query := listOutboxQuery
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		query = lockedOutboxQuery
+	}
+	var payloads [][]byte
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &payloads, query, limit); err != nil {
+		return nil, err
+	}
+	notifications := make([]common.Notification, len(payloads))
+	for i, payload := range payloads {
+		if err := json.Unmarshal(payload, &notifications[i]); err != nil {
+			return nil, fmt.Errorf("failed to decode notification: %w", err)
+		}
+	}
+	return notifications, nil
+}
+
+func (s *Storage) DeleteFromOutbox(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.conn(ctx).ExecContext(ctx, deleteFromOutboxQuery, keys)
+	return err
+}
+
+// DeleteEventsBefore removes the single events first and then the ended series along with their overrides.
+func (s *Storage) DeleteEventsBefore(ctx context.Context, t time.Time, limit int) (int64, error) {
+	var batch interface{}
+	if limit > 0 {
+		batch = limit
+	}
+	res, err := s.conn(ctx).ExecContext(ctx, deleteEndedEventsQuery, t, batch)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 && n >= int64(limit) {
+		return n, nil
 	}
-	if err = rows.Err(); err != nil {
+	series, err := s.endedSeries(ctx, t)
+	if err != nil {
+		return n, err
+	}
+	if limit > 0 && len(series) > limit-int(n) {
+		series = series[:limit-int(n)]
+	}
+	if len(series) == 0 {
+		return n, nil
+	}
+	if res, err = s.conn(ctx).ExecContext(ctx, deleteSeriesQuery, series); err != nil {
+		return n, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return n, err
+	}
+	s.log.Tracef("removed %d ended series", len(series))
+	return n + removed, nil
+}
+
+func (s *Storage) CountEventsBefore(ctx context.Context, t time.Time) (int64, error) {
+	var n, overrides int64
+	if err := sqlx.GetContext(ctx, s.conn(ctx), &n, countEndedEventsQuery, t); err != nil {
+		return 0, err
+	}
+	series, err := s.endedSeries(ctx, t)
+	if err != nil || len(series) == 0 {
+		return n, err
+	}
+	if err = sqlx.GetContext(ctx, s.conn(ctx), &overrides, countOverridesQuery, series); err != nil {
+		return 0, err
+	}
+	return n + int64(len(series)) + overrides, nil
+}
+
+// endedSeries returns the IDs of the recurring series which ended before t,
+// their rules can't be evaluated by the database.
+func (s *Storage) endedSeries(ctx context.Context, t time.Time) ([]int64, error) {
+	var candidates []common.Event
+	if err := sqlx.SelectContext(ctx, s.conn(ctx), &candidates, listFiniteSeriesQuery, t); err != nil {
 		return nil, err
 	}
-	return events, nil
+	var ids []int64
+	for _, event := range candidates {
+		if event.EndedBefore(t) {
+			ids = append(ids, event.ID)
+		}
+	}
+	return ids, nil
 }
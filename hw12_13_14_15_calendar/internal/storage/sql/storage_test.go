@@ -46,10 +46,23 @@ func TestStorage(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, int64(2))
 
-	test, _ := events.ListEventsByDay(ctx, tt)
+	test, _ := events.ListEventsByDay(ctx, 11, tt)
 	require.Len(t, test, 2)
 
-	err = events.UpdateEvent(ctx, 1, &common.Event{
+	event, err := events.GetEvent(ctx, 11, 2)
+	require.NoError(t, err)
+	require.Equal(t, event.Title, "Second")
+	_, err = events.GetEvent(ctx, 12, 2)
+	require.ErrorIs(t, err, common.ErrForbidden)
+	_, err = events.GetEvent(ctx, 11, 100)
+	require.ErrorIs(t, err, common.ErrNoSuchEvent)
+
+	err = events.UpdateEvent(ctx, 12, 1, &common.Event{Title: "Stolen"})
+	require.ErrorIs(t, err, common.ErrForbidden)
+	err = events.DeleteEvent(ctx, 11, 100, 0)
+	require.ErrorIs(t, err, common.ErrNoSuchEvent)
+
+	err = events.UpdateEvent(ctx, 11, 1, &common.Event{
 		Title:       "First edited",
 		StartTime:   tt,
 		Duration:    5,
@@ -59,10 +72,14 @@ func TestStorage(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	err = events.DeleteEvent(ctx, 2)
+	err = events.UpdateEvent(ctx, 15, 1, &common.Event{Title: "Stale", Owner: 15, Version: 1})
+	require.ErrorIs(t, err, common.ErrVersionConflict)
+	err = events.DeleteEvent(ctx, 11, 2, 2)
+	require.ErrorIs(t, err, common.ErrVersionConflict)
+	err = events.DeleteEvent(ctx, 11, 2, 1)
 	require.NoError(t, err)
 
-	elems, err := events.ListEventsByDay(ctx, tt)
+	elems, err := events.ListEventsByDay(ctx, 15, tt)
 	require.Len(t, elems, 1)
 	require.NoError(t, err)
 	require.Equal(t, elems[0].Title, "First edited")
@@ -77,20 +94,100 @@ func TestStorage(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id, int64(3))
 
-	_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 6)})
+	_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 6), Owner: 15})
 	require.NoError(t, err)
-	_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 20)})
+	_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 20), Owner: 15})
 	require.NoError(t, err)
-	_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 60)})
+	_, err = events.CreateEvent(ctx, &common.Event{StartTime: tt.AddDate(0, 0, 60), Owner: 15})
 	require.NoError(t, err)
 
-	test, err = events.ListEventsByDay(ctx, tt)
+	test, err = events.ListEventsByDay(ctx, 15, tt)
+	require.NoError(t, err)
+	require.Len(t, test, 1)
+
+	err = events.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := events.CreateEvent(ctx, &common.Event{Title: "'; DROP TABLE events; --", StartTime: tt, Owner: 15}); err != nil {
+			return err
+		}
+		return events.DeleteEvent(ctx, 15, 100, 0)
+	})
+	require.ErrorIs(t, err, common.ErrNoSuchEvent)
+	test, err = events.ListEventsByDay(ctx, 15, tt)
 	require.NoError(t, err)
 	require.Len(t, test, 1)
-	test, err = events.ListEventsByWeek(ctx, tt)
+	test, err = events.ListEventsByWeek(ctx, 15, tt)
 	require.NoError(t, err)
 	require.Len(t, test, 2)
-	test, err = events.ListEventsByMonth(ctx, tt)
+	test, err = events.ListEventsByMonth(ctx, 15, tt)
 	require.NoError(t, err)
 	require.Len(t, test, 3)
+
+	page, next, err := events.ListEvents(ctx, tt, tt.AddDate(0, 1, 0), common.EventFilter{Owner: 15, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	require.Equal(t, page[0].Title, "First edited")
+	page, next, err = events.ListEvents(ctx, tt, tt.AddDate(0, 1, 0), common.EventFilter{Owner: 15, Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	require.Empty(t, next)
+	page, _, err = events.ListEvents(ctx, tt, tt.AddDate(0, 1, 0), common.EventFilter{Owner: 15, Title: "EDITED"})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	id, err = events.CreateEvent(ctx, &common.Event{Title: "Soon", StartTime: now.Add(time.Minute), Reminders: []int32{3600, 300}, Owner: 16})
+	require.NoError(t, err)
+	event, err = events.GetEvent(ctx, 16, id)
+	require.NoError(t, err)
+	require.Equal(t, []int32{3600, 300}, event.Reminders)
+	err = events.WithTx(ctx, func(ctx context.Context) error {
+		due, err := events.ListEventsToNotify(ctx, now)
+		require.NoError(t, err)
+		require.Len(t, due, 2)
+		if err := events.EnqueueNotifications(ctx, append(due, due[0])); err != nil {
+			return err
+		}
+		return events.MarkNotified(ctx, []int64{id}, now)
+	})
+	require.NoError(t, err)
+	due, err := events.ListEventsToNotify(ctx, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Empty(t, due)
+	outbox, err := events.ListOutbox(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, outbox, 2)
+	require.Equal(t, "Soon", outbox[0].Title)
+	require.NoError(t, events.DeleteFromOutbox(ctx, []string{outbox[0].Key, outbox[1].Key}))
+	outbox, err = events.ListOutbox(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, outbox)
+
+	require.ErrorIs(t, events.AddAttendees(ctx, 15, id, []int64{17}), common.ErrForbidden)
+	require.NoError(t, events.AddAttendees(ctx, 16, id, []int64{17, 18}))
+	require.NoError(t, events.SetAttendeeStatus(ctx, id, 17, common.StatusAccepted))
+	require.NoError(t, events.AddAttendees(ctx, 16, id, []int64{17}))
+	require.ErrorIs(t, events.SetAttendeeStatus(ctx, id, 19, common.StatusAccepted), common.ErrNotInvited)
+	event, err = events.GetEvent(ctx, 16, id)
+	require.NoError(t, err)
+	require.Len(t, event.Attendees, 2)
+	require.Equal(t, common.StatusAccepted, event.Attendees[0].Status, "the answer survives a repeated invitation")
+	require.Equal(t, common.StatusPending, event.Attendees[1].Status)
+	invited, err := events.ListInvitedEvents(ctx, 18, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	due, err = events.ListEventsToNotify(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, due, 2, "the owner and the attendee who accepted")
+
+	n, err := events.CountEventsBefore(ctx, tt.AddDate(0, 1, 0))
+	require.NoError(t, err)
+	removed, err := events.DeleteEventsBefore(ctx, tt.AddDate(0, 1, 0), 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), removed)
+	rest, err := events.DeleteEventsBefore(ctx, tt.AddDate(0, 1, 0), 0)
+	require.NoError(t, err)
+	require.Equal(t, n, removed+rest)
+	test, err = events.ListEventsByMonth(ctx, 15, tt)
+	require.NoError(t, err)
+	require.Empty(t, test)
 }
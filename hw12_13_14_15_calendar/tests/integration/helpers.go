@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/common"
 	internalhttp "github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/internal/server/http"
@@ -14,6 +15,12 @@ import (
 type CalendarHTTPApi struct {
 	ConnHTTP *http.Client
 	Host     string
+	Owner    int64
+}
+
+func (a *CalendarHTTPApi) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(internalhttp.OwnerHeader, strconv.FormatInt(a.Owner, 10))
+	return a.ConnHTTP.Do(req)
 }
 
 func (a *CalendarHTTPApi) CreateEvent(ctx context.Context, event common.Event) (int64, int) {
@@ -26,7 +33,7 @@ func (a *CalendarHTTPApi) CreateEvent(ctx context.Context, event common.Event) (
 	if err != nil {
 		return 0, http.StatusInternalServerError
 	}
-	r, err := a.ConnHTTP.Do(req)
+	r, err := a.do(req)
 	if err != nil {
 		return 0, http.StatusInternalServerError
 	}
@@ -52,7 +59,7 @@ func (a *CalendarHTTPApi) DeleteEvent(ctx context.Context, id int64) int {
 	if err != nil {
 		return http.StatusInternalServerError
 	}
-	r, err := a.ConnHTTP.Do(req)
+	r, err := a.do(req)
 	if err != nil {
 		return http.StatusInternalServerError
 	}
@@ -77,7 +84,7 @@ func (a *CalendarHTTPApi) UpdateEvent(ctx context.Context, event common.Event, i
 	if err != nil {
 		return http.StatusInternalServerError
 	}
-	r, err := a.ConnHTTP.Do(req)
+	r, err := a.do(req)
 	if err != nil {
 		return http.StatusInternalServerError
 	}
@@ -97,7 +104,7 @@ func (a *CalendarHTTPApi) ListEventsByDay(ctx context.Context, date string) ([]c
 	if err != nil {
 		return nil, http.StatusInternalServerError
 	}
-	r, err := a.ConnHTTP.Do(req)
+	r, err := a.do(req)
 	if err != nil {
 		return nil, http.StatusInternalServerError
 	}
@@ -121,7 +128,7 @@ func (a *CalendarHTTPApi) ListEventsByWeek(ctx context.Context, date string) ([]
 	if err != nil {
 		return nil, http.StatusInternalServerError
 	}
-	r, err := a.ConnHTTP.Do(req)
+	r, err := a.do(req)
 	if err != nil {
 		return nil, http.StatusInternalServerError
 	}
@@ -145,7 +152,7 @@ func (a *CalendarHTTPApi) ListEventsByMonth(ctx context.Context, date string) ([
 	if err != nil {
 		return nil, http.StatusInternalServerError
 	}
-	r, err := a.ConnHTTP.Do(req)
+	r, err := a.do(req)
 	if err != nil {
 		return nil, http.StatusInternalServerError
 	}
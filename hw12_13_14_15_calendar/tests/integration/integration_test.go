@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -19,10 +20,14 @@ import (
 	"github.com/gerladeno/otus_homeworks/hw12_13_14_15_calendar/tests/integration"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-const retries = 3
+const (
+	retries = 3
+	owner   = 1
+)
 
 type CalendarSuite struct {
 	ctx context.Context
@@ -57,7 +62,7 @@ func (s *CalendarSuite) SetupSuite() {
 	connHTTP.Transport = http.DefaultTransport
 	connHTTP.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 
-	s.clientHTTP = &integration.CalendarHTTPApi{ConnHTTP: &connHTTP, Host: "http://" + calendarHost + ":8888"}
+	s.clientHTTP = &integration.CalendarHTTPApi{ConnHTTP: &connHTTP, Host: "http://" + calendarHost + ":8888", Owner: owner}
 	s.clientGRPC = eventsv1.NewEventsHandlerClient(connGRPC)
 	s.notificationCh = make(chan *common.Notification, 100)
 	s.idsToDelete = make(map[int64]struct{})
@@ -67,6 +72,7 @@ func (s *CalendarSuite) SetupSuite() {
 
 func (s *CalendarSuite) SetupTest() {
 	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.ctx = metadata.AppendToOutgoingContext(s.ctx, internalgrpc.OwnerMetadataKey, strconv.FormatInt(owner, 10))
 }
 
 func (s *CalendarSuite) TearDownTest() {
@@ -91,7 +97,6 @@ func (s *CalendarSuite) TestAddAndListEventsGRPC() {
 		StartTime:   t,
 		Duration:    10,
 		Description: "shitty description",
-		Owner:       1,
 		NotifyTime:  600000000,
 	}
 	id, err := s.clientGRPC.CreateEvent(s.ctx, &eventsv1.CreateEventRequest{Event: internalgrpc.Event2Pb(event)})
@@ -99,25 +104,22 @@ func (s *CalendarSuite) TestAddAndListEventsGRPC() {
 	ids[id.GetId()] = struct{}{}
 	toUpdate := id.GetId()
 
-	event.Owner = 2
 	event.StartTime = event.StartTime.Add(5 * 24 * time.Hour)
 	id, err = s.clientGRPC.CreateEvent(s.ctx, &eventsv1.CreateEventRequest{Event: internalgrpc.Event2Pb(event)})
 	s.Require().NoError(err)
 	ids[id.GetId()] = struct{}{}
 
-	event.Owner = 3
 	event.StartTime = event.StartTime.Add(20 * 24 * time.Hour)
 	id, err = s.clientGRPC.CreateEvent(s.ctx, &eventsv1.CreateEventRequest{Event: internalgrpc.Event2Pb(event)})
 	s.Require().NoError(err)
 	ids[id.GetId()] = struct{}{}
 
-	event.Owner = 4
 	event.StartTime = event.StartTime.Add(20 * 24 * time.Hour)
 	id, err = s.clientGRPC.CreateEvent(s.ctx, &eventsv1.CreateEventRequest{Event: internalgrpc.Event2Pb(event)})
 	s.Require().NoError(err)
 	ids[id.GetId()] = struct{}{}
 
-	event.Owner = 100
+	event.Title = "Rescheduled Event"
 	event.StartTime = t
 	_, err = s.clientGRPC.UpdateEvent(s.ctx, &eventsv1.UpdateEventRequest{Event: internalgrpc.Event2Pb(event), Id: toUpdate})
 	s.Require().NoError(err)
@@ -125,7 +127,8 @@ func (s *CalendarSuite) TestAddAndListEventsGRPC() {
 	events, err := s.clientGRPC.ListEventsByDay(s.ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(t)})
 	s.Require().NoError(err)
 	s.Require().Len(events.GetEvents(), 1)
-	s.Require().Equal(events.GetEvents()[0].GetOwner(), int64(100))
+	s.Require().Equal(events.GetEvents()[0].GetTitle(), "Rescheduled Event")
+	s.Require().Equal(events.GetEvents()[0].GetOwner(), int64(owner))
 
 	events, err = s.clientGRPC.ListEventsByWeek(s.ctx, &eventsv1.ListEventsRequest{FromDate: timestamppb.New(t)})
 	s.Require().NoError(err)
@@ -169,7 +172,6 @@ func (s *CalendarSuite) TestAddAndListEventsHTTP() {
 		StartTime:   t,
 		Duration:    10,
 		Description: "shitty description",
-		Owner:       1,
 		NotifyTime:  600000000,
 	}
 	id, code := s.clientHTTP.CreateEvent(s.ctx, event)
@@ -177,25 +179,22 @@ func (s *CalendarSuite) TestAddAndListEventsHTTP() {
 	ids[id] = struct{}{}
 	toUpdate := id
 
-	event.Owner = 2
 	event.StartTime = event.StartTime.Add(5 * 24 * time.Hour)
 	id, code = s.clientHTTP.CreateEvent(s.ctx, event)
 	s.Require().Equal(code, http.StatusOK)
 	ids[id] = struct{}{}
 
-	event.Owner = 3
 	event.StartTime = event.StartTime.Add(20 * 24 * time.Hour)
 	id, code = s.clientHTTP.CreateEvent(s.ctx, event)
 	s.Require().Equal(code, http.StatusOK)
 	ids[id] = struct{}{}
 
-	event.Owner = 4
 	event.StartTime = event.StartTime.Add(20 * 24 * time.Hour)
 	id, code = s.clientHTTP.CreateEvent(s.ctx, event)
 	s.Require().Equal(code, http.StatusOK)
 	ids[id] = struct{}{}
 
-	event.Owner = 100
+	event.Title = "Rescheduled Event"
 	event.StartTime = t
 	code = s.clientHTTP.UpdateEvent(s.ctx, event, toUpdate)
 	s.Require().Equal(code, http.StatusOK)
@@ -203,7 +202,8 @@ func (s *CalendarSuite) TestAddAndListEventsHTTP() {
 	events, code := s.clientHTTP.ListEventsByDay(s.ctx, t.Format("2006-01-02"))
 	s.Require().Equal(code, http.StatusOK)
 	s.Require().Len(events, 1)
-	s.Require().Equal(events[0].Owner, int64(100))
+	s.Require().Equal(events[0].Title, "Rescheduled Event")
+	s.Require().Equal(events[0].Owner, int64(owner))
 
 	events, code = s.clientHTTP.ListEventsByWeek(s.ctx, t.Format("2006-01-02"))
 	s.Require().Equal(code, http.StatusOK)